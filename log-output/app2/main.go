@@ -47,7 +47,9 @@ func main() {
 }
 
 func setupRouter(logFName string, pongAppUrl string) *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(recovery))
+	router.NoRoute(noRoute)
 
 	router.GET("/", func(c *gin.Context) {
 		c.Redirect(http.StatusMovedPermanently, "/log")
@@ -56,7 +58,7 @@ func setupRouter(logFName string, pongAppUrl string) *gin.Engine {
 	router.GET("/log", func(c *gin.Context) {
 		fp, err := os.OpenFile(logFName, os.O_RDONLY, 0644)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error opening log file: %v", err)
+			abortWithInternalError(c, "cannot open the log file", err)
 			return
 		}
 		defer fp.Close()
@@ -73,7 +75,7 @@ func setupRouter(logFName string, pongAppUrl string) *gin.Engine {
 		response, err4 := http.Get(pongAppUrl)
 
 		if err3 != nil || err4 != nil {
-			c.String(http.StatusInternalServerError, "Error reading file or making HTTP request: %v %v", err3, err4)
+			abortWithInternalError(c, "cannot read the log or reach the pong app", errors.Join(err3, err4))
 			return
 		}
 
@@ -86,7 +88,7 @@ func setupRouter(logFName string, pongAppUrl string) *gin.Engine {
 		counter_data := buf[:datalength]
 
 		if err4 != nil && !errors.Is(err4, io.EOF) {
-			c.String(http.StatusInternalServerError, "Error reading counter data: %v", err4)
+			abortWithInternalError(c, "cannot read the ping / pong counter", err4)
 			return
 		}
 
@@ -94,5 +96,7 @@ func setupRouter(logFName string, pongAppUrl string) *gin.Engine {
 
 		c.String(http.StatusOK, message)
 	})
+	router.GET("/healthz", healthHandler(livenessChecks()))
+	router.GET("/readyz", healthHandler(readinessChecks(logFName, pongAppUrl)))
 	return router
 }
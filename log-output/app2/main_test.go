@@ -56,3 +56,46 @@ func TestGetLog(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Contains(t, w.Body.String(), "Test log content\nPing / Pongs: 42")
 }
+
+func TestGetLogMissingFileIsProblem(t *testing.T) {
+	router := setupRouter("/nonexistent/log.txt", "http://127.0.0.1:1")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/log", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"instance":"/log"`)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	testRouter.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"log":{"status":"ok"`)
+	assert.Contains(t, w.Body.String(), `"pong":{"status":"ok"`)
+}
+
+func TestNotReadyWithoutDependencies(t *testing.T) {
+	// Neither the log file nor the pong service is there
+	router := setupRouter("/nonexistent/log.txt", "http://127.0.0.1:1")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"log":{"status":"fail","error":"stat /nonexistent/log.txt: no such file or directory"`)
+	assert.Contains(t, w.Body.String(), `"pong":{"status":"fail"`)
+
+	// Liveness does not depend on them
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors are reported as RFC 7807 problem details, the same model the project services use.
+
+const problemContentType = "application/problem+json"
+
+// ProblemTypeDefault is used when the HTTP status code says all there is to say.
+const ProblemTypeDefault = "about:blank"
+
+// FieldError describes a validation failure of a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// NewProblem returns a problem of the default type for the given status.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   ProblemTypeDefault,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// abortWithProblem writes the problem as the response and stops the handler chain.
+func abortWithProblem(c *gin.Context, p *Problem) {
+	if p.Instance == "" && c.Request != nil {
+		p.Instance = c.Request.URL.Path
+	}
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// abortWithInternalError logs the cause of a 500 and answers with a generic problem, so
+// file paths and internal errors do not reach clients.
+func abortWithInternalError(c *gin.Context, detail string, err error) {
+	if c.Request != nil {
+		log.Printf("%s %s: %s: %v", c.Request.Method, c.Request.URL.Path, detail, err)
+	} else {
+		log.Printf("%s: %v", detail, err)
+	}
+	abortWithProblem(c, NewProblem(http.StatusInternalServerError, detail))
+}
+
+func noRoute(c *gin.Context) {
+	abortWithProblem(c, NewProblem(http.StatusNotFound, "no such route"))
+}
+
+func recovery(c *gin.Context, err any) {
+	abortWithProblem(c, NewProblem(http.StatusInternalServerError, "internal server error"))
+}
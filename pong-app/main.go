@@ -44,7 +44,10 @@ func main() {
 }
 
 func setupRouter(fname string) *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	// incrCounter panics if the counter file cannot be written; report that as a problem document
+	router.Use(gin.Logger(), gin.CustomRecovery(recovery))
+	router.NoRoute(noRoute)
 
 	router.GET("/pingpong", func(c *gin.Context) {
 		c.String(http.StatusOK, incrCounter(fname))
@@ -55,6 +58,8 @@ func setupRouter(fname string) *gin.Engine {
 		counterMutex.RUnlock()
 		c.String(http.StatusOK, value)
 	})
+	router.GET("/healthz", healthHandler(livenessChecks()))
+	router.GET("/readyz", healthHandler(readinessChecks(fname)))
 	return router
 }
 
@@ -98,6 +103,8 @@ func initCounter(fname string) int {
 
 func incrCounter(fname string) string {
 	counterMutex.Lock()
+	// Deferred so that a panic below does not leave the mutex locked for the recovered server
+	defer counterMutex.Unlock()
 	counter++
 	value := strconv.Itoa(counter)
 
@@ -131,7 +138,6 @@ func incrCounter(fname string) string {
 			panic(err)
 		}
 	}()
-	counterMutex.Unlock()
 
 	return "pong " + value
 }
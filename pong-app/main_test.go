@@ -154,3 +154,65 @@ func TestPongsOnePingEndpoint(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "1", w.Body.String())
 }
+
+func TestCounterWriteFailureIsProblem(t *testing.T) {
+	// A directory cannot be truncated into a counter file, so incrCounter panics
+	router := setupRouter(t.TempDir())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/pingpong", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"status":500`)
+}
+
+func TestUnknownRouteIsProblem(t *testing.T) {
+	router, _ := setupTestRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/nope", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	router, _ := setupTestRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"counter":{"status":"ok"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"counter-file":{"status":"ok"`)
+}
+
+func TestNotLiveWithStuckCounterLock(t *testing.T) {
+	router := setupRouter(filepath.Join(t.TempDir(), "counter.txt"))
+	counterMutex.Lock()
+	defer counterMutex.Unlock()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"counter":{"status":"fail"`)
+}
+
+func TestNotReadyWithoutCounterDirectory(t *testing.T) {
+	// The counter cannot be written when its directory is gone
+	router := setupRouter(filepath.Join(t.TempDir(), "gone", "counter.txt"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"fail"`)
+	assert.Contains(t, w.Body.String(), "no such file or directory")
+}
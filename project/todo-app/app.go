@@ -125,7 +125,7 @@ func (app *App) GetImage(c *gin.Context) {
 	// NO --> return 503
 	if app.ImageFetchedFromBackendAt.IsZero() {
 		c.Writer.Header().Set("Retry-After", "10")
-		c.String(http.StatusServiceUnavailable, "The image it is being fetched, please try again later")
+		abortWithProblem(c, NewProblem(http.StatusServiceUnavailable, "The image it is being fetched, please try again later"))
 		return
 	}
 
@@ -137,7 +137,7 @@ func (app *App) GetImage(c *gin.Context) {
 		// Is the image too old and is being fetched?
 		if age > app.MaxAge+app.GracePeriod {
 			c.Writer.Header().Set("Retry-After", "10")
-			c.String(http.StatusServiceUnavailable, "Image is too old and it is being fetched, please try again later")
+			abortWithProblem(c, NewProblem(http.StatusServiceUnavailable, "Image is too old and it is being fetched, please try again later"))
 			return
 		}
 
@@ -151,7 +151,7 @@ func (app *App) GetImage(c *gin.Context) {
 				app.IsGracePeriodUsed = true
 			} else {
 				c.Writer.Header().Set("Retry-After", "10")
-				c.String(http.StatusServiceUnavailable, "Grace fetch already used. Image is being fetched, please try again later")
+				abortWithProblem(c, NewProblem(http.StatusServiceUnavailable, "Grace fetch already used. Image is being fetched, please try again later"))
 				return
 			}
 		}
@@ -167,7 +167,7 @@ func (app *App) GetImage(c *gin.Context) {
 
 	imageData, err := readImage(app.ImagePath)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to read image: %v", err)
+		abortWithInternalError(c, "cannot read the image", err)
 		return
 	}
 
@@ -176,7 +176,8 @@ func (app *App) GetImage(c *gin.Context) {
 	c.Writer.WriteHeader(http.StatusOK)
 	_, err = c.Writer.Write([]byte(imageData))
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to write image: %v", err)
+		// Headers are already sent, so the client cannot be told anymore
+		logger.Println("Failed to write image:", err)
 	}
 }
 
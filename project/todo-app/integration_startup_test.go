@@ -26,7 +26,7 @@ func TestSetupRouter(t *testing.T) {
 	)
 	router := setupRouter(app)
 
-	assert.Equal(t, 4, len(router.Routes())) // We have four routes defined
+	assert.Equal(t, 6, len(router.Routes())) // We have six routes defined, including the probes
 	assert.NotNil(t, router)
 }
 
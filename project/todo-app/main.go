@@ -81,12 +81,16 @@ func main() {
 }
 
 func setupRouter(app *App) *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(recovery))
+	router.NoRoute(noRoute)
 	router.LoadHTMLGlob("templates/*")
 
 	router.GET("/", app.GetIndex)
 	router.GET("/images/image.jpg", app.GetImage)
 	router.Static("/static", "./static")
+	router.GET("/healthz", healthHandler(app.livenessChecks()))
+	router.GET("/readyz", healthHandler(app.readinessChecks()))
 
 	// Add more routes here, using app methods
 	return router
@@ -12,7 +12,7 @@ describe('todo API helpers', () => {
 
     const res = await fetchTodos();
     expect(res).toEqual(mock);
-    expect(globalThis.fetch).toHaveBeenCalledWith('/todos');
+    expect(globalThis.fetch).toHaveBeenCalledWith('/api/v1/todos');
   });
 
   it('addTodo posts data and returns created todo', async () => {
@@ -21,7 +21,7 @@ describe('todo API helpers', () => {
 
     const res = await addTodo('b');
     expect(res).toEqual(created);
-    expect(globalThis.fetch).toHaveBeenCalledWith('/todos', expect.objectContaining({ method: 'POST' }));
+    expect(globalThis.fetch).toHaveBeenCalledWith('/api/v1/todos', expect.objectContaining({ method: 'POST' }));
   });
 
   it('deleteTodo sends DELETE request', async () => {
@@ -29,15 +29,15 @@ describe('todo API helpers', () => {
 
     const todoId = '3';
     await deleteTodo(todoId);
-    expect(globalThis.fetch).toHaveBeenCalledWith(`/todos/${todoId}`, expect.objectContaining({ method: 'DELETE' }));
+    expect(globalThis.fetch).toHaveBeenCalledWith(`/api/v1/todos/${todoId}`, expect.objectContaining({ method: 'DELETE' }));
   });
 
-  it('updateTodo sends PUT request and returns updated todo', async () => {
+  it('updateTodo sends PATCH request and returns updated todo', async () => {
     const updated = { uuid: '4', description: 'c updated', createdAt: '2025-01-01T00:00:00Z' };
     globalThis.fetch = vi.fn().mockResolvedValue({ ok: true, json: async () => updated } as any);
 
     const res = await updateTodo('4', 'c updated');
     expect(res).toEqual(updated);
-    expect(globalThis.fetch).toHaveBeenCalledWith('/todos/4', expect.objectContaining({ method: 'PUT' }));
+    expect(globalThis.fetch).toHaveBeenCalledWith('/api/v1/todos/4', expect.objectContaining({ method: 'PATCH' }));
   });
 });
@@ -5,6 +5,9 @@
 
 /* eslint-disable @typescript-eslint/no-explicit-any */
 
+/* The client is pinned to v1 of the API, so changes to the todo shape in later
+ * versions cannot break it. */
+export const API_BASE = "/api/v1";
 
 /* Type definition for a Todo item */
 export type Todo = {
@@ -18,7 +21,7 @@ export type Todo = {
  * @returns A promise that resolves to an array of todos
  */
 export async function fetchTodos(): Promise<Todo[]> {
-  const res = await fetch("/todos");
+  const res = await fetch(`${API_BASE}/todos`);
   return res.json();
 }
 
@@ -28,7 +31,7 @@ export async function fetchTodos(): Promise<Todo[]> {
  * @returns A promise that resolves to the created todo
  */
 export async function addTodo(description: string): Promise<Todo> {
-  const res = await fetch("/todos", {
+  const res = await fetch(`${API_BASE}/todos`, {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
@@ -44,7 +47,7 @@ export async function addTodo(description: string): Promise<Todo> {
  * @returns A promise that resolves when the todo is deleted
  */
 export async function deleteTodo(uuid: string): Promise<void> {
-  await fetch(`/todos/${uuid}`, {
+  await fetch(`${API_BASE}/todos/${uuid}`, {
     method: "DELETE",
   });
 }
@@ -56,8 +59,8 @@ export async function deleteTodo(uuid: string): Promise<void> {
  * @returns A promise that resolves to the updated todo
  */
 export async function updateTodo(uuid: string, description: string): Promise<Todo> {
-  const res = await fetch(`/todos/${uuid}`, {
-    method: "PUT",
+  const res = await fetch(`${API_BASE}/todos/${uuid}`, {
+    method: "PATCH",
     headers: {
       "Content-Type": "application/json",
     },
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
@@ -283,6 +284,11 @@ func TestGetImageCases(t *testing.T) {
 			if !tc.expectErr {
 				assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
 				assert.Equal(t, testImage, w.Body.Bytes(), "Response body should match the test image content")
+			} else {
+				assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+				var p Problem
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p), "Error response should be a problem document")
+				assert.Equal(t, tc.expectHTTPStatusCode, p.Status)
 			}
 			tc.assertions(t, mockReader)
 			mockReader.AssertExpectations(t)
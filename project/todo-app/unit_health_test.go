@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *testing.T, app *App, path string) (int, map[string]any) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	setupRouter(app).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func checkStatus(body map[string]any, name string) any {
+	return body["checks"].(map[string]any)[name].(map[string]any)["status"]
+}
+
+func TestHealthProbes(t *testing.T) {
+	dir := t.TempDir()
+	app := NewApp(filepath.Join(dir, "image.jpg"), "http://127.0.0.1:1", 10*time.Minute, time.Minute, time.Second)
+
+	code, body := probe(t, app, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", checkStatus(body, "app"))
+
+	// Not ready until an image has been fetched
+	code, body = probe(t, app, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", body["status"])
+	assert.Equal(t, "fail", checkStatus(body, "image"))
+	assert.Equal(t, "ok", checkStatus(body, "cache"))
+
+	app.ImageFetchedFromBackendAt = time.Now()
+	code, body = probe(t, app, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+
+	// Nor when the cache is gone
+	require.NoError(t, os.RemoveAll(dir))
+	code, body = probe(t, app, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", checkStatus(body, "cache"))
+	assert.Contains(t, body["checks"].(map[string]any)["cache"].(map[string]any)["error"], "no such file or directory")
+}
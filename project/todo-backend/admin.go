@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Routes under /admin are for the operators of todo-backend. Admins are the users listed
+// in the comma separated ADMIN_USERS, identified like everyone else by the proxy in front
+// of todo-backend. Without ADMIN_USERS nobody can use the admin routes.
+
+// adminsFromEnv returns the set of users in ADMIN_USERS.
+func adminsFromEnv() map[string]bool {
+	admins := map[string]bool{}
+	for _, user := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			admins[user] = true
+		}
+	}
+	return admins
+}
+
+// requireAdmin rejects requests of users who are not admins.
+func requireAdmin(admins map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !admins[requestIdentity(c)] {
+			abortWithProblem(c, NewProblem(http.StatusForbidden, "admin rights are required"))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// The todo routes are served in two versions, so changing the shape of a todo does not
+// break clients such as the TS frontend:
+//
+//	/api/v1/todos...  the original shape
+//	/api/v2/todos...  the original fields plus overdue and the URLs of the todo
+//
+// Both versions share the handlers and differ only in the mapper turning a Todo into the
+// response. The legacy /todos routes serve v1 and are deprecated: their responses carry
+// Deprecation, Sunset and a Link to the /api/v1 route replacing them.
+//
+// openapi.yaml describes the todo routes once, under /todos; expandAPIVersions derives
+// the /api/v1 and /api/v2 paths from them when the spec is loaded.
+//
+// Design choice: only the todo routes are versioned so far. The other routes keep their
+// single, unversioned path until their shape needs to change.
+
+const apiVersionKey = "apiVersion"
+
+// The legacy /todos routes are deprecated as of the introduction of /api/v1 and go away
+// at legacySunset.
+var (
+	legacyDeprecatedAt = time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	legacySunset       = time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// apiVersion maps todos to the response shape of one version of the API, and to the
+// columns of its CSV representation.
+type apiVersion struct {
+	prefix    string
+	todo      func(prefix string, t Todo, now time.Time) any
+	csvHeader []string
+	csvRow    func(prefix string, t Todo, now time.Time) []string
+}
+
+var (
+	apiV1 = &apiVersion{prefix: "/api/v1", todo: todoV1, csvHeader: todoCSVHeaderV1, csvRow: todoCSVRowV1}
+	apiV2 = &apiVersion{prefix: "/api/v2", todo: todoV2, csvHeader: todoCSVHeaderV2, csvRow: todoCSVRowV2}
+)
+
+var (
+	todoCSVHeaderV1 = []string{"uuid", "description", "owner", "state", "done", "due_at", "completed_at",
+		"created_at", "changed_at", "comment_count", "rrule", "recurrence_start", "next_uuid", "links"}
+	todoCSVHeaderV2 = append(append([]string{}, todoCSVHeaderV1...), "overdue", "url")
+)
+
+// TodoV2 is the v2 shape of a todo.
+type TodoV2 struct {
+	Todo
+	// Overdue is set for open todos past their due date.
+	Overdue bool     `json:"overdue"`
+	URLs    TodoURLs `json:"urls"`
+}
+
+// TodoURLs are the paths of a todo and its sub-resources.
+type TodoURLs struct {
+	Self        string `json:"self"`
+	Comments    string `json:"comments"`
+	Attachments string `json:"attachments"`
+	Occurrences string `json:"occurrences"`
+}
+
+func todoV1(_ string, t Todo, _ time.Time) any {
+	return t
+}
+
+// todoCSVRowV1 has the columns of todoCSVHeaderV1. Links are separated by spaces.
+func todoCSVRowV1(_ string, t Todo, _ time.Time) []string {
+	links := make([]string, len(t.Links))
+	for i, l := range t.Links {
+		links[i] = l.URL
+	}
+	return []string{t.UUID, t.Description, t.Owner, t.State, strconv.FormatBool(t.Done),
+		csvTime(t.DueAt), csvTime(t.CompletedAt), csvTime(&t.CreatedAt), csvTime(&t.ChangedAt),
+		strconv.Itoa(t.CommentCount), t.RRule, csvTime(t.RecurrenceStart), t.NextUUID,
+		strings.Join(links, " ")}
+}
+
+func todoCSVRowV2(prefix string, t Todo, now time.Time) []string {
+	v2 := todoV2(prefix, t, now).(TodoV2)
+	return append(todoCSVRowV1(prefix, t, now), strconv.FormatBool(v2.Overdue), v2.URLs.Self)
+}
+
+func todoV2(prefix string, t Todo, now time.Time) any {
+	self := prefix + "/todos/" + t.UUID
+	return TodoV2{
+		Todo:    t,
+		Overdue: isOverdue(t, now),
+		URLs: TodoURLs{
+			Self:        self,
+			Comments:    self + "/comments",
+			Attachments: self + "/attachments",
+			Occurrences: self + "/occurrences",
+		},
+	}
+}
+
+// isOverdue reports whether an open todo is past its due date.
+func isOverdue(t Todo, now time.Time) bool {
+	return !t.Done && t.DueAt != nil && t.DueAt.Before(now)
+}
+
+// useAPIVersion selects the response shape of the routes of a group.
+func useAPIVersion(v *apiVersion) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiVersionKey, v)
+		c.Next()
+	}
+}
+
+// requestAPIVersion returns the version of the request, v1 for the legacy routes.
+func requestAPIVersion(c *gin.Context) *apiVersion {
+	if v, ok := c.Get(apiVersionKey); ok {
+		return v.(*apiVersion)
+	}
+	return apiV1
+}
+
+// deprecatedRoute marks the responses of a legacy route as deprecated (RFC 9745) with
+// the date it goes away (RFC 8594) and its successor under /api/v1.
+func deprecatedRoute(deprecatedAt, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+		c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		c.Header("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiV1.prefix, c.Request.URL.Path))
+		c.Next()
+	}
+}
+
+// renderTodo writes a todo in the shape of the request's API version, in the
+// negotiated media type.
+func renderTodo(c *gin.Context, status int, t Todo) {
+	renderTodoList(c, status, []Todo{t}, true)
+}
+
+// renderTodos writes a list of todos in the shape of the request's API version, in the
+// negotiated media type.
+func renderTodos(c *gin.Context, status int, todos []Todo) {
+	renderTodoList(c, status, todos, false)
+}
+
+func renderTodoList(c *gin.Context, status int, todos []Todo, single bool) {
+	v := requestAPIVersion(c)
+	now := time.Now()
+	var value any = mapTodos(v, todos, now)
+	if single {
+		value = v.todo(v.prefix, todos[0], now)
+	}
+	respond(c, status, value, func() [][]string {
+		rows := [][]string{v.csvHeader}
+		for _, t := range todos {
+			rows = append(rows, v.csvRow(v.prefix, t, now))
+		}
+		return rows
+	})
+}
+
+// renderTodoDiff writes a diff with the todos in the shape of the request's API version.
+func renderTodoDiff(c *gin.Context, status int, d TodoDiff) {
+	v := requestAPIVersion(c)
+	now := time.Now()
+	type change struct {
+		UUID   string   `json:"uuid"`
+		Fields []string `json:"fields"`
+		Before any      `json:"before"`
+		After  any      `json:"after"`
+	}
+	changed := make([]change, len(d.Changed))
+	for i, ch := range d.Changed {
+		changed[i] = change{ch.UUID, ch.Fields, v.todo(v.prefix, ch.Before, now), v.todo(v.prefix, ch.After, now)}
+	}
+	c.JSON(status, gin.H{
+		"from":    d.From,
+		"to":      d.To,
+		"added":   mapTodos(v, d.Added, now),
+		"changed": changed,
+		"removed": mapTodos(v, d.Removed, now),
+	})
+}
+
+// renderTodoChanges writes a page of the change feed with the todos in the shape of the
+// request's API version.
+func renderTodoChanges(c *gin.Context, status int, changes TodoChanges) {
+	v := requestAPIVersion(c)
+	now := time.Now()
+	type change struct {
+		Seq    int64         `json:"seq"`
+		At     time.Time     `json:"at"`
+		Type   TodoEventType `json:"type"`
+		Todo   any           `json:"todo"`
+		Fields []string      `json:"fields,omitempty"`
+	}
+	out := make([]change, len(changes.Changes))
+	for i, ch := range changes.Changes {
+		out[i] = change{ch.Seq, ch.At, ch.Type, v.todo(v.prefix, ch.Todo, now), ch.Fields}
+	}
+	c.JSON(status, gin.H{
+		"changes": out,
+		"next":    changes.Next,
+		"more":    changes.More,
+		"missed":  changes.Missed,
+	})
+}
+
+func mapTodos(v *apiVersion, todos []Todo, now time.Time) []any {
+	out := make([]any, len(todos))
+	for i, t := range todos {
+		out[i] = v.todo(v.prefix, t, now)
+	}
+	return out
+}
+
+// versionedSchemas are the schemas replaced in the v2 copies of the todo routes.
+var versionedSchemas = map[string]string{
+	"#/components/schemas/Todo":        "#/components/schemas/TodoV2",
+	"#/components/schemas/TodoDiff":    "#/components/schemas/TodoDiffV2",
+	"#/components/schemas/TodoChanges": "#/components/schemas/TodoChangesV2",
+}
+
+// expandAPIVersions adds the /api/v1 and /api/v2 copies of the /todos paths to the
+// OpenAPI document, and marks the /todos paths deprecated.
+func expandAPIVersions(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	paths := mappingValue(doc.Content[0], "paths")
+	if paths == nil {
+		return nil, fmt.Errorf("no paths")
+	}
+
+	var v1, v2 []*yaml.Node
+	for i := 0; i < len(paths.Content); i += 2 {
+		key, item := paths.Content[i], paths.Content[i+1]
+		if key.Value != "/todos" && !strings.HasPrefix(key.Value, "/todos/") {
+			continue
+		}
+		v1 = append(v1, versionedPath(apiV1.prefix+key.Value, item, "V1", nil)...)
+		v2 = append(v2, versionedPath(apiV2.prefix+key.Value, item, "V2", versionedSchemas)...)
+		for _, op := range operations(item) {
+			op.Content = append(op.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Value: "deprecated"},
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+		}
+	}
+	paths.Content = append(append(paths.Content, v1...), v2...)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), enc.Close()
+}
+
+// versionedPath returns the key and a copy of the path item for a versioned path, with
+// the operation ids suffixed and the schema references replaced.
+func versionedPath(path string, item *yaml.Node, suffix string, schemas map[string]string) []*yaml.Node {
+	clone := cloneNode(item)
+	for _, op := range operations(clone) {
+		if id := mappingValue(op, "operationId"); id != nil {
+			id.Value += suffix
+		}
+	}
+	replaceRefs(clone, schemas)
+	return []*yaml.Node{{Kind: yaml.ScalarNode, Value: path}, clone}
+}
+
+// operations returns the operation objects of a path item.
+func operations(item *yaml.Node) []*yaml.Node {
+	var ops []*yaml.Node
+	for _, method := range []string{"get", "post", "put", "patch", "delete"} {
+		if op := mappingValue(item, method); op != nil {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// mappingValue returns the value of key in a YAML mapping, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func cloneNode(n *yaml.Node) *yaml.Node {
+	clone := *n
+	clone.Content = make([]*yaml.Node, len(n.Content))
+	for i, child := range n.Content {
+		clone.Content[i] = cloneNode(child)
+	}
+	return &clone
+}
+
+func replaceRefs(n *yaml.Node, schemas map[string]string) {
+	for i := 0; i+1 < len(n.Content); i++ {
+		if n.Kind == yaml.MappingNode && i%2 == 0 && n.Content[i].Value == "$ref" {
+			if to, ok := schemas[n.Content[i+1].Value]; ok {
+				n.Content[i+1].Value = to
+			}
+		}
+	}
+	for _, child := range n.Content {
+		replaceRefs(child, schemas)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIVersions_Shapes(t *testing.T) {
+	s := &TodoMgr{}
+	past := time.Now().Add(-time.Hour)
+	todo, err := s.Create("", TodoInput{Description: "late", DueAt: &past})
+	require.NoError(t, err)
+	router := setupRouter(s)
+
+	get := func(path string) (*httptest.ResponseRecorder, []map[string]any) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, w.Code, path)
+		var todos []map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &todos))
+		require.Len(t, todos, 1)
+		return w, todos
+	}
+
+	legacy, legacyTodos := get("/todos")
+	v1, v1Todos := get("/api/v1/todos")
+	assert.Equal(t, legacyTodos, v1Todos)
+	assert.NotContains(t, v1Todos[0], "overdue")
+
+	_, v2Todos := get("/api/v2/todos")
+	assert.Equal(t, true, v2Todos[0]["overdue"])
+	assert.Equal(t, "/api/v2/todos/"+todo.UUID+"/comments", v2Todos[0]["urls"].(map[string]any)["comments"])
+	for key, value := range v1Todos[0] {
+		assert.Equal(t, value, v2Todos[0][key], key)
+	}
+
+	// Only the legacy routes are deprecated
+	assert.Equal(t, "@1792281600", legacy.Header().Get("Deprecation"))
+	assert.Equal(t, "Fri, 30 Apr 2027 00:00:00 GMT", legacy.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v1/todos>; rel="successor-version"`, legacy.Header().Get("Link"))
+	assert.Empty(t, v1.Header().Get("Deprecation"))
+	assert.Empty(t, v1.Header().Get("Sunset"))
+}
+
+func TestAPIVersions_SharedHandlers(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodPost, "/api/v2/todos", `{"description":"via v2"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created TodoV2
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.False(t, created.Overdue)
+	assert.Equal(t, "/api/v2/todos/"+created.UUID, created.URLs.Self)
+
+	// The same todo through the other versions
+	w = request(http.MethodPatch, "/api/v1/todos/"+created.UUID, `{"done":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "urls")
+	w = request(http.MethodPost, "/todos/"+created.UUID+"/transition", `{"to":"backlog"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Deprecation"))
+
+	w = request(http.MethodGet, "/api/v2/todos/diff?from=2000-01-01T00:00:00Z", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var diff TodoDiff
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	require.Len(t, diff.Added, 1)
+	assert.Contains(t, w.Body.String(), `"overdue":false`)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodDelete, "/api/v2/todos", "").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/api/v2/todos/"+created.UUID, "").Code)
+	assert.Empty(t, s.List())
+}
+
+func TestAPIVersions_Spec(t *testing.T) {
+	spec := mustLoadOpenAPISpec()
+
+	legacy := spec.Paths.Find("/todos/{uuid}")
+	require.NotNil(t, legacy)
+	assert.True(t, legacy.Patch.Deprecated)
+	assert.Equal(t, "patchTodo", legacy.Patch.OperationID)
+
+	v1 := spec.Paths.Find("/api/v1/todos/{uuid}")
+	require.NotNil(t, v1)
+	assert.False(t, v1.Patch.Deprecated)
+	assert.Equal(t, "patchTodoV1", v1.Patch.OperationID)
+	assert.Equal(t, "#/components/schemas/Todo", v1.Patch.Responses.Status(http.StatusOK).Value.Content.Get("application/json").Schema.Ref)
+
+	v2 := spec.Paths.Find("/api/v2/todos/{uuid}")
+	require.NotNil(t, v2)
+	assert.Equal(t, "#/components/schemas/TodoV2", v2.Patch.Responses.Status(http.StatusOK).Value.Content.Get("application/json").Schema.Ref)
+
+	// Routes outside /todos are not versioned
+	assert.Nil(t, spec.Paths.Find("/api/v1/templates"))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Attachments are stored on the persistent volume, one directory per todo:
+//
+//	<ATTACHMENTS_DIR>/<todo uuid>/<attachment id>       file contents
+//	<ATTACHMENTS_DIR>/<todo uuid>/<attachment id>.json  metadata
+//
+// Both files are written to a temp file first and renamed into place, like todo-app's
+// saveImage does, so a crash never leaves a half written attachment behind. The data file
+// is renamed before the metadata, and only attachments with metadata are listed. With
+// encryption keys configured both files are encrypted (see encryption.go), and bound to
+// their todo and id.
+//
+// Design choice: uploads are read into memory before they are written, so unencrypted
+// data never touches the disk. MaxFileSize bounds the memory an upload takes.
+//
+// The content type is sniffed from the data instead of trusting the client, and downloads
+// are always served with Content-Disposition: attachment so browsers do not render them.
+
+// Defaults for the env variables read in NewAttachmentStoreFromEnv.
+const (
+	defaultAttachmentsDir        = "/app/data/attachments"
+	defaultMaxAttachmentSize     = 10 << 20 // 10 MiB
+	defaultMaxAttachmentsPerTodo = 50 << 20 // 50 MiB
+)
+
+// ErrAttachmentNotFound is returned when a todo has no attachment with the given id.
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// ErrAttachmentTooLarge is returned when a file exceeds the per-file or per-todo size limit.
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+// Attachment is the metadata of a file attached to a todo.
+type Attachment struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttachmentStore keeps attachments under a directory on disk.
+type AttachmentStore struct {
+	// mu serialises the size check and the rename of uploads, so concurrent
+	// uploads cannot exceed the per-todo limit together.
+	mu          sync.Mutex
+	dir         string
+	MaxFileSize int64
+	MaxTodoSize int64
+	// Keys encrypts the files. Nil stores them unencrypted.
+	Keys *Keyring
+}
+
+func NewAttachmentStore(dir string, maxFileSize, maxTodoSize int64) (*AttachmentStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &AttachmentStore{dir: dir, MaxFileSize: maxFileSize, MaxTodoSize: maxTodoSize}, nil
+}
+
+// NewAttachmentStoreFromEnv configures the store from ATTACHMENTS_DIR,
+// ATTACHMENT_MAX_SIZE and ATTACHMENTS_MAX_PER_TODO (sizes in bytes).
+func NewAttachmentStoreFromEnv() (*AttachmentStore, error) {
+	dir := os.Getenv("ATTACHMENTS_DIR")
+	if dir == "" {
+		dir = defaultAttachmentsDir
+	}
+	return NewAttachmentStore(dir,
+		sizeFromEnv("ATTACHMENT_MAX_SIZE", defaultMaxAttachmentSize),
+		sizeFromEnv("ATTACHMENTS_MAX_PER_TODO", defaultMaxAttachmentsPerTodo))
+}
+
+// sizeFromEnv reads a positive byte count from the environment variable, falling back to def.
+func sizeFromEnv(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// todoDir returns the directory of the todo's attachments. The UUID must be
+// one TodoMgr generated, so it cannot escape the store directory.
+func (st *AttachmentStore) todoDir(todoUUID string) string {
+	return filepath.Join(st.dir, todoUUID)
+}
+
+// List returns the attachments of the todo, oldest first.
+func (st *AttachmentStore) List(todoUUID string) ([]Attachment, error) {
+	entries, err := os.ReadDir(st.todoDir(todoUUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Attachment{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []Attachment{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || uuid.Validate(id) != nil {
+			continue
+		}
+		a, err := st.Get(todoUUID, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out, nil
+}
+
+// Get returns the metadata of an attachment.
+func (st *AttachmentStore) Get(todoUUID, id string) (Attachment, error) {
+	if uuid.Validate(id) != nil {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	data, err := readSealedFile(st.Keys, filepath.Join(st.todoDir(todoUUID), id+".json"), metadataContext(todoUUID, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return Attachment{}, err
+	}
+	var a Attachment
+	if err := json.Unmarshal(data, &a); err != nil {
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+// Open returns the metadata and the contents of an attachment.
+func (st *AttachmentStore) Open(todoUUID, id string) (Attachment, []byte, error) {
+	a, err := st.Get(todoUUID, id)
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	data, err := readSealedFile(st.Keys, filepath.Join(st.todoDir(todoUUID), id), dataContext(todoUUID, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Attachment{}, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return Attachment{}, nil, err
+	}
+	return a, data, nil
+}
+
+// Save stores the contents of r as a new attachment of the todo.
+func (st *AttachmentStore) Save(todoUUID, filename string, r io.Reader) (Attachment, error) {
+	dir := st.todoDir(todoUUID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return Attachment{}, err
+	}
+
+	a := Attachment{
+		ID:        uuid.New().String(),
+		Filename:  sanitizeFilename(filename),
+		CreatedAt: time.Now().UTC(),
+	}
+	dataPath := filepath.Join(dir, a.ID)
+
+	// Read one byte more than allowed to detect oversized files
+	data, err := io.ReadAll(io.LimitReader(r, st.MaxFileSize+1))
+	if err != nil {
+		return Attachment{}, err
+	}
+	if int64(len(data)) > st.MaxFileSize {
+		return Attachment{}, ErrAttachmentTooLarge
+	}
+	a.Size = int64(len(data))
+	a.ContentType = http.DetectContentType(data)
+	meta, err := json.Marshal(a)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	existing, err := st.List(todoUUID)
+	if err != nil {
+		return Attachment{}, err
+	}
+	total := a.Size
+	for _, e := range existing {
+		total += e.Size
+	}
+	if total > st.MaxTodoSize {
+		return Attachment{}, ErrAttachmentTooLarge
+	}
+
+	if err := writeSealedFile(st.Keys, dataPath, dataContext(todoUUID, a.ID), data); err != nil {
+		return Attachment{}, err
+	}
+	if err := writeSealedFile(st.Keys, filepath.Join(dir, a.ID+".json"), metadataContext(todoUUID, a.ID), meta); err != nil {
+		os.Remove(dataPath)
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+// Delete removes an attachment. The metadata goes first, so a failure in between
+// leaves an unlisted data file rather than a listed attachment without data.
+func (st *AttachmentStore) Delete(todoUUID, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, err := st.Get(todoUUID, id); err != nil {
+		return err
+	}
+	dir := st.todoDir(todoUUID)
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RemoveAll removes all attachments of a todo.
+func (st *AttachmentStore) RemoveAll(todoUUID string) error {
+	return os.RemoveAll(st.todoDir(todoUUID))
+}
+
+// Rekey rewraps the data keys of all attachments with the primary key, counting the
+// files in result.
+func (st *AttachmentStore) Rekey(result *RekeyResult) error {
+	if st.Keys == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	todoDirs, err := os.ReadDir(st.dir)
+	if err != nil {
+		return err
+	}
+	for _, todoDir := range todoDirs {
+		if !todoDir.IsDir() || uuid.Validate(todoDir.Name()) != nil {
+			continue
+		}
+		todoUUID := todoDir.Name()
+		entries, err := os.ReadDir(st.todoDir(todoUUID))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			id, isMeta := strings.CutSuffix(entry.Name(), ".json")
+			if uuid.Validate(id) != nil {
+				continue // temp files
+			}
+			context := dataContext(todoUUID, id)
+			if isMeta {
+				context = metadataContext(todoUUID, id)
+			}
+			changed, err := rewrapFile(st.Keys, filepath.Join(st.todoDir(todoUUID), entry.Name()), context)
+			if err != nil {
+				return err
+			}
+			result.add(changed)
+		}
+	}
+	return nil
+}
+
+// dataContext and metadataContext bind the encrypted files of an attachment to it.
+func dataContext(todoUUID, id string) string {
+	return "attachment:" + todoUUID + "/" + id
+}
+
+func metadataContext(todoUUID, id string) string {
+	return "attachment-meta:" + todoUUID + "/" + id
+}
+
+// sanitizeFilename keeps only the base name of the client supplied filename.
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	return name
+}
+
+// attachmentStore returns the todo's attachment store after checking that the todo exists,
+// or aborts the request.
+func (s *TodoMgr) attachmentStore(c *gin.Context) (*AttachmentStore, string, bool) {
+	if s.Attachments == nil {
+		abortWithProblem(c, NewProblem(http.StatusNotFound, "attachments are not enabled"))
+		return nil, "", false
+	}
+	UUID := strings.TrimSpace(c.Param("uuid"))
+	if _, err := s.Get(UUID); err != nil {
+		abortWithError(c, err)
+		return nil, "", false
+	}
+	return s.Attachments, UUID, true
+}
+
+// getAttachments lists the attachments of a todo.
+// @param uuid path string true "UUID of the todo"
+// @success 200 {array} Attachment
+// @failure 404 {object} Problem
+func (s *TodoMgr) getAttachments(c *gin.Context) {
+	st, UUID, ok := s.attachmentStore(c)
+	if !ok {
+		return
+	}
+	attachments, err := st.List(UUID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// createAttachment stores the file uploaded in the "file" field of a multipart form.
+// @param uuid path string true "UUID of the todo"
+// @param file formData file true "The file to attach"
+// @success 201 {object} Attachment
+// @failure 400 {object} Problem
+// @failure 404 {object} Problem
+// @failure 413 {object} Problem
+func (s *TodoMgr) createAttachment(c *gin.Context) {
+	st, UUID, ok := s.attachmentStore(c)
+	if !ok {
+		return
+	}
+
+	// Leave some room for the multipart headers around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, st.MaxFileSize+64<<10)
+
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		abortWithValidationError(c, "file", "must be uploaded as multipart/form-data")
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			abortWithError(c, uploadError(err))
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		a, err := st.Save(UUID, part.FileName(), part)
+		part.Close()
+		if err != nil {
+			abortWithError(c, uploadError(err))
+			return
+		}
+		c.JSON(http.StatusCreated, a)
+		return
+	}
+	abortWithValidationError(c, "file", "is required")
+}
+
+// uploadError reports a body cut off by http.MaxBytesReader as a too large attachment.
+func uploadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return ErrAttachmentTooLarge
+	}
+	return err
+}
+
+// getAttachment downloads an attachment.
+// @param uuid path string true "UUID of the todo"
+// @param id path string true "ID of the attachment"
+// @success 200 {file} binary
+// @failure 404 {object} Problem
+func (s *TodoMgr) getAttachment(c *gin.Context) {
+	st, UUID, ok := s.attachmentStore(c)
+	if !ok {
+		return
+	}
+	a, data, err := st.Open(UUID, c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, a.Size, a.ContentType, bytes.NewReader(data), map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// deleteAttachment deletes an attachment.
+// @param uuid path string true "UUID of the todo"
+// @param id path string true "ID of the attachment"
+// @success 200 {object} map[string]string
+// @failure 404 {object} Problem
+func (s *TodoMgr) deleteAttachment(c *gin.Context) {
+	st, UUID, ok := s.attachmentStore(c)
+	if !ok {
+		return
+	}
+	if err := st.Delete(UUID, c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "attachment deleted"})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngHeader is enough of a PNG file for http.DetectContentType.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func setupAttachmentTest(t *testing.T, maxFileSize, maxTodoSize int64) (*TodoMgr, *gin.Engine, Todo) {
+	t.Helper()
+	st, err := NewAttachmentStore(t.TempDir(), maxFileSize, maxTodoSize)
+	require.NoError(t, err)
+	s := &TodoMgr{Attachments: st}
+	todo, err := s.Create("", TodoInput{Description: "with attachments"})
+	require.NoError(t, err)
+	return s, setupRouter(s), todo
+}
+
+func uploadAttachment(router *gin.Engine, todoUUID, filename string, data []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", filename)
+	fw.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/todos/"+todoUUID+"/attachments", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAttachments_UploadDownloadDelete(t *testing.T) {
+	s, router, todo := setupAttachmentTest(t, 1<<20, 1<<20)
+
+	// The client claims a text file, but the content is sniffed
+	w := uploadAttachment(router, todo.UUID, "../../screenshot.txt", pngHeader)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var a Attachment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &a))
+	assert.Equal(t, "image/png", a.ContentType)
+	assert.Equal(t, "screenshot.txt", a.Filename)
+	assert.Equal(t, int64(len(pngHeader)), a.Size)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/attachments", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []Attachment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, a.ID, list[0].ID)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/attachments/"+a.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, pngHeader, w.Body.Bytes())
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=screenshot.txt`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	// No temp files are left behind
+	entries, err := os.ReadDir(s.Attachments.todoDir(todo.UUID))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/todos/"+todo.UUID+"/attachments/"+a.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/attachments/"+a.ID, nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+}
+
+func TestAttachments_SizeLimits(t *testing.T) {
+	_, router, todo := setupAttachmentTest(t, 100, 150)
+
+	w := uploadAttachment(router, todo.UUID, "big.bin", bytes.Repeat([]byte("x"), 101))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	w = uploadAttachment(router, todo.UUID, "a.txt", bytes.Repeat([]byte("x"), 100))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Fits the per-file limit, but not the per-todo limit
+	w = uploadAttachment(router, todo.UUID, "b.txt", bytes.Repeat([]byte("x"), 51))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = uploadAttachment(router, todo.UUID, "c.txt", bytes.Repeat([]byte("x"), 50))
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestAttachments_BadRequests(t *testing.T) {
+	_, router, todo := setupAttachmentTest(t, 100, 100)
+
+	w := uploadAttachment(router, "non-existent-uuid", "a.txt", []byte("hello"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Not multipart
+	req := httptest.NewRequest(http.MethodPost, "/todos/"+todo.UUID+"/attachments", strings.NewReader(`{"file":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Attachment ids are UUIDs, anything else cannot reach the filesystem
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/attachments/..%2F..%2Fetc", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDeleteTodo_RemovesAttachments(t *testing.T) {
+	s, router, todo := setupAttachmentTest(t, 100, 100)
+
+	require.Equal(t, http.StatusCreated, uploadAttachment(router, todo.UUID, "a.txt", []byte("hello")).Code)
+	_, err := s.Delete("", todo.UUID)
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(s.Attachments.dir, todo.UUID))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAttachments_DisabledWithoutStore(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "no attachments"})
+	require.NoError(t, err)
+
+	w := uploadAttachment(setupRouter(s), todo.UUID, "a.txt", []byte("hello"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The audit trail records who changed what and when, for todos and their comments.
+// Every event carries the state of the todo after the change (the last known state for
+// deletions), so the trail alone is enough to tell how a todo evolved.
+//
+// Design choice: like the todos, the trail is kept in memory. It keeps at most
+// MaxAuditEvents events; older ones are folded into auditBase, the todos as they were
+// before the oldest kept event, so that the history can still be replayed from there.
+// The trail is for the admins, since it shows the todos of everyone.
+
+// AuditAction names what happened in an audit event.
+type AuditAction string
+
+const (
+	AuditTodoCreated    AuditAction = "todo.created"
+	AuditTodoUpdated    AuditAction = "todo.updated"
+	AuditTodoDeleted    AuditAction = "todo.deleted"
+	AuditCommentCreated AuditAction = "comment.created"
+	AuditCommentUpdated AuditAction = "comment.updated"
+	AuditCommentDeleted AuditAction = "comment.deleted"
+)
+
+// AuditEvent is a single entry of the audit trail.
+type AuditEvent struct {
+	Seq      int64       `json:"seq"`
+	At       time.Time   `json:"at"`
+	Actor    string      `json:"actor,omitempty"` // authenticated user, empty for anonymous
+	Action   AuditAction `json:"action"`
+	TodoUUID string      `json:"todo_uuid"`
+	Todo     Todo        `json:"todo"`
+	Comment  *Comment    `json:"comment,omitempty"`
+	Backup   string      `json:"backup,omitempty"` // the backup whose restore made the change
+}
+
+// defaultAuditLimit is how many events GET /audit returns unless asked for fewer.
+const defaultAuditLimit = 100
+
+// maxAuditLimit caps the limit of GET /audit, like maxChangesLimit for the change feed.
+const maxAuditLimit = 1000
+
+// auditMaxEventsFromEnv reads AUDIT_MAX_EVENTS, defaulting to 10000. Zero keeps every event.
+func auditMaxEventsFromEnv() int {
+	value := os.Getenv("AUDIT_MAX_EVENTS")
+	if value == "" {
+		return 10000
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid AUDIT_MAX_EVENTS %q", value)
+	}
+	return n
+}
+
+// record appends an event to the audit trail.
+// Caller must hold s.mu for writing.
+func (s *TodoMgr) record(at time.Time, actor string, action AuditAction, t Todo, c *Comment) {
+	mutations.WithLabelValues(string(action)).Inc()
+	seq := int64(1)
+	if n := len(s.auditTrail); n > 0 {
+		seq = s.auditTrail[n-1].Seq + 1
+	}
+	s.auditTrail = append(s.auditTrail, AuditEvent{
+		Seq:      seq,
+		At:       at,
+		Actor:    actor,
+		Action:   action,
+		TodoUUID: t.UUID,
+		Todo:     t,
+		Comment:  c,
+	})
+	if s.MaxAuditEvents > 0 && len(s.auditTrail) > s.MaxAuditEvents {
+		// Fold a quarter more than needed, so that not every event pays for a fold
+		s.foldAudit(len(s.auditTrail) - s.MaxAuditEvents + s.MaxAuditEvents/4)
+	}
+}
+
+// foldAudit drops the n oldest events of the trail, applying them to auditBase.
+// Caller must hold s.mu for writing.
+func (s *TodoMgr) foldAudit(n int) {
+	s.auditBase = applyAuditEvents(s.auditBase, s.auditTrail[:n], time.Time{})
+	s.auditTrail = append([]AuditEvent(nil), s.auditTrail[n:]...)
+}
+
+// applyAuditEvents returns the todos, in creation order, after the events that happened
+// at or before the instant; the zero instant applies them all.
+func applyAuditEvents(base []Todo, events []AuditEvent, at time.Time) []Todo {
+	order := make([]string, 0, len(base))
+	state := make(map[string]Todo, len(base))
+	for _, t := range base {
+		order = append(order, t.UUID)
+		state[t.UUID] = t
+	}
+	for _, ev := range events {
+		// Mutators take their timestamp before locking, so the events are only roughly in
+		// time order and a later event may still be part of the state
+		if !at.IsZero() && ev.At.After(at) {
+			continue
+		}
+		switch ev.Action {
+		case AuditTodoCreated:
+			order = append(order, ev.TodoUUID)
+			state[ev.TodoUUID] = ev.Todo
+		case AuditTodoDeleted:
+			delete(state, ev.TodoUUID)
+		default:
+			state[ev.TodoUUID] = ev.Todo
+		}
+	}
+
+	out := make([]Todo, 0, len(state))
+	for _, id := range order {
+		if t, ok := state[id]; ok {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// AuditTrail returns the events after the sequence number, oldest first, optionally only
+// those of one todo. At most limit events are returned; zero means no limit.
+func (s *TodoMgr) AuditTrail(todoUUID string, after int64, limit int) []AuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []AuditEvent{}
+	start := 0
+	if len(s.auditTrail) > 0 {
+		// Sequence numbers are consecutive, so the scan can start right after the cursor
+		start = int(min(max(after-s.auditTrail[0].Seq+1, 0), int64(len(s.auditTrail))))
+	}
+	for _, ev := range s.auditTrail[start:] {
+		if todoUUID != "" && ev.TodoUUID != todoUUID {
+			continue
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// getAudit pages through the audit trail. Only admins may read it.
+// @param todo_uuid query string false "Only events of this todo"
+// @param after query int false "Only events with a larger seq, for paging"
+// @param limit query int false "Maximum number of events, default 100, at most 1000"
+// @success 200 {array} AuditEvent
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+func (s *TodoMgr) getAudit(c *gin.Context) {
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil || after < 0 {
+		abortWithValidationError(c, "after", "must be a non-negative integer")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLimit)))
+	if err != nil || limit < 1 {
+		abortWithValidationError(c, "limit", "must be a positive integer")
+		return
+	}
+
+	c.JSON(http.StatusOK, s.AuditTrail(c.Query("todo_uuid"), after, min(limit, maxAuditLimit)))
+}
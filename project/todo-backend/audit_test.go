@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditTrail_RecordsTodoAndCommentChanges(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("alice", TodoInput{Description: "audited"})
+	require.NoError(t, err)
+	other, err := s.Create("bob", TodoInput{Description: "other"})
+	require.NoError(t, err)
+	comment, err := s.AddComment("bob", todo.UUID, "hello")
+	require.NoError(t, err)
+	_, err = s.UpdateComment("bob", todo.UUID, comment.ID, "hello again")
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteComment("bob", todo.UUID, comment.ID))
+	description := "audited twice"
+	_, err = s.Update("carol", todo.UUID, TodoPatch{Description: &description})
+	require.NoError(t, err)
+	_, err = s.Delete("alice", todo.UUID)
+	require.NoError(t, err)
+
+	events := s.AuditTrail(todo.UUID, 0, 0)
+	actions := []AuditAction{}
+	actors := []string{}
+	for _, ev := range events {
+		actions = append(actions, ev.Action)
+		actors = append(actors, ev.Actor)
+	}
+	assert.Equal(t, []AuditAction{
+		AuditTodoCreated, AuditCommentCreated, AuditCommentUpdated, AuditCommentDeleted, AuditTodoUpdated, AuditTodoDeleted,
+	}, actions)
+	assert.Equal(t, []string{"alice", "bob", "bob", "bob", "carol", "alice"}, actors)
+	assert.Equal(t, "hello again", events[2].Comment.Body)
+	assert.Equal(t, "audited twice", events[5].Todo.Description)
+
+	// Paging over the whole trail
+	all := s.AuditTrail("", 0, 0)
+	require.Len(t, all, 7)
+	assert.Equal(t, other.UUID, all[1].TodoUUID)
+	page := s.AuditTrail("", 2, 2)
+	require.Len(t, page, 2)
+	assert.Equal(t, int64(3), page[0].Seq)
+}
+
+func TestGetAudit(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	_, err := s.Create("alice", TodoInput{Description: "audited"})
+	require.NoError(t, err)
+
+	w := apiRequest(router, http.MethodGet, "/audit?limit=10", "root", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var events []AuditEvent
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+	require.Len(t, events, 1)
+	assert.Equal(t, AuditTodoCreated, events[0].Action)
+
+	assert.Equal(t, http.StatusBadRequest, apiRequest(router, http.MethodGet, "/audit?limit=0", "root", "").Code)
+
+	// Large limits are cut to maxAuditLimit
+	for i := 0; i < maxAuditLimit; i++ {
+		_, err := s.Create("alice", TodoInput{Description: "audited"})
+		require.NoError(t, err)
+	}
+	w = apiRequest(router, http.MethodGet, "/audit?limit=1000000", "root", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &events))
+	assert.Len(t, events, maxAuditLimit)
+
+	// The trail shows the todos of everyone
+	assert.Equal(t, http.StatusForbidden, apiRequest(router, http.MethodGet, "/audit", "alice", "").Code)
+	assert.Equal(t, http.StatusForbidden, apiRequest(router, http.MethodGet, "/audit", "", "").Code)
+}
+
+func TestAuditTrail_Retention(t *testing.T) {
+	s := &TodoMgr{MaxAuditEvents: 8}
+	kept, err := s.Create("", TodoInput{Description: "kept"})
+	require.NoError(t, err)
+	gone, err := s.Create("", TodoInput{Description: "gone"})
+	require.NoError(t, err)
+	_, err = s.Delete("", gone.UUID)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		description := "kept " + strconv.Itoa(i)
+		_, err = s.Update("", kept.UUID, TodoPatch{Description: &description})
+		require.NoError(t, err)
+	}
+
+	// 13 events: 8 overflow to 9, which folds the 3 oldest and 2 more
+	all := s.AuditTrail("", 0, 0)
+	assert.LessOrEqual(t, len(all), 8)
+	assert.Equal(t, int64(13), all[len(all)-1].Seq, "sequence numbers go on")
+	for i := 1; i < len(all); i++ {
+		assert.Equal(t, all[i-1].Seq+1, all[i].Seq)
+	}
+	page := s.AuditTrail("", all[0].Seq, 2)
+	require.Len(t, page, 2)
+	assert.Equal(t, all[1].Seq, page[0].Seq)
+	assert.Equal(t, all, s.AuditTrail("", 0, 0), "a cursor before the oldest event starts at the oldest")
+
+	// The folded events still count for the history
+	assert.Equal(t, s.List(), s.ListAsOf(time.Now()))
+	before := s.ListAsOf(all[0].At.Add(-time.Hour))
+	require.Len(t, before, 1)
+	assert.Equal(t, kept.UUID, before[0].UUID)
+}
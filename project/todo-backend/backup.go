@@ -0,0 +1,519 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Backups are point-in-time snapshots of the in-memory state, taken while todo-backend
+// keeps serving. Admins create them with POST /admin/backup, list them with GET
+// /admin/backups and bring one back with POST /admin/restore. Each backup is a gzipped
+// tar archive in BACKUP_DIR, encrypted like the other files on the volume:
+//
+//	manifest.json  format version, creation time, counts and the SHA-256 of the snapshot
+//	snapshot.json  todos, comments, templates and the audit trail
+//
+// A snapshot is taken under the read lock of the TodoMgr, so it is consistent: no
+// mutation is half in it. A restore checks the format version, the checksum and the
+// snapshot itself before swapping it in under the write lock, so requests see either the
+// old state or the restored one. The difference is recorded in the audit trail as events
+// of the restoring admin naming the backup, and subscribers get it as events too.
+//
+// Design choice: attachments, jobs and shares are not in the backups. Attachments already
+// live on the persistent volume, those of todos the restore removes are deleted with them,
+// and jobs belong to the running process. Shares are left as they are and serve the
+// restored todos. The audit trail in the snapshot is not restored either: the current one
+// goes on, so a restore cannot erase history.
+
+const (
+	defaultBackupDir = "/app/data/backups"
+
+	// backupFormatVersion is the version of the archive layout and the snapshot. Restores
+	// accept archives up to this version.
+	backupFormatVersion = 1
+
+	backupManifestName = "manifest.json"
+	backupSnapshotName = "snapshot.json"
+)
+
+// backupIDPattern matches the ids of backups, which are also their file names.
+var backupIDPattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{9}Z-[0-9a-f]{8}$`)
+
+// ErrBackupNotFound is returned for unknown backup ids.
+var ErrBackupNotFound = errors.New("backup not found")
+
+// ErrBackupsDisabled is returned by the backup methods when no BackupStore is configured.
+var ErrBackupsDisabled = errors.New("backups are not enabled")
+
+// ErrInvalidBackup is returned for backups that cannot be restored: damaged archives,
+// checksum mismatches, unknown format versions or inconsistent snapshots.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backup describes a backup; it is the manifest of the archive.
+type Backup struct {
+	ID            string    `json:"id"`
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Size          int64     `json:"size"`     // of the archive file, zero in the manifest itself
+	Checksum      string    `json:"checksum"` // "sha256:" and the hex digest of snapshot.json
+	Todos         int       `json:"todos"`
+	Comments      int       `json:"comments"`
+	Templates     int       `json:"templates"`
+	AuditEvents   int       `json:"audit_events"`
+}
+
+// backupSnapshot is the state saved in a backup.
+type backupSnapshot struct {
+	Todos      []Todo               `json:"todos"`
+	Comments   map[string][]Comment `json:"comments"`
+	Templates  []Template           `json:"templates"`
+	AuditTrail []AuditEvent         `json:"audit_trail"`
+	AuditBase  []Todo               `json:"audit_base,omitempty"`
+}
+
+// BackupStore keeps backups under a directory on disk.
+type BackupStore struct {
+	mu  sync.Mutex // serialises writing and rekeying backups
+	dir string
+	// Keys encrypts the backups. Nil stores them unencrypted.
+	Keys *Keyring
+}
+
+func NewBackupStore(dir string) (*BackupStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &BackupStore{dir: dir}, nil
+}
+
+// NewBackupStoreFromEnv configures the store from BACKUP_DIR.
+func NewBackupStoreFromEnv() (*BackupStore, error) {
+	dir := os.Getenv("BACKUP_DIR")
+	if dir == "" {
+		dir = defaultBackupDir
+	}
+	return NewBackupStore(dir)
+}
+
+func (st *BackupStore) path(id string) string {
+	return filepath.Join(st.dir, id+".tar.gz")
+}
+
+// backupContext binds an encrypted backup to its id.
+func backupContext(id string) string {
+	return "backup/" + id
+}
+
+// Backup writes a snapshot of the todos, comments, templates and audit trail.
+func (s *TodoMgr) Backup() (Backup, error) {
+	defer storeTimer("backup").ObserveDuration()
+
+	if s.Backups == nil {
+		return Backup{}, ErrBackupsDisabled
+	}
+
+	s.mu.RLock()
+	snapshot := backupSnapshot{
+		Todos:      s.todosSorted,
+		Comments:   s.comments,
+		Templates:  s.templates,
+		AuditTrail: s.auditTrail,
+		AuditBase:  s.auditBase,
+	}
+	data, err := json.Marshal(snapshot)
+	now := time.Now().UTC()
+	s.mu.RUnlock()
+	if err != nil {
+		return Backup{}, err
+	}
+
+	sum := sha256.Sum256(data)
+	b := Backup{
+		ID:            newBackupID(now),
+		FormatVersion: backupFormatVersion,
+		CreatedAt:     now,
+		Checksum:      "sha256:" + hex.EncodeToString(sum[:]),
+		Todos:         len(snapshot.Todos),
+		Templates:     len(snapshot.Templates),
+		AuditEvents:   len(snapshot.AuditTrail),
+	}
+	for _, comments := range snapshot.Comments {
+		b.Comments += len(comments)
+	}
+	return b, s.Backups.write(&b, data)
+}
+
+// newBackupID returns an id sorting by creation time.
+func newBackupID(now time.Time) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return strings.Replace(now.Format("20060102T150405.000Z"), ".", "", 1) + "-" + hex.EncodeToString(suffix)
+}
+
+// write archives the manifest and the snapshot and writes them atomically, setting the
+// size of b.
+func (st *BackupStore) write(b *Backup, snapshot []byte) error {
+	manifest, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{backupManifestName, manifest}, {backupSnapshotName, snapshot}} {
+		hdr := &tar.Header{Name: entry.name, Mode: 0o600, Size: int64(len(entry.data)), ModTime: b.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	sealed, err := st.Keys.Seal(buf.Bytes(), backupContext(b.ID))
+	if err != nil {
+		return err
+	}
+	b.Size = int64(len(sealed))
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return writeFileAtomic(st.path(b.ID), sealed)
+}
+
+// read returns the manifest and the snapshot of a backup, after checking the format
+// version and the checksum.
+func (st *BackupStore) read(id string) (Backup, []byte, error) {
+	if !backupIDPattern.MatchString(id) {
+		return Backup{}, nil, ErrBackupNotFound
+	}
+	data, err := readSealedFile(st.Keys, st.path(id), backupContext(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return Backup{}, nil, ErrBackupNotFound
+	}
+	if err != nil {
+		return Backup{}, nil, err
+	}
+
+	entries, err := readTarGz(data)
+	if err != nil {
+		return Backup{}, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	var b Backup
+	if err := json.Unmarshal(entries[backupManifestName], &b); err != nil {
+		return Backup{}, nil, fmt.Errorf("%w: manifest: %v", ErrInvalidBackup, err)
+	}
+	if b.ID != id {
+		return Backup{}, nil, fmt.Errorf("%w: manifest is of backup %q", ErrInvalidBackup, b.ID)
+	}
+	if b.FormatVersion < 1 || b.FormatVersion > backupFormatVersion {
+		return Backup{}, nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidBackup, b.FormatVersion)
+	}
+	snapshot, ok := entries[backupSnapshotName]
+	if !ok {
+		return Backup{}, nil, fmt.Errorf("%w: no %s", ErrInvalidBackup, backupSnapshotName)
+	}
+	sum := sha256.Sum256(snapshot)
+	if b.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		return Backup{}, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidBackup)
+	}
+	if info, err := os.Stat(st.path(id)); err == nil {
+		b.Size = info.Size()
+	}
+	return b, snapshot, nil
+}
+
+// readTarGz returns the files of a gzipped tar archive by name.
+func readTarGz(data []byte) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entries[hdr.Name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// List returns the backups that can be read, newest first. Unreadable ones are logged
+// and left out.
+func (st *BackupStore) List() ([]Backup, error) {
+	files, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".tar.gz")
+		if !ok || !backupIDPattern.MatchString(id) {
+			continue // temp files
+		}
+		b, _, err := st.read(id)
+		if err != nil {
+			log.Printf("Skipping backup %s: %v", id, err)
+			continue
+		}
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups, nil
+}
+
+// Rekey rewraps the data keys of all backups with the primary key, counting the files in
+// result.
+func (st *BackupStore) Rekey(result *RekeyResult) error {
+	if st.Keys == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	files, err := os.ReadDir(st.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".tar.gz")
+		if !ok || !backupIDPattern.MatchString(id) {
+			continue
+		}
+		changed, err := rewrapFile(st.Keys, st.path(id), backupContext(id))
+		if err != nil {
+			return err
+		}
+		result.add(changed)
+	}
+	return nil
+}
+
+// ListBackups lists the backups, newest first.
+func (s *TodoMgr) ListBackups() ([]Backup, error) {
+	if s.Backups == nil {
+		return nil, ErrBackupsDisabled
+	}
+	return s.Backups.List()
+}
+
+// Restore replaces the todos, comments and templates with those of a backup on behalf of
+// actor.
+func (s *TodoMgr) Restore(actor, id string) (Backup, error) {
+	defer storeTimer("restore").ObserveDuration()
+
+	if s.Backups == nil {
+		return Backup{}, ErrBackupsDisabled
+	}
+	b, data, err := s.Backups.read(id)
+	if err != nil {
+		return Backup{}, err
+	}
+	var snapshot backupSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Backup{}, fmt.Errorf("%w: snapshot: %v", ErrInvalidBackup, err)
+	}
+	if err := s.checkSnapshot(snapshot); err != nil {
+		return Backup{}, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if snapshot.Comments == nil {
+		snapshot.Comments = map[string][]Comment{}
+	}
+
+	s.mu.Lock()
+	if err := s.checkSnapshotQuota(snapshot); err != nil {
+		s.mu.Unlock()
+		return Backup{}, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	before := s.todosSorted
+	s.todosSorted = snapshot.Todos
+	s.comments = snapshot.Comments
+	s.templates = snapshot.Templates
+	removed := s.commitRestore(actor, b.ID, before, snapshot.Todos)
+	s.mu.Unlock()
+
+	// Like Delete, remove the files outside the lock
+	if s.Attachments != nil {
+		for _, t := range removed {
+			if err := s.Attachments.RemoveAll(t.UUID); err != nil {
+				log.Printf("Failed to remove attachments of todo %s: %v", t.UUID, err)
+			}
+		}
+	}
+	return b, nil
+}
+
+// checkSnapshot rejects snapshots the rest of TodoMgr would trip over.
+func (s *TodoMgr) checkSnapshot(snapshot backupSnapshot) error {
+	wf := s.workflow()
+	seen := map[string]bool{}
+	for _, t := range snapshot.Todos {
+		// The UUIDs name the attachment directories, so they must be UUIDs TodoMgr could
+		// have generated
+		if id, err := uuid.Parse(t.UUID); err != nil || id.String() != t.UUID {
+			return fmt.Errorf("todo uuid %q is not a UUID", t.UUID)
+		}
+		if seen[t.UUID] {
+			return fmt.Errorf("todo uuid %q is duplicated", t.UUID)
+		}
+		seen[t.UUID] = true
+		if wf.state(t.State) == nil {
+			return fmt.Errorf("todo %s is in state %q, which the workflow does not have", t.UUID, t.State)
+		}
+	}
+	for todoUUID := range snapshot.Comments {
+		if !seen[todoUUID] {
+			return fmt.Errorf("comments of unknown todo %s", todoUUID)
+		}
+	}
+	for i, ev := range snapshot.AuditTrail {
+		if ev.Seq < 1 || i > 0 && ev.Seq != snapshot.AuditTrail[i-1].Seq+1 {
+			return fmt.Errorf("audit trail is not in sequence at %d", ev.Seq)
+		}
+	}
+	return nil
+}
+
+// checkSnapshotQuota rejects snapshots with more todos than MaxTodos or MaxTodosPerOwner
+// allow, so a restore cannot get around the quotas.
+// Caller must hold s.mu.
+func (s *TodoMgr) checkSnapshotQuota(snapshot backupSnapshot) error {
+	if s.MaxTodos > 0 && len(snapshot.Todos) > s.MaxTodos {
+		return fmt.Errorf("%d todos exceed the quota of %d", len(snapshot.Todos), s.MaxTodos)
+	}
+	if s.MaxTodosPerOwner == 0 {
+		return nil
+	}
+	owned := map[string]int{}
+	for _, t := range snapshot.Todos {
+		owned[t.Owner]++
+		if owned[t.Owner] > s.MaxTodosPerOwner {
+			return fmt.Errorf("the todos of %q exceed the quota of %d per owner", t.Owner, s.MaxTodosPerOwner)
+		}
+	}
+	return nil
+}
+
+// commitRestore records how the todos changed with the restore of a backup in the audit
+// trail and tells the subscribers. It returns the todos the restore removed.
+// Caller must hold s.mu for writing.
+func (s *TodoMgr) commitRestore(actor, backupID string, before, after []Todo) []Todo {
+	now := time.Now().UTC()
+	change := func(typ TodoEventType, action AuditAction, t Todo) {
+		s.publish(TodoEvent{Type: typ, Todo: t})
+		s.record(now, actor, action, t, nil)
+		s.auditTrail[len(s.auditTrail)-1].Backup = backupID
+	}
+
+	previous := map[string]Todo{}
+	for _, t := range before {
+		previous[t.UUID] = t
+	}
+	for _, t := range after {
+		old, existed := previous[t.UUID]
+		delete(previous, t.UUID)
+		switch {
+		case !existed:
+			change(TodoCreated, AuditTodoCreated, t)
+		case len(changedFields(old, t)) > 0:
+			change(TodoUpdated, AuditTodoUpdated, t)
+		}
+	}
+	var removed []Todo
+	for _, t := range before {
+		if _, ok := previous[t.UUID]; ok {
+			change(TodoDeleted, AuditTodoDeleted, t)
+			removed = append(removed, t)
+		}
+	}
+	return removed
+}
+
+// postBackup takes a backup.
+// @success 201 {object} Backup
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) postBackup(c *gin.Context) {
+	b, err := s.Backup()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, b)
+}
+
+// getBackups lists the backups, newest first.
+// @success 200 {array} Backup
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) getBackups(c *gin.Context) {
+	backups, err := s.ListBackups()
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, backups)
+}
+
+// postRestore restores a backup.
+// @param id body string true "Id of the backup"
+// @success 200 {object} Backup
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+// @failure 422 {object} Problem
+func (s *TodoMgr) postRestore(c *gin.Context) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+	if strings.TrimSpace(req.ID) == "" {
+		abortWithValidationError(c, "id", "is required")
+		return
+	}
+	b, err := s.Restore(requestIdentity(c), strings.TrimSpace(req.ID))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupBackupTest(t *testing.T, keys *Keyring) (*TodoMgr, *gin.Engine) {
+	t.Helper()
+	t.Setenv("ADMIN_USERS", "root")
+	st, err := NewBackupStore(t.TempDir())
+	require.NoError(t, err)
+	st.Keys = keys
+	s := &TodoMgr{Backups: st, Keys: keys}
+	return s, setupRouter(s)
+}
+
+func TestBackup_RoundTrip(t *testing.T) {
+	s, router := setupBackupTest(t, nil)
+	kept, err := s.Create("alice", TodoInput{Description: "kept"})
+	require.NoError(t, err)
+	_, err = s.AddComment("alice", kept.UUID, "a comment")
+	require.NoError(t, err)
+	_, err = s.CreateTemplate("alice", TemplateInput{Name: "release", Items: []string{"tag"}})
+	require.NoError(t, err)
+	kept, err = s.Get(kept.UUID)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusForbidden, apiRequest(router, http.MethodPost, "/admin/backup", "alice", "").Code)
+	w := apiRequest(router, http.MethodPost, "/admin/backup", "root", "")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var b Backup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &b))
+	assert.Equal(t, backupFormatVersion, b.FormatVersion)
+	assert.Equal(t, 1, b.Todos)
+	assert.Equal(t, 1, b.Comments)
+	assert.Equal(t, 1, b.Templates)
+	assert.Equal(t, 2, b.AuditEvents)
+	assert.Regexp(t, `^sha256:[0-9a-f]{64}$`, b.Checksum)
+	assert.Positive(t, b.Size)
+
+	// Changes after the backup are undone by the restore
+	done := true
+	_, err = s.Update("alice", kept.UUID, TodoPatch{Done: &done})
+	require.NoError(t, err)
+	added, err := s.Create("", TodoInput{Description: "added later"})
+	require.NoError(t, err)
+	s.Attachments, err = NewAttachmentStore(t.TempDir(), 1024, 1024)
+	require.NoError(t, err)
+	_, err = s.Attachments.Save(added.UUID, "notes.txt", strings.NewReader("notes"))
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteTemplate(s.Templates()[0].ID, "", true))
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	w = apiRequest(router, http.MethodGet, "/admin/backups", "root", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var backups []Backup
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &backups))
+	require.Len(t, backups, 1)
+	assert.Equal(t, b, backups[0])
+
+	w = apiRequest(router, http.MethodPost, "/admin/restore", "root", `{"id":"`+b.ID+`"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	todos := s.List()
+	require.Len(t, todos, 1)
+	assert.Equal(t, kept, todos[0])
+	comments, err := s.Comments(kept.UUID)
+	require.NoError(t, err)
+	assert.Len(t, comments, 1)
+	assert.Len(t, s.Templates(), 1)
+
+	// The trail goes on, with the restore recorded in it
+	trail := s.AuditTrail("", 4, 0)
+	require.Len(t, trail, 2)
+	assert.Equal(t, AuditEvent{Seq: 5, At: trail[0].At, Actor: "root", Action: AuditTodoUpdated, TodoUUID: kept.UUID, Todo: kept, Backup: b.ID}, trail[0])
+	assert.Equal(t, AuditTodoDeleted, trail[1].Action)
+	assert.Equal(t, added.UUID, trail[1].TodoUUID)
+	assert.Equal(t, b.ID, trail[1].Backup)
+	assert.NoDirExists(t, s.Attachments.todoDir(added.UUID))
+	assert.Equal(t, 0, s.Stats(trail[0].At.Add(-time.Minute), trail[0].At.Add(time.Minute), "day", time.Now()).Deleted)
+
+	// Watchers learn what the restore changed
+	assert.Equal(t, TodoEvent{Type: TodoUpdated, Todo: kept}, <-events)
+	ev := <-events
+	assert.Equal(t, TodoDeleted, ev.Type)
+	assert.Equal(t, added.UUID, ev.Todo.UUID)
+	assert.Empty(t, events)
+
+	// The restored state keeps working
+	_, err = s.Create("", TodoInput{Description: "after restore"})
+	require.NoError(t, err)
+	assert.Len(t, s.AuditTrail("", 0, 0), 7)
+}
+
+func TestBackup_Encrypted(t *testing.T) {
+	keys := testKeyring(t, "new", "old")
+	s, router := setupBackupTest(t, testKeyring(t, "old"))
+	_, err := s.Create("", TodoInput{Description: "secret plans"})
+	require.NoError(t, err)
+	b, err := s.Backup()
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(s.Backups.path(b.ID))
+	require.NoError(t, err)
+	assert.True(t, isSealed(data))
+	assert.NotContains(t, string(data), "secret plans")
+
+	s.Keys, s.Backups.Keys = keys, keys
+	result, err := s.Rekey()
+	require.NoError(t, err)
+	assert.Equal(t, RekeyResult{Rewrapped: 1}, result)
+	// Restores take the body in any of the request media types
+	req := httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader("id: "+b.ID+"\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	req.Header.Set(identityHeader, "root")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// writeBackupArchive replaces the archive of a backup with the given manifest and snapshot.
+func writeBackupArchive(t *testing.T, st *BackupStore, id string, manifest, snapshot []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range map[string][]byte{backupManifestName: manifest, backupSnapshotName: snapshot} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(st.path(id), buf.Bytes(), 0o600))
+}
+
+func TestBackup_RestoreRejectsInvalidBackups(t *testing.T) {
+	s, router := setupBackupTest(t, nil)
+	todo, err := s.Create("", TodoInput{Description: "current"})
+	require.NoError(t, err)
+	b, err := s.Backup()
+	require.NoError(t, err)
+	entries, err := readTarGz(mustReadFile(t, s.Backups.path(b.ID)))
+	require.NoError(t, err)
+	manifest, snapshot := entries[backupManifestName], entries[backupSnapshotName]
+
+	restore := func(id string) *Problem {
+		t.Helper()
+		w := apiRequest(router, http.MethodPost, "/admin/restore", "root", `{"id":"`+id+`"}`)
+		var p Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		return &p
+	}
+
+	assert.Equal(t, http.StatusBadRequest, restore("").Status)
+	assert.Equal(t, http.StatusNotFound, restore("../../etc/passwd").Status)
+	assert.Equal(t, http.StatusNotFound, restore("20260101T000000000Z-00000000").Status)
+
+	// A flipped byte in the snapshot
+	tampered := bytes.Replace(snapshot, []byte("current"), []byte("CURRENT"), 1)
+	writeBackupArchive(t, s.Backups, b.ID, manifest, tampered)
+	p := restore(b.ID)
+	assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+	assert.Contains(t, p.Detail, "checksum")
+
+	// A backup of a newer todo-backend
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(manifest, &m))
+	m["format_version"] = backupFormatVersion + 1
+	newer, err := json.Marshal(m)
+	require.NoError(t, err)
+	writeBackupArchive(t, s.Backups, b.ID, newer, snapshot)
+	p = restore(b.ID)
+	assert.Equal(t, http.StatusUnprocessableEntity, p.Status)
+	assert.Contains(t, p.Detail, "format version")
+
+	require.NoError(t, os.WriteFile(s.Backups.path(b.ID), []byte("not an archive"), 0o600))
+	assert.Equal(t, http.StatusUnprocessableEntity, restore(b.ID).Status)
+
+	// Unreadable backups are left out of the list
+	backups, err := s.ListBackups()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+	require.NoError(t, os.WriteFile(filepath.Join(s.Backups.dir, b.ID+".tar.gz.tmp.123"), nil, 0o600))
+	_, err = s.ListBackups()
+	require.NoError(t, err)
+
+	_, err = s.Get(todo.UUID)
+	assert.NoError(t, err, "nothing was restored")
+}
+
+func TestBackup_CheckSnapshot(t *testing.T) {
+	s := &TodoMgr{}
+	const id = "0b4cf2a3-44a4-4d1e-9f43-59b7de4c2d41"
+	todo := Todo{UUID: id, State: "backlog"}
+	tests := []struct {
+		name     string
+		snapshot backupSnapshot
+		valid    bool
+	}{
+		{"empty", backupSnapshot{}, true},
+		{"valid", backupSnapshot{Todos: []Todo{todo}, Comments: map[string][]Comment{id: {{ID: "c"}}},
+			AuditTrail: []AuditEvent{{Seq: 1}, {Seq: 2}}}, true},
+		{"kept part of the audit trail", backupSnapshot{AuditTrail: []AuditEvent{{Seq: 41}, {Seq: 42}}}, true},
+		{"duplicate uuid", backupSnapshot{Todos: []Todo{todo, todo}}, false},
+		{"path as uuid", backupSnapshot{Todos: []Todo{{UUID: "../../keys", State: "backlog"}}}, false},
+		{"uuid in another form", backupSnapshot{Todos: []Todo{{UUID: "{" + id + "}", State: "backlog"}}}, false},
+		{"unknown state", backupSnapshot{Todos: []Todo{{UUID: id, State: "archived"}}}, false},
+		{"orphaned comments", backupSnapshot{Comments: map[string][]Comment{"b": {{ID: "c"}}}}, false},
+		{"audit gap", backupSnapshot{AuditTrail: []AuditEvent{{Seq: 1}, {Seq: 3}}}, false},
+	}
+	for _, tt := range tests {
+		err := s.checkSnapshot(tt.snapshot)
+		assert.Equal(t, tt.valid, err == nil, tt.name)
+	}
+}
+
+func TestBackup_RestoreEnforcesQuotas(t *testing.T) {
+	s, router := setupBackupTest(t, nil)
+	for _, owner := range []string{"alice", "alice", "bob"} {
+		_, err := s.Create(owner, TodoInput{Description: "mine"})
+		require.NoError(t, err)
+	}
+	b, err := s.Backup()
+	require.NoError(t, err)
+
+	restore := func() *httptest.ResponseRecorder {
+		return apiRequest(router, http.MethodPost, "/admin/restore", "root", `{"id":"`+b.ID+`"}`)
+	}
+
+	s.SetQuota(2, 0)
+	w := restore()
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "quota")
+
+	s.SetQuota(0, 1)
+	w = restore()
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "per owner")
+
+	s.SetQuota(3, 2)
+	assert.Equal(t, http.StatusOK, restore().Code)
+}
+
+func TestBackup_Disabled(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	router := setupRouter(&TodoMgr{})
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodPost, "/admin/backup", "root", "").Code)
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodGet, "/admin/backups", "root", "").Code)
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Comments form a discussion thread on a todo. They are kept with the todos and removed
+// together with their todo. Only the author of a comment can edit or delete it; anonymous
+// comments can be changed by any anonymous client.
+
+const COMMENTMAXLENGTH = 2000
+
+// ErrCommentNotFound is returned when a todo has no comment with the given id.
+var ErrCommentNotFound = errors.New("comment not found")
+
+// ErrNotCommentAuthor is returned when someone else than the author changes a comment.
+var ErrNotCommentAuthor = errors.New("only the author can change a comment")
+
+// Comment is a single comment on a todo.
+type Comment struct {
+	ID        string    `json:"id"`
+	TodoUUID  string    `json:"todo_uuid"`
+	Author    string    `json:"author,omitempty"` // authenticated user, empty for anonymous
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// normalizeCommentBody trims the body and checks it against the length limits.
+func normalizeCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", &ValidationError{Field: "body", Message: "is required"}
+	}
+	if len(body) > COMMENTMAXLENGTH {
+		return "", &ValidationError{Field: "body", Message: "exceeds maximum length"}
+	}
+	return body, nil
+}
+
+// Comments returns the comments of the todo, oldest first.
+func (s *TodoMgr) Comments(todoUUID string) ([]Comment, error) {
+	defer storeTimer("list_comments").ObserveDuration()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.indexOf(todoUUID) < 0 {
+		return nil, ErrTodoNotFound
+	}
+	out := make([]Comment, len(s.comments[todoUUID]))
+	copy(out, s.comments[todoUUID])
+	return out, nil
+}
+
+// AddComment adds a comment by author to the todo.
+func (s *TodoMgr) AddComment(author, todoUUID, body string) (Comment, error) {
+	defer storeTimer("add_comment").ObserveDuration()
+
+	body, err := normalizeCommentBody(body)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(todoUUID)
+	if i < 0 {
+		return Comment{}, ErrTodoNotFound
+	}
+	now := time.Now().UTC()
+	comment := Comment{
+		ID:        uuid.New().String(),
+		TodoUUID:  todoUUID,
+		Author:    author,
+		Body:      body,
+		CreatedAt: now,
+		ChangedAt: now,
+	}
+	if s.comments == nil {
+		s.comments = make(map[string][]Comment)
+	}
+	s.comments[todoUUID] = append(s.comments[todoUUID], comment)
+	s.todosSorted[i].CommentCount++
+
+	s.publish(TodoEvent{Type: TodoUpdated, Todo: s.todosSorted[i]})
+	s.record(now, author, AuditCommentCreated, s.todosSorted[i], &comment)
+	return comment, nil
+}
+
+// UpdateComment replaces the body of a comment. Only its author may do so.
+func (s *TodoMgr) UpdateComment(actor, todoUUID, id, body string) (Comment, error) {
+	defer storeTimer("update_comment").ObserveDuration()
+
+	body, err := normalizeCommentBody(body)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, j, err := s.commentIndex(actor, todoUUID, id)
+	if err != nil {
+		return Comment{}, err
+	}
+	now := time.Now().UTC()
+	comment := &s.comments[todoUUID][j]
+	comment.Body = body
+	comment.ChangedAt = now
+
+	updated := *comment
+	s.record(now, actor, AuditCommentUpdated, s.todosSorted[i], &updated)
+	return updated, nil
+}
+
+// DeleteComment removes a comment. Only its author may do so.
+func (s *TodoMgr) DeleteComment(actor, todoUUID, id string) error {
+	defer storeTimer("delete_comment").ObserveDuration()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, j, err := s.commentIndex(actor, todoUUID, id)
+	if err != nil {
+		return err
+	}
+	deleted := s.comments[todoUUID][j]
+	s.comments[todoUUID] = append(s.comments[todoUUID][:j], s.comments[todoUUID][j+1:]...)
+	s.todosSorted[i].CommentCount--
+
+	s.publish(TodoEvent{Type: TodoUpdated, Todo: s.todosSorted[i]})
+	s.record(time.Now().UTC(), actor, AuditCommentDeleted, s.todosSorted[i], &deleted)
+	return nil
+}
+
+// commentIndex returns the positions of the todo and of the comment the actor wants to change.
+// Caller must hold s.mu.
+func (s *TodoMgr) commentIndex(actor, todoUUID, id string) (int, int, error) {
+	i := s.indexOf(todoUUID)
+	if i < 0 {
+		return -1, -1, ErrTodoNotFound
+	}
+	for j, comment := range s.comments[todoUUID] {
+		if comment.ID != id {
+			continue
+		}
+		if comment.Author != actor {
+			return -1, -1, ErrNotCommentAuthor
+		}
+		return i, j, nil
+	}
+	return -1, -1, ErrCommentNotFound
+}
+
+// getComments lists the comments of a todo.
+// @param uuid path string true "UUID of the todo"
+// @success 200 {array} Comment
+// @failure 404 {object} Problem
+func (s *TodoMgr) getComments(c *gin.Context) {
+	comments, err := s.Comments(strings.TrimSpace(c.Param("uuid")))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, comments)
+}
+
+// createComment adds a comment to a todo.
+// @param uuid path string true "UUID of the todo"
+// @param body body string true "Text of the comment"
+// @success 201 {object} Comment
+// @failure 400 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) createComment(c *gin.Context) {
+	var req struct {
+		Body string `json:"body"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+
+	comment, err := s.AddComment(requestIdentity(c), strings.TrimSpace(c.Param("uuid")), req.Body)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, comment)
+}
+
+// patchComment edits a comment.
+// @param uuid path string true "UUID of the todo"
+// @param id path string true "ID of the comment"
+// @param body body string true "New text of the comment"
+// @success 200 {object} Comment
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) patchComment(c *gin.Context) {
+	var req struct {
+		Body string `json:"body"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+
+	comment, err := s.UpdateComment(requestIdentity(c), strings.TrimSpace(c.Param("uuid")), c.Param("id"), req.Body)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, comment)
+}
+
+// deleteComment deletes a comment.
+// @param uuid path string true "UUID of the todo"
+// @param id path string true "ID of the comment"
+// @success 200 {object} map[string]string
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) deleteComment(c *gin.Context) {
+	if err := s.DeleteComment(requestIdentity(c), strings.TrimSpace(c.Param("uuid")), c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "comment deleted"})
+}
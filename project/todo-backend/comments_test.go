@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComments_Lifecycle(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("alice", TodoInput{Description: "discuss me"})
+	require.NoError(t, err)
+	base := "/todos/" + todo.UUID + "/comments"
+
+	w := apiRequest(router, http.MethodPost, base, "bob", `{"body":"  looks good  "}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var comment Comment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &comment))
+	assert.Equal(t, "bob", comment.Author)
+	assert.Equal(t, "looks good", comment.Body)
+
+	got, err := s.Get(todo.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.CommentCount)
+
+	// Only the author can edit or delete
+	w = apiRequest(router, http.MethodPatch, base+"/"+comment.ID, "alice", `{"body":"hijacked"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = apiRequest(router, http.MethodDelete, base+"/"+comment.ID, "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = apiRequest(router, http.MethodPatch, base+"/"+comment.ID, "bob", `{"body":"looks great"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &comment))
+	assert.Equal(t, "looks great", comment.Body)
+
+	w = apiRequest(router, http.MethodGet, base, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var comments []Comment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &comments))
+	require.Len(t, comments, 1)
+	assert.Equal(t, "looks great", comments[0].Body)
+
+	w = apiRequest(router, http.MethodDelete, base+"/"+comment.ID, "bob", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	got, err = s.Get(todo.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, got.CommentCount)
+
+	w = apiRequest(router, http.MethodDelete, base+"/"+comment.ID, "bob", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestComments_BadRequests(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("", TodoInput{Description: "discuss me"})
+	require.NoError(t, err)
+
+	w := apiRequest(router, http.MethodPost, "/todos/"+todo.UUID+"/comments", "", `{"body":"   "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "body", decodeProblem(t, w).Errors[0].Field)
+
+	w = apiRequest(router, http.MethodPost, "/todos/"+todo.UUID+"/comments", "", `{"body":"`+strings.Repeat("x", COMMENTMAXLENGTH+1)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = apiRequest(router, http.MethodPost, "/todos/non-existent-uuid/comments", "", `{"body":"hello"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = negotiationRequest(router, http.MethodPost, "/todos/"+todo.UUID+"/comments", "application/xml", "", "<body>hello</body>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	w = negotiationRequest(router, http.MethodPost, "/todos/"+todo.UUID+"/comments", "application/yaml", "", "body: hello\n")
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestDeleteTodo_RemovesComments(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "discuss me"})
+	require.NoError(t, err)
+	_, err = s.AddComment("", todo.UUID, "hello")
+	require.NoError(t, err)
+
+	_, err = s.Delete("", todo.UUID)
+	require.NoError(t, err)
+	assert.Empty(t, s.comments)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	// The container is built from scratch, which has no zoneinfo for the tz parameter
+	_ "time/tzdata"
+)
+
+// Due dates can be typed into the description, e.g. "deploy staging tomorrow 5pm". When
+// asked to (POST /todos?parse_dates=true or the Parse-Dates: true header), the first date
+// expression is taken out of the description and becomes due_at. Understood are:
+//
+//	today, tomorrow, monday … sunday, next monday … next sunday
+//	in 3 days, in a week, in 2 hours (also minutes and weeks)
+//	2026-10-20 and RFC 3339 date-times
+//
+// optionally preceded by on, by, due or at, and followed or preceded by a time of day
+// (5pm, 5:30 pm, 17:00, noon). A weekday means the first one after today. A date without
+// a time is due at the end of that day, and a time without a date at its next occurrence.
+// Dates are read in the time zone given by the tz parameter, UTC by default.
+
+// parseDatesHeader turns on due date parsing like the parse_dates query parameter.
+const parseDatesHeader = "Parse-Dates"
+
+// parsedDueDateHeader reports the expression that was taken out of the description.
+const parsedDueDateHeader = "Parsed-Due-Date"
+
+// dateConnectives may precede a date or time and are removed with it.
+var dateConnectives = map[string]bool{"on": true, "by": true, "due": true, "at": true}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+}
+
+var (
+	clockPattern    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)$`)
+	clock24Pattern  = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+	hourOnlyPattern = regexp.MustCompile(`^\d{1,2}(?::\d{2})?$`)
+)
+
+// ParsedDueDate is a date expression found in a description.
+type ParsedDueDate struct {
+	Expression  string    // the words taken out, as typed
+	DueAt       time.Time // in UTC
+	Description string    // the description without the expression
+}
+
+// parseDueDate finds the first date expression in the text. It reports false if there is
+// none, or if nothing else would be left of the description.
+func parseDueDate(text string, now time.Time, loc *time.Location) (ParsedDueDate, bool) {
+	words := strings.Fields(text)
+	tokens := make([]string, len(words))
+	for i, w := range words {
+		tokens[i] = strings.ToLower(strings.TrimRight(w, ",.;!?"))
+	}
+	now = now.In(loc)
+
+	for i := range tokens {
+		start := i
+		if dateConnectives[tokens[i]] {
+			start = i + 1
+		}
+		due, end, ok := matchDueDate(tokens, start, now)
+		if !ok {
+			continue
+		}
+		rest := append(append([]string{}, words[:i]...), words[end:]...)
+		if len(rest) == 0 {
+			return ParsedDueDate{}, false
+		}
+		return ParsedDueDate{
+			Expression:  strings.Join(words[i:end], " "),
+			DueAt:       due.UTC(),
+			Description: strings.Join(rest, " "),
+		}, true
+	}
+	return ParsedDueDate{}, false
+}
+
+// matchDueDate matches a date and/or time at tokens[i:], returning the due date and the
+// position after the expression.
+func matchDueDate(tokens []string, i int, now time.Time) (time.Time, int, bool) {
+	if i >= len(tokens) {
+		return time.Time{}, 0, false
+	}
+
+	// "in 3 days" is a complete expression of its own
+	if due, n, ok := matchRelative(tokens[i:], now); ok {
+		return due, i + n, true
+	}
+
+	if day, n, ok := matchDay(tokens[i:], now); ok {
+		end := i + n
+		at := end
+		if at < len(tokens) && tokens[at] == "at" {
+			at++
+		}
+		if h, m, n, ok := matchClock(tokens[at:]); ok {
+			return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, now.Location()), at + n, true
+		}
+		if day.Hour() != 0 || day.Minute() != 0 {
+			// An RFC 3339 date-time carries its own time
+			return day, end, true
+		}
+		return endOfDay(day), end, true
+	}
+
+	if h, m, n, ok := matchClock(tokens[i:]); ok {
+		end := i + n
+		on := end
+		if on < len(tokens) && dateConnectives[tokens[on]] {
+			on++
+		}
+		if day, n, ok := matchDay(tokens[on:], now); ok && day.Hour() == 0 && day.Minute() == 0 {
+			return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, now.Location()), on + n, true
+		}
+		due := time.Date(now.Year(), now.Month(), now.Day(), h, m, 0, 0, now.Location())
+		if !due.After(now) {
+			due = due.AddDate(0, 0, 1)
+		}
+		return due, end, true
+	}
+	return time.Time{}, 0, false
+}
+
+// matchDay matches today, tomorrow, a weekday or an ISO date, returning midnight of that
+// day (or the exact instant of an RFC 3339 date-time).
+func matchDay(tokens []string, now time.Time) (time.Time, int, bool) {
+	if len(tokens) == 0 {
+		return time.Time{}, 0, false
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch tokens[0] {
+	case "today", "tonight":
+		return today, 1, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), 1, true
+	case "next":
+		if len(tokens) > 1 {
+			if wd, ok := weekdays[tokens[1]]; ok {
+				return nextWeekday(today, wd), 2, true
+			}
+		}
+		return time.Time{}, 0, false
+	}
+	if wd, ok := weekdays[tokens[0]]; ok {
+		return nextWeekday(today, wd), 1, true
+	}
+	if d, err := time.ParseInLocation("2006-01-02", tokens[0], now.Location()); err == nil {
+		return d, 1, true
+	}
+	// Tokens were lowercased, RFC 3339 wants the T and Z in upper case
+	if d, err := time.Parse(time.RFC3339, strings.ToUpper(tokens[0])); err == nil {
+		return d.In(now.Location()), 1, true
+	}
+	return time.Time{}, 0, false
+}
+
+// matchRelative matches "in <n> <unit>".
+func matchRelative(tokens []string, now time.Time) (time.Time, int, bool) {
+	if len(tokens) < 3 || tokens[0] != "in" {
+		return time.Time{}, 0, false
+	}
+	n, ok := numberWords[tokens[1]]
+	if !ok {
+		var err error
+		if n, err = strconv.Atoi(tokens[1]); err != nil || n < 0 || n > 3650 {
+			return time.Time{}, 0, false
+		}
+	}
+
+	switch strings.TrimSuffix(tokens[2], "s") {
+	case "minute", "min":
+		return now.Add(time.Duration(n) * time.Minute), 3, true
+	case "hour":
+		return now.Add(time.Duration(n) * time.Hour), 3, true
+	case "day":
+		return endOfDay(now.AddDate(0, 0, n)), 3, true
+	case "week":
+		return endOfDay(now.AddDate(0, 0, 7*n)), 3, true
+	}
+	return time.Time{}, 0, false
+}
+
+// matchClock matches a time of day such as 5pm, 5 pm, 5:30pm, 17:00 or noon.
+func matchClock(tokens []string) (hour, minute, n int, ok bool) {
+	if len(tokens) == 0 {
+		return 0, 0, 0, false
+	}
+	if tokens[0] == "noon" {
+		return 12, 0, 1, true
+	}
+	if tokens[0] == "midnight" {
+		return 0, 0, 1, true
+	}
+
+	token, n := tokens[0], 1
+	if len(tokens) > 1 && (tokens[1] == "am" || tokens[1] == "pm") && hourOnlyPattern.MatchString(token) {
+		token, n = token+tokens[1], 2
+	}
+	if m := clockPattern.FindStringSubmatch(token); m != nil {
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		if hour < 1 || hour > 12 || minute > 59 {
+			return 0, 0, 0, false
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+		return hour, minute, n, true
+	}
+	if m := clock24Pattern.FindStringSubmatch(tokens[0]); m != nil {
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		if hour > 23 || minute > 59 {
+			return 0, 0, 0, false
+		}
+		return hour, minute, 1, true
+	}
+	return 0, 0, 0, false
+}
+
+// nextWeekday returns the first day after today falling on the weekday.
+func nextWeekday(today time.Time, wd time.Weekday) time.Time {
+	days := (int(wd) - int(today.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+	return today.AddDate(0, 0, days)
+}
+
+// endOfDay is when a todo due on a day without a time is due.
+func endOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 0, 0, t.Location())
+}
+
+// parseDatesRequested reports whether the client asked for due date parsing. It aborts
+// the request if the flag is not a boolean.
+func parseDatesRequested(c *gin.Context) (bool, bool) {
+	value := c.Query("parse_dates")
+	field := "parse_dates"
+	if value == "" {
+		value, field = c.GetHeader(parseDatesHeader), parseDatesHeader
+	}
+	if value == "" {
+		return false, true
+	}
+	parse, err := strconv.ParseBool(value)
+	if err != nil {
+		abortWithValidationError(c, field, "must be true or false")
+		return false, false
+	}
+	return parse, true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDueDate(t *testing.T) {
+	// A Wednesday afternoon
+	now := time.Date(2026, 10, 14, 15, 30, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		text        string
+		expression  string
+		description string
+		due         time.Time
+	}{
+		{"deploy staging tomorrow 5pm", "tomorrow 5pm", "deploy staging", at(10, 15, 17, 0)},
+		{"Pay rent today", "today", "Pay rent", at(10, 14, 23, 59)},
+		{"Retro next Friday at 10:30am", "next Friday at 10:30am", "Retro", at(10, 16, 10, 30)},
+		{"call Bob on wednesday", "on wednesday", "call Bob", at(10, 21, 23, 59)},
+		{"renew cert in 3 days", "in 3 days", "renew cert", at(10, 17, 23, 59)},
+		{"check build in 2 hours", "in 2 hours", "check build", at(10, 14, 17, 30)},
+		{"release due 2026-11-02", "due 2026-11-02", "release", at(11, 2, 23, 59)},
+		{"standup at 9 am", "at 9 am", "standup", at(10, 15, 9, 0)},
+		{"lunch at noon tomorrow with team", "at noon tomorrow", "lunch with team", at(10, 15, 12, 0)},
+		{"demo 2026-10-20T08:00:00Z", "2026-10-20T08:00:00Z", "demo", at(10, 20, 8, 0)},
+	}
+	for _, tt := range tests {
+		parsed, ok := parseDueDate(tt.text, now, time.UTC)
+		require.True(t, ok, tt.text)
+		assert.Equal(t, tt.expression, parsed.Expression, tt.text)
+		assert.Equal(t, tt.description, parsed.Description, tt.text)
+		assert.Equal(t, tt.due, parsed.DueAt, tt.text)
+	}
+
+	for _, text := range []string{"buy 5 apples", "read chapter 12:99", "tomorrow", "fix the due date parser"} {
+		_, ok := parseDueDate(text, now, time.UTC)
+		assert.False(t, ok, text)
+	}
+}
+
+func TestParseDueDate_TimeZone(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	require.NoError(t, err)
+	// 23:30 UTC is already the next day in Helsinki
+	now := time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC)
+
+	parsed, ok := parseDueDate("sauna tomorrow 6pm", now, helsinki)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 10, 16, 15, 0, 0, 0, time.UTC), parsed.DueAt)
+}
+
+func TestCreateTodo_ParseDates(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	post := func(path, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/todos?parse_dates=true&tz=Europe/Helsinki", `{"description":"deploy staging tomorrow 5pm"}`, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "tomorrow 5pm", w.Header().Get(parsedDueDateHeader))
+	todo := decodeTodo(t, w)
+	assert.Equal(t, "deploy staging", todo.Description)
+	require.NotNil(t, todo.DueAt)
+	helsinki, _ := time.LoadLocation("Europe/Helsinki")
+	assert.Equal(t, 17, todo.DueAt.In(helsinki).Hour())
+
+	w = post("/todos", `{"description":"review in 2 days"}`, http.Header{parseDatesHeader: {"true"}})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "review", decodeTodo(t, w).Description)
+
+	// Without the flag, or with an explicit due_at, the description is kept as typed
+	w = post("/todos", `{"description":"deploy staging tomorrow"}`, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(parsedDueDateHeader))
+	assert.Equal(t, "deploy staging tomorrow", decodeTodo(t, w).Description)
+
+	w = post("/todos?parse_dates=true", `{"description":"deploy staging tomorrow","due_at":"2026-12-01T00:00:00Z"}`, nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "deploy staging tomorrow", created.Description)
+
+	w = post("/todos?parse_dates=true&tz=Mars/Olympus", `{"description":"climb tomorrow"}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Everything todo-backend writes to the volume (attachments, their metadata, the job
+// queue and the backups) is encrypted with envelope encryption when ENCRYPTION_KEYS_FILE
+// names a key file, typically mounted from a Secret. Every file gets its own random data
+// key, which encrypts the contents with AES-256-GCM and is itself encrypted ("wrapped")
+// with the primary key of the file. Files are bound to their place in the store, so
+// swapping two encrypted files is detected as tampering just like a flipped bit.
+//
+// The key file has one key per line, "<id> <base64 of 32 bytes>"; the first key is the
+// primary one used for writing, the others are only used to decrypt. To rotate, add a new
+// key at the top, restart, and run POST /admin/rekey to rewrap the data keys of existing
+// files with it; then the old key can be removed. Files are also re-encrypted whenever
+// they are written.
+//
+// Unencrypted files are refused, since an attacker could replace an encrypted file with
+// one. To encrypt existing data, start once with ENCRYPTION_ALLOW_PLAINTEXT=true and rekey.
+
+// sealedMagic starts every encrypted file, followed by the format version.
+var sealedMagic = []byte("TDE\x01")
+
+const (
+	dataKeySize  = 32
+	gcmNonceSize = 12
+	wrappedSize  = gcmNonceSize + dataKeySize + 16
+)
+
+// ErrTampered is returned when an encrypted file fails authentication.
+var ErrTampered = errors.New("encrypted data was tampered with or is corrupt")
+
+// ErrUnknownKey is returned for files encrypted with a key that is not in the keyring.
+var ErrUnknownKey = errors.New("data is encrypted with an unknown key")
+
+// ErrPlaintext is returned for unencrypted files when encryption is enabled.
+var ErrPlaintext = errors.New("data is not encrypted")
+
+// ErrEncryptionDisabled is returned for encrypted files when no keys are configured.
+var ErrEncryptionDisabled = errors.New("encryption at rest is not enabled")
+
+// Keyring holds the keys wrapping the data keys. A nil Keyring stores data unencrypted.
+type Keyring struct {
+	keys    map[string]cipher.AEAD
+	primary string
+	// AllowPlaintext accepts unencrypted files, to migrate existing data.
+	AllowPlaintext bool
+}
+
+// ParseKeyring reads keys in the format of the key file.
+func ParseKeyring(r io.Reader) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, " ")
+		if !ok || id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("line %d: want \"<id> <base64 key>\"", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("line %d: key %q is not 32 bytes of base64", line, id)
+		}
+		if _, ok := k.keys[id]; ok {
+			return nil, fmt.Errorf("line %d: key %q is listed twice", line, id)
+		}
+		if k.keys[id], err = newGCM(key); err != nil {
+			return nil, err
+		}
+		if k.primary == "" {
+			k.primary = id
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if k.primary == "" {
+		return nil, errors.New("no keys")
+	}
+	return k, nil
+}
+
+// keyringFromEnv loads the keys in ENCRYPTION_KEYS_FILE, or returns nil if it is not set.
+// A missing file is an error rather than a reason to write plaintext.
+func keyringFromEnv() (*Keyring, error) {
+	path := os.Getenv("ENCRYPTION_KEYS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	k, err := ParseKeyring(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	k.AllowPlaintext = os.Getenv("ENCRYPTION_ALLOW_PLAINTEXT") == "true"
+	return k, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts plaintext for the given context, which names the place of the data in
+// the store. A nil Keyring returns the plaintext.
+func (k *Keyring) Seal(plaintext []byte, context string) ([]byte, error) {
+	if k == nil {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := k.wrap(dataKey, context)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, dataAAD(context)), nil
+}
+
+// Open decrypts data sealed for the context.
+func (k *Keyring) Open(data []byte, context string) ([]byte, error) {
+	if !isSealed(data) {
+		if k != nil && !k.AllowPlaintext {
+			return nil, ErrPlaintext
+		}
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrEncryptionDisabled
+	}
+
+	_, dataKey, rest, err := k.unwrap(data, context)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcmNonceSize {
+		return nil, ErrTampered
+	}
+	plaintext, err := aead.Open(nil, rest[:gcmNonceSize], rest[gcmNonceSize:], dataAAD(context))
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return plaintext, nil
+}
+
+// Rewrap returns the data with its data key wrapped by the primary key, leaving the
+// encrypted contents as they are. Unencrypted data is sealed. It reports whether anything
+// changed.
+func (k *Keyring) Rewrap(data []byte, context string) ([]byte, bool, error) {
+	if !isSealed(data) {
+		if !k.AllowPlaintext {
+			return nil, false, ErrPlaintext
+		}
+		sealed, err := k.Seal(data, context)
+		return sealed, true, err
+	}
+	keyID, dataKey, rest, err := k.unwrap(data, context)
+	if err != nil {
+		return nil, false, err
+	}
+	if keyID == k.primary {
+		return data, false, nil
+	}
+	out, err := k.wrap(dataKey, context)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(out, rest...), true, nil
+}
+
+// wrap returns the header of a sealed file: the magic, the id of the primary key and the
+// data key encrypted with it.
+func (k *Keyring) wrap(dataKey []byte, context string) ([]byte, error) {
+	header := append(append(append([]byte{}, sealedMagic...), byte(len(k.primary))), k.primary...)
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(header, nonce...)
+	return k.keys[k.primary].Seal(out, nonce, dataKey, wrapAAD(header, context)), nil
+}
+
+// unwrap decrypts the data key of a sealed file, returning the id of the key that wrapped
+// it and the rest of the file (the nonce and the encrypted contents).
+func (k *Keyring) unwrap(data []byte, context string) (string, []byte, []byte, error) {
+	n := len(sealedMagic)
+	if len(data) < n+1 {
+		return "", nil, nil, ErrTampered
+	}
+	idLen := int(data[n])
+	headerLen := n + 1 + idLen
+	if len(data) < headerLen+wrappedSize {
+		return "", nil, nil, ErrTampered
+	}
+	keyID := string(data[n+1 : headerLen])
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, keyID)
+	}
+	wrapped := data[headerLen : headerLen+wrappedSize]
+	dataKey, err := aead.Open(nil, wrapped[:gcmNonceSize], wrapped[gcmNonceSize:], wrapAAD(data[:headerLen], context))
+	if err != nil {
+		return "", nil, nil, ErrTampered
+	}
+	return keyID, dataKey, data[headerLen+wrappedSize:], nil
+}
+
+// The data key is bound to the key id and the context, the contents only to the
+// context, so rewrapping does not touch them.
+func wrapAAD(header []byte, context string) []byte {
+	return append(append(append([]byte{}, header...), 0), context...)
+}
+
+func dataAAD(context string) []byte {
+	return append(append(append([]byte{}, sealedMagic...), 0), context...)
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedMagic)
+}
+
+// readSealedFile reads and decrypts a file written by writeSealedFile.
+func readSealedFile(k *Keyring, path, context string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := k.Open(data, context)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plaintext, nil
+}
+
+// writeSealedFile encrypts data and writes it atomically.
+func writeSealedFile(k *Keyring, path, context string, data []byte) error {
+	sealed, err := k.Seal(data, context)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, sealed)
+}
+
+// rewrapFile rewraps the data key of a file with the primary key, reporting whether
+// the file changed.
+func rewrapFile(k *Keyring, path, context string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	out, changed, err := k.Rewrap(data, context)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, writeFileAtomic(path, out)
+}
+
+// RekeyResult counts the files a rekey went through.
+type RekeyResult struct {
+	Rewrapped int `json:"rewrapped"`
+	Unchanged int `json:"unchanged"`
+}
+
+func (r *RekeyResult) add(changed bool) {
+	if changed {
+		r.Rewrapped++
+	} else {
+		r.Unchanged++
+	}
+}
+
+// Rekey rewraps the data keys of all persisted files with the primary key.
+func (s *TodoMgr) Rekey() (RekeyResult, error) {
+	var result RekeyResult
+	if s.Keys == nil {
+		return result, ErrEncryptionDisabled
+	}
+	if s.Jobs != nil {
+		changed, err := s.Jobs.Rekey()
+		if err != nil {
+			return result, err
+		}
+		result.add(changed)
+	}
+	if s.Attachments != nil {
+		if err := s.Attachments.Rekey(&result); err != nil {
+			return result, err
+		}
+	}
+	if s.Backups != nil {
+		if err := s.Backups.Rekey(&result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// writeFileAtomic writes data to path via a temp file and a rename.
+func writeFileAtomic(path string, data []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp.*")
+	if err != nil {
+		return err
+	}
+	defer tempFile.Close()
+	defer os.Remove(tempFile.Name()) // Clean up the temp file on any error
+
+	if _, err := tempFile.Write(data); err != nil {
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+// postRekey rewraps the data keys of all persisted files with the primary key, using
+// rekey: TodoMgr.Rekey, or TenantRegistry.Rekey with tenants.
+// @success 200 {object} RekeyResult
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+// @failure 500 {object} Problem
+func postRekey(rekey func() (RekeyResult, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := rekey()
+		if err != nil {
+			if !errors.Is(err, ErrEncryptionDisabled) {
+				log.Printf("Rekey failed after %d files: %v", result.Rewrapped+result.Unchanged, err)
+			}
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyring returns a keyring with keys of the given ids, the first being the primary one.
+// The same id always gets the same key.
+func testKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+	var file strings.Builder
+	for _, id := range ids {
+		key := bytes.Repeat([]byte(id[:1]), 32)
+		file.WriteString(id + " " + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	k, err := ParseKeyring(strings.NewReader(file.String()))
+	require.NoError(t, err)
+	return k
+}
+
+func TestParseKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	k, err := ParseKeyring(strings.NewReader("# rotated 2026-10\n\nnew " + key + "\nold " + key + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", k.primary)
+	assert.Len(t, k.keys, 2)
+
+	for _, file := range []string{"", "# nothing\n", "short " + base64.StdEncoding.EncodeToString([]byte("too short")), "nokey\n", "a " + key + "\na " + key} {
+		_, err := ParseKeyring(strings.NewReader(file))
+		assert.Error(t, err, file)
+	}
+}
+
+func TestKeyringFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ENCRYPTION_KEYS_FILE", filepath.Join(dir, "keys"))
+	_, err := keyringFromEnv()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	t.Setenv("ENCRYPTION_KEYS_FILE", "")
+	k, err := keyringFromEnv()
+	require.NoError(t, err)
+	assert.Nil(t, k)
+
+	t.Setenv("ENCRYPTION_KEYS_FILE", filepath.Join(dir, "keys"))
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "keys"), []byte("k1 "+key+"\n"), 0o600))
+	k, err = keyringFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "k1", k.primary)
+}
+
+func TestKeyring_SealAndOpen(t *testing.T) {
+	k := testKeyring(t, "a")
+	plaintext := []byte("call Jane Doe at ACME")
+
+	sealed, err := k.Seal(plaintext, "ctx")
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "Jane")
+	opened, err := k.Open(sealed, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, opened)
+
+	// Every file gets its own data key and nonces
+	again, err := k.Seal(plaintext, "ctx")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	// A nil keyring stores plaintext, and cannot read encrypted data
+	var none *Keyring
+	stored, err := none.Seal(plaintext, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, stored)
+	_, err = none.Open(sealed, "ctx")
+	assert.ErrorIs(t, err, ErrEncryptionDisabled)
+}
+
+func TestKeyring_DetectsTampering(t *testing.T) {
+	k := testKeyring(t, "a")
+	sealed, err := k.Seal([]byte("secret"), "ctx")
+	require.NoError(t, err)
+
+	// Flip a bit anywhere past the magic: the key id, the wrapped key, the nonce or the data
+	for i := len(sealedMagic); i < len(sealed); i++ {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 1
+		_, err := k.Open(tampered, "ctx")
+		assert.Error(t, err, "byte %d", i)
+	}
+
+	_, err = k.Open(sealed[:len(sealed)-1], "ctx")
+	assert.ErrorIs(t, err, ErrTampered)
+	_, err = k.Open(sealed[:10], "ctx")
+	assert.ErrorIs(t, err, ErrTampered)
+
+	// A file moved to another place in the store
+	_, err = k.Open(sealed, "other")
+	assert.ErrorIs(t, err, ErrTampered)
+
+	// A file replaced by an unencrypted one
+	_, err = k.Open([]byte("[]"), "ctx")
+	assert.ErrorIs(t, err, ErrPlaintext)
+	k.AllowPlaintext = true
+	opened, err := k.Open([]byte("[]"), "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("[]"), opened)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old := testKeyring(t, "a")
+	sealed, err := old.Seal([]byte("secret"), "ctx")
+	require.NoError(t, err)
+
+	_, err = testKeyring(t, "b").Open(sealed, "ctx")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	rotated := testKeyring(t, "b", "a")
+	opened, err := rotated.Open(sealed, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), opened)
+
+	rewrapped, changed, err := rotated.Rewrap(sealed, "ctx")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, changed, err = rotated.Rewrap(rewrapped, "ctx")
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// The old key is no longer needed
+	opened, err = testKeyring(t, "b").Open(rewrapped, "ctx")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), opened)
+
+	_, _, err = rotated.Rewrap([]byte("plain"), "ctx")
+	assert.ErrorIs(t, err, ErrPlaintext)
+}
+
+func TestEncryptedAttachmentsAndJobs(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	dir := t.TempDir()
+	jobsPath := filepath.Join(dir, "jobs.json")
+
+	// Data written before the rotation, partly before encryption was turned on
+	plain, err := NewJobQueue(jobsPath, 1, nil)
+	require.NoError(t, err)
+	_, err = plain.Enqueue("customer.call", map[string]string{"name": "Jane Doe"}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = NewJobQueue(jobsPath, 1, testKeyring(t, "a"))
+	assert.ErrorIs(t, err, ErrPlaintext)
+
+	s, router, todo := setupAttachmentTest(t, 1<<20, 1<<20)
+	s.Attachments.Keys = testKeyring(t, "a")
+	w := uploadAttachment(router, todo.UUID, "customers.txt", []byte("Jane Doe, ACME"))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var a Attachment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &a))
+
+	todoDir := filepath.Join(s.Attachments.dir, todo.UUID)
+	for _, name := range []string{a.ID, a.ID + ".json"} {
+		data, err := os.ReadFile(filepath.Join(todoDir, name))
+		require.NoError(t, err)
+		assert.True(t, isSealed(data), name)
+		assert.NotContains(t, string(data), "Jane")
+		assert.NotContains(t, string(data), "customers")
+	}
+
+	// Rotate to key b, reading unencrypted files while migrating
+	keys := testKeyring(t, "b", "a")
+	keys.AllowPlaintext = true
+	s.Keys = keys
+	s.Attachments.Keys = keys
+	s.Jobs, err = NewJobQueue(jobsPath, 1, keys)
+	require.NoError(t, err)
+	require.Len(t, s.Jobs.Jobs(""), 1)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/attachments/"+a.ID, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Jane Doe, ACME", w.Body.String())
+
+	rekey := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/rekey", nil)
+		req.Header.Set(identityHeader, user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusForbidden, rekey("alice").Code)
+	w = rekey("root")
+	require.Equal(t, http.StatusOK, w.Code)
+	var result RekeyResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, RekeyResult{Rewrapped: 3}, result)
+
+	w = rekey("root")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, RekeyResult{Unchanged: 3}, result)
+
+	// Everything can be read without the old key and without plaintext
+	b := testKeyring(t, "b")
+	jobs, err := NewJobQueue(jobsPath, 1, b)
+	require.NoError(t, err)
+	assert.Len(t, jobs.Jobs(""), 1)
+	s.Attachments.Keys = b
+	_, data, err := s.Attachments.Open(todo.UUID, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe, ACME", string(data))
+
+	// Swapping the data file with the metadata is detected on load
+	meta, err := os.ReadFile(filepath.Join(todoDir, a.ID+".json"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(todoDir, a.ID), meta, 0o600))
+	_, _, err = s.Attachments.Open(todo.UUID, a.ID)
+	assert.ErrorIs(t, err, ErrTampered)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/attachments/"+a.ID, nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRekey_EncryptionDisabled(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	req := httptest.NewRequest(http.MethodPost, "/admin/rekey", nil)
+	req.Header.Set(identityHeader, "root")
+	w := httptest.NewRecorder()
+	setupRouter(&TodoMgr{}).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
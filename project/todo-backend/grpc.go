@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"fazstrac/project/todo-backend/todopb"
+)
+
+// The gRPC API mirrors the REST API. The generated code in todopb/ comes from
+// proto/todo.proto; regenerate it with `buf generate` after editing the proto.
+
+// todoGRPCServer implements todopb.TodoServiceServer on top of TodoMgr.
+type todoGRPCServer struct {
+	todopb.UnimplementedTodoServiceServer
+	// mgr is nil with tenants, whose interceptors put the TodoMgr of the tenant into
+	// the context instead
+	mgr *TodoMgr
+}
+
+// mgrOf returns the TodoMgr serving the call.
+func (g *todoGRPCServer) mgrOf(ctx context.Context) *TodoMgr {
+	if s, ok := ctx.Value(tenantMgrKey{}).(*TodoMgr); ok {
+		return s
+	}
+	return g.mgr
+}
+
+// newGRPCServer returns a gRPC server with the todo service, health checking and reflection
+// registered. Like the REST API, it only takes the identity from calls of the proxies.
+func newGRPCServer(s *TodoMgr, proxies []netip.Prefix) *grpc.Server {
+	trust := grpcProxyTrust{proxies: proxies, headers: []string{identityHeader}}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(trust.unaryInterceptor, grpcCreateRateLimited(), s.maintenanceInterceptor),
+		grpc.StreamInterceptor(trust.streamInterceptor))
+	registerGRPCServices(server, &todoGRPCServer{mgr: s})
+	return server
+}
+
+// newTenantGRPCServer is newGRPCServer serving the tenants of the registry, named in the
+// metadata of the calls like in the headers of REST requests.
+func newTenantGRPCServer(reg *TenantRegistry, proxies []netip.Prefix) *grpc.Server {
+	trust := grpcProxyTrust{proxies: proxies, headers: []string{identityHeader, tenantHeader, accessTokenHeader}}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(trust.unaryInterceptor, grpcCreateRateLimited(), reg.tenantUnaryInterceptor),
+		grpc.ChainStreamInterceptor(trust.streamInterceptor, reg.tenantStreamInterceptor))
+	registerGRPCServices(server, &todoGRPCServer{})
+	return server
+}
+
+func registerGRPCServices(server *grpc.Server, g *todoGRPCServer) {
+	todopb.RegisterTodoServiceServer(server, g)
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(todopb.TodoService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	// Reflection lets tools like grpcurl discover the API without the proto file
+	reflection.Register(server)
+}
+
+func (g *todoGRPCServer) ListTodos(ctx context.Context, req *todopb.ListTodosRequest) (*todopb.ListTodosResponse, error) {
+	todos := g.mgrOf(ctx).List()
+
+	resp := &todopb.ListTodosResponse{Todos: make([]*todopb.Todo, 0, len(todos))}
+	for _, t := range todos {
+		resp.Todos = append(resp.Todos, todoToProto(t))
+	}
+	return resp, nil
+}
+
+func (g *todoGRPCServer) GetTodo(ctx context.Context, req *todopb.GetTodoRequest) (*todopb.Todo, error) {
+	t, err := g.mgrOf(ctx).Get(req.GetUuid())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return todoToProto(t), nil
+}
+
+func (g *todoGRPCServer) CreateTodo(ctx context.Context, req *todopb.CreateTodoRequest) (*todopb.Todo, error) {
+	in := TodoInput{Description: req.GetDescription(), RRule: req.GetRrule()}
+	if req.DueAt != nil {
+		dueAt := req.GetDueAt().AsTime()
+		in.DueAt = &dueAt
+	}
+	t, err := g.mgrOf(ctx).Create(grpcIdentity(ctx), in)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return todoToProto(t), nil
+}
+
+func (g *todoGRPCServer) UpdateTodo(ctx context.Context, req *todopb.UpdateTodoRequest) (*todopb.Todo, error) {
+	p := TodoPatch{Description: req.Description, Done: req.Done, RRule: req.Rrule}
+	if req.DueAt != nil {
+		dueAt := req.GetDueAt().AsTime()
+		p.DueAt = &dueAt
+	}
+	t, err := g.mgrOf(ctx).Update(grpcIdentity(ctx), req.GetUuid(), p)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return todoToProto(t), nil
+}
+
+func (g *todoGRPCServer) TransitionTodo(ctx context.Context, req *todopb.TransitionTodoRequest) (*todopb.Todo, error) {
+	t, err := g.mgrOf(ctx).Transition(grpcIdentity(ctx), req.GetUuid(), req.GetTo())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return todoToProto(t), nil
+}
+
+func (g *todoGRPCServer) DeleteTodo(ctx context.Context, req *todopb.DeleteTodoRequest) (*todopb.DeleteTodoResponse, error) {
+	if _, err := g.mgrOf(ctx).Delete(grpcIdentity(ctx), req.GetUuid()); err != nil {
+		return nil, grpcError(err)
+	}
+	return &todopb.DeleteTodoResponse{}, nil
+}
+
+// WatchTodos streams changes until the client goes away.
+func (g *todoGRPCServer) WatchTodos(req *todopb.WatchTodosRequest, stream grpc.ServerStreamingServer[todopb.TodoEvent]) error {
+	events, cancel := g.mgrOf(stream.Context()).Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(todoEventToProto(ev)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// grpcError maps an error returned by TodoMgr to a gRPC status, the same way
+// abortWithError maps it to a problem for the REST API.
+func grpcError(err error) error {
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		validationFailures.WithLabelValues(validationErr.Field).Inc()
+		st := status.New(codes.InvalidArgument, err.Error())
+		detailed, detailErr := st.WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: validationErr.Field, Description: validationErr.Message},
+			},
+		})
+		if detailErr != nil {
+			return st.Err()
+		}
+		return detailed.Err()
+	case errors.Is(err, ErrTodoNotFound), errors.Is(err, ErrAttachmentNotFound), errors.Is(err, ErrCommentNotFound),
+		errors.Is(err, ErrTemplateNotFound), errors.Is(err, ErrJobNotFound), errors.Is(err, ErrBackupNotFound),
+		errors.Is(err, ErrBackupsDisabled), errors.Is(err, ErrTenantNotFound), errors.Is(err, ErrShareNotFound),
+		errors.Is(err, ErrShareLinkInvalid), errors.Is(err, ErrEncryptionDisabled):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrTenantExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, ErrTransitionNotAllowed), errors.Is(err, ErrJobNotFailed), errors.Is(err, ErrTenantActive):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrNotCommentAuthor), errors.Is(err, ErrNotShareCreator), errors.Is(err, ErrNotTemplateOwner),
+		errors.Is(err, ErrTenantSuspended):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrAttachmentTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrNoTenant), errors.Is(err, ErrInvalidBackup):
+		return status.Error(codes.InvalidArgument, err.Error())
+	default:
+		// Like abortWithInternalError, keep file paths and internal errors from clients
+		log.Printf("gRPC call failed: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// grpcProxyTrust is the gRPC counterpart of trustProxies: it drops the metadata set by the
+// proxy from calls whose peer is not one of the proxies.
+type grpcProxyTrust struct {
+	proxies []netip.Prefix
+	headers []string
+}
+
+// context returns the context of a call, without the headers unless the peer is a proxy.
+func (t grpcProxyTrust) context(ctx context.Context) context.Context {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil && fromTrustedProxy(p.Addr.String(), t.proxies) {
+		return ctx
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	md = md.Copy()
+	for _, header := range t.headers {
+		md.Delete(header)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func (t grpcProxyTrust) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(t.context(ctx), req)
+}
+
+func (t grpcProxyTrust) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: t.context(ss.Context())})
+}
+
+// contextServerStream is a stream with a context replaced by an interceptor.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcIdentity is the gRPC counterpart of requestIdentity: the proxy passes the
+// authenticated user in the x-forwarded-user metadata, which grpcProxyTrust drops from
+// calls of other peers.
+func grpcIdentity(ctx context.Context) string {
+	values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(identityHeader))
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
+
+// grpcRateLimitKey is the gRPC counterpart of rateLimitKey, keyed by the peer IP for
+// anonymous calls.
+func grpcRateLimitKey(ctx context.Context) string {
+	if identity := grpcIdentity(ctx); identity != "" {
+		return "user:" + identity
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "ip:"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "ip:" + p.Addr.String()
+	}
+	return "ip:" + host
+}
+
+// grpcCreateRateLimited applies the limit of POST /todos to CreateTodo, so the gRPC API is
+// no way around it.
+func grpcCreateRateLimited() grpc.UnaryServerInterceptor {
+	create := rateLimitFromEnv("RATE_LIMIT_CREATE", defaultCreateRateLimit)
+	l := NewRateLimiter(rateLimitFromEnv(routeRateLimitEnv("POST /todos"), create))
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if info.FullMethod != todopb.TodoService_CreateTodo_FullMethodName {
+			return handler(ctx, req)
+		}
+		if d := l.take(grpcRateLimitKey(ctx)); !d.allowed {
+			return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %s seconds", ceilSeconds(d.retryAfter))
+		}
+		return handler(ctx, req)
+	}
+}
+
+func todoToProto(t Todo) *todopb.Todo {
+	return &todopb.Todo{
+		Uuid:            t.UUID,
+		Description:     t.Description,
+		Owner:           t.Owner,
+		State:           t.State,
+		Done:            t.Done,
+		DueAt:           timestampOrNil(t.DueAt),
+		CompletedAt:     timestampOrNil(t.CompletedAt),
+		CreatedAt:       timestamppb.New(t.CreatedAt),
+		ChangedAt:       timestamppb.New(t.ChangedAt),
+		Rrule:           t.RRule,
+		RecurrenceStart: timestampOrNil(t.RecurrenceStart),
+		NextUuid:        t.NextUUID,
+		CommentCount:    int32(t.CommentCount),
+		Links:           linksToProto(t.Links),
+	}
+}
+
+func linksToProto(links []LinkPreview) []*todopb.LinkPreview {
+	out := make([]*todopb.LinkPreview, len(links))
+	for i, l := range links {
+		out[i] = &todopb.LinkPreview{
+			Url:         l.URL,
+			Status:      l.Status,
+			Title:       l.Title,
+			Description: l.Description,
+			Error:       l.Error,
+			FetchedAt:   timestampOrNil(l.FetchedAt),
+		}
+	}
+	return out
+}
+
+// timestampOrNil leaves optional times unset instead of sending the zero time.
+func timestampOrNil(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func todoEventToProto(ev TodoEvent) *todopb.TodoEvent {
+	eventType := todopb.TodoEvent_TYPE_UNSPECIFIED
+	switch ev.Type {
+	case TodoCreated:
+		eventType = todopb.TodoEvent_TYPE_CREATED
+	case TodoUpdated:
+		eventType = todopb.TodoEvent_TYPE_UPDATED
+	case TodoDeleted:
+		eventType = todopb.TodoEvent_TYPE_DELETED
+	}
+	return &todopb.TodoEvent{Type: eventType, Todo: todoToProto(ev.Todo)}
+}
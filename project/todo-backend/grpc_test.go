@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"fazstrac/project/todo-backend/todopb"
+)
+
+// setupGRPCTest starts the gRPC server on an in-memory listener and returns a connected client.
+func setupGRPCTest(t *testing.T, s *TodoMgr) *grpc.ClientConn {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := newGRPCServer(s, nil)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func TestGRPC_CRUD(t *testing.T) {
+	s := &TodoMgr{}
+	client := todopb.NewTodoServiceClient(setupGRPCTest(t, s))
+	ctx := context.Background()
+
+	created, err := client.CreateTodo(ctx, &todopb.CreateTodoRequest{Description: "  buy milk  "})
+	require.NoError(t, err)
+	assert.Equal(t, "buy milk", created.GetDescription())
+	assert.NotEmpty(t, created.GetUuid())
+
+	// The REST side sees the same todo
+	assert.Equal(t, 1, len(s.todosSorted))
+
+	got, err := client.GetTodo(ctx, &todopb.GetTodoRequest{Uuid: created.GetUuid()})
+	require.NoError(t, err)
+	assert.Equal(t, created.GetUuid(), got.GetUuid())
+
+	updated, err := client.UpdateTodo(ctx, &todopb.UpdateTodoRequest{Uuid: created.GetUuid(), Description: proto.String("buy oat milk")})
+	require.NoError(t, err)
+	assert.Equal(t, "buy oat milk", updated.GetDescription())
+
+	list, err := client.ListTodos(ctx, &todopb.ListTodosRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetTodos(), 1)
+	assert.Equal(t, "buy oat milk", list.GetTodos()[0].GetDescription())
+
+	_, err = client.DeleteTodo(ctx, &todopb.DeleteTodoRequest{Uuid: created.GetUuid()})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(s.todosSorted))
+}
+
+func TestGRPC_Errors(t *testing.T) {
+	client := todopb.NewTodoServiceClient(setupGRPCTest(t, &TodoMgr{}))
+	ctx := context.Background()
+
+	_, err := client.CreateTodo(ctx, &todopb.CreateTodoRequest{Description: "   "})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	assert.Equal(t, "description", badRequest.GetFieldViolations()[0].GetField())
+
+	_, err = client.GetTodo(ctx, &todopb.GetTodoRequest{Uuid: "non-existent-uuid"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.DeleteTodo(ctx, &todopb.DeleteTodoRequest{Uuid: "non-existent-uuid"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPC_ErrorCodes(t *testing.T) {
+	assert.Equal(t, codes.NotFound, status.Code(grpcError(fmt.Errorf("template x: %w", ErrTemplateNotFound))))
+	assert.Equal(t, codes.NotFound, status.Code(grpcError(ErrEncryptionDisabled)))
+	assert.Equal(t, codes.PermissionDenied, status.Code(grpcError(ErrTenantSuspended)))
+	assert.Equal(t, codes.AlreadyExists, status.Code(grpcError(ErrTenantExists)))
+
+	// Internal errors are not passed on
+	st := status.Convert(grpcError(errors.New("open /app/data/jobs.json: permission denied")))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "internal error", st.Message())
+}
+
+func TestGRPC_WatchTodos(t *testing.T) {
+	s := &TodoMgr{}
+	client := todopb.NewTodoServiceClient(setupGRPCTest(t, s))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchTodos(ctx, &todopb.WatchTodosRequest{})
+	require.NoError(t, err)
+
+	// Wait until the server side has subscribed before making changes
+	require.Eventually(t, func() bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.subscribers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	created, err := s.Create("", TodoInput{Description: "watch me"})
+	require.NoError(t, err)
+	_, err = s.Delete("", created.UUID)
+	require.NoError(t, err)
+
+	ev, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, todopb.TodoEvent_TYPE_CREATED, ev.GetType())
+	assert.Equal(t, "watch me", ev.GetTodo().GetDescription())
+
+	ev, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, todopb.TodoEvent_TYPE_DELETED, ev.GetType())
+	assert.Equal(t, created.UUID, ev.GetTodo().GetUuid())
+}
+
+func TestGRPC_HealthCheck(t *testing.T) {
+	client := healthpb.NewHealthClient(setupGRPCTest(t, &TodoMgr{}))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: todopb.TodoService_ServiceDesc.ServiceName})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
+
+func TestGRPC_TransitionTodo(t *testing.T) {
+	s := &TodoMgr{}
+	client := todopb.NewTodoServiceClient(setupGRPCTest(t, s))
+	ctx := context.Background()
+
+	created, err := client.CreateTodo(ctx, &todopb.CreateTodoRequest{Description: "move me"})
+	require.NoError(t, err)
+
+	_, err = client.TransitionTodo(ctx, &todopb.TransitionTodoRequest{Uuid: created.GetUuid(), To: "review"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	moved, err := client.TransitionTodo(ctx, &todopb.TransitionTodoRequest{Uuid: created.GetUuid(), To: "in-progress"})
+	require.NoError(t, err)
+	assert.Equal(t, "in-progress", moved.GetState())
+}
+
+func TestGRPC_CreateRateLimited(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CREATE", "2/1m")
+	s := &TodoMgr{}
+	client := todopb.NewTodoServiceClient(setupGRPCTest(t, s))
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := client.CreateTodo(ctx, &todopb.CreateTodoRequest{Description: "spam"})
+		require.NoError(t, err)
+	}
+	_, err := client.CreateTodo(ctx, &todopb.CreateTodoRequest{Description: "spam"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Len(t, s.List(), 2)
+
+	// The user is only taken from the proxies, so naming one does not give a new budget
+	alice := metadata.AppendToOutgoingContext(ctx, "x-forwarded-user", "alice")
+	_, err = client.CreateTodo(alice, &todopb.CreateTodoRequest{Description: "mine"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Other calls have their own budget
+	_, err = client.ListTodos(ctx, &todopb.ListTodosRequest{})
+	require.NoError(t, err)
+}
+
+func TestGRPC_IdentityOnlyFromTrustedProxies(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &TodoMgr{}
+	server := newGRPCServer(s, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	alice := metadata.AppendToOutgoingContext(context.Background(), "x-forwarded-user", "alice")
+	created, err := todopb.NewTodoServiceClient(conn).CreateTodo(alice, &todopb.CreateTodoRequest{Description: "mine"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", created.GetOwner())
+
+	// Peers that are not proxies are anonymous
+	anonymous := todopb.NewTodoServiceClient(setupGRPCTest(t, s))
+	created, err = anonymous.CreateTodo(alice, &todopb.CreateTodoRequest{Description: "not alice's"})
+	require.NoError(t, err)
+	assert.Empty(t, created.GetOwner())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The probes run named checks: /healthz whether the process is alive, /readyz whether
+// the dependencies it needs to serve requests are available. Either answers 503 if a
+// check fails, with the result of every check.
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+
+	// healthCheckTimeout bounds the checks of a probe, well within the probe timeout
+	healthCheckTimeout = 2 * time.Second
+)
+
+// HealthCheck reports whether something the service depends on is usable.
+type HealthCheck func(ctx context.Context) error
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// HealthChecks is a set of named checks. The zero value has no checks and passes.
+type HealthChecks struct {
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// Add registers a check, replacing any check of the same name.
+func (h *HealthChecks) Add(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.checks == nil {
+		h.checks = map[string]HealthCheck{}
+	}
+	h.checks[name] = check
+}
+
+// Run runs the checks concurrently and reports whether all of them passed.
+func (h *HealthChecks) Run(ctx context.Context) (bool, map[string]CheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	h.mu.RLock()
+	results := make(map[string]CheckResult, len(h.checks))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := runCheck(ctx, check)
+			resultsMu.Lock()
+			results[name] = r
+			resultsMu.Unlock()
+		}()
+	}
+	h.mu.RUnlock()
+	wg.Wait()
+
+	for _, r := range results {
+		if r.Status != healthStatusOK {
+			return false, results
+		}
+	}
+	return true, results
+}
+
+// runCheck runs the check, giving up on it once ctx is done.
+func runCheck(ctx context.Context, check HealthCheck) CheckResult {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("no answer: %w", ctx.Err())
+	}
+	r := CheckResult{Status: healthStatusOK, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		r.Status, r.Error = healthStatusFail, err.Error()
+	}
+	return r
+}
+
+// healthHandler serves the checks as a probe: 200 if all of them pass, 503 otherwise.
+// @success 200 {object} map[string]any
+// @failure 503 {object} map[string]any
+func healthHandler(checks *HealthChecks) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ok, results := checks.Run(c.Request.Context())
+		if !ok {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": healthStatusFail, "checks": results})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": healthStatusOK, "checks": results})
+	}
+}
+
+// rLockWithin takes the read lock, trying until ctx is done, so that a deadlock fails the
+// check without leaving a goroutine blocked on the lock. The caller unlocks on success.
+func rLockWithin(ctx context.Context, mu *sync.RWMutex) error {
+	for !mu.TryRLock() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("lock not available: %w", ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// lockAvailable checks that the lock can be taken, so that a deadlock fails liveness.
+func lockAvailable(mu *sync.RWMutex) HealthCheck {
+	return func(ctx context.Context) error {
+		if err := rLockWithin(ctx, mu); err != nil {
+			return err
+		}
+		mu.RUnlock()
+		return nil
+	}
+}
+
+// dirWritable checks that files can be created in the directory.
+func dirWritable(dir string) HealthCheck {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthcheck-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// livenessChecks are the checks of /healthz.
+func (s *TodoMgr) livenessChecks() *HealthChecks {
+	checks := &HealthChecks{}
+	checks.Add("store", lockAvailable(&s.mu))
+	return checks
+}
+
+// readinessChecks are the checks of /readyz: the storage of the stores in use.
+func (s *TodoMgr) readinessChecks() *HealthChecks {
+	checks := &HealthChecks{}
+	if s.Attachments != nil {
+		checks.Add("attachments", dirWritable(s.Attachments.dir))
+	}
+	if s.Backups != nil {
+		checks.Add("backups", dirWritable(s.Backups.dir))
+	}
+	if s.Jobs != nil && s.Jobs.path != "" {
+		checks.Add("jobs", dirWritable(filepath.Dir(s.Jobs.path)))
+	}
+	return checks
+}
+
+// getReadiness reports whether todo-backend can serve requests, and whether it is read-only.
+// @success 200 {object} map[string]any
+// @failure 503 {object} map[string]any
+func (s *TodoMgr) getReadiness(c *gin.Context) {
+	ok, results := s.readinessChecks().Run(c.Request.Context())
+	m := s.Maintenance()
+	switch {
+	case !ok:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "maintenance": m, "checks": results})
+	case m.Enabled:
+		c.JSON(http.StatusOK, gin.H{"status": "read-only", "maintenance": m, "checks": results})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "maintenance": m, "checks": results})
+	}
+}
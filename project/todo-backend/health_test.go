@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecks_Run(t *testing.T) {
+	ok, results := (&HealthChecks{}).Run(context.Background())
+	assert.True(t, ok)
+	assert.Empty(t, results)
+
+	checks := &HealthChecks{}
+	checks.Add("fine", func(context.Context) error { return nil })
+	checks.Add("broken", func(context.Context) error { return errors.New("disk on fire") })
+	ok, results = checks.Run(context.Background())
+	assert.False(t, ok)
+	assert.Equal(t, healthStatusOK, results["fine"].Status)
+	assert.Equal(t, CheckResult{Status: healthStatusFail, Error: "disk on fire"}, results["broken"])
+
+	checks.Add("broken", func(context.Context) error { return nil })
+	ok, _ = checks.Run(context.Background())
+	assert.True(t, ok, "the check was replaced")
+}
+
+func TestHealthChecks_Deadlock(t *testing.T) {
+	s := &TodoMgr{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ok, results := s.livenessChecks().Run(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, healthStatusFail, results["store"].Status)
+	assert.NotEmpty(t, results["store"].Error)
+
+	// The check itself gives up on the lock instead of staying blocked on it
+	assert.ErrorIs(t, lockAvailable(&s.mu)(ctx), context.DeadlineExceeded)
+}
+
+func TestHealth_Probes(t *testing.T) {
+	dir := t.TempDir()
+	attachments, err := NewAttachmentStore(filepath.Join(dir, "attachments"), 0, 0)
+	require.NoError(t, err)
+	s := &TodoMgr{Attachments: attachments}
+	router := setupRouter(s)
+
+	probe := func(path string) (int, map[string]any) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatusOK, body["status"])
+	assert.Contains(t, body["checks"], "store")
+
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", body["status"])
+	assert.Contains(t, body["checks"], "attachments")
+	assert.NotContains(t, body["checks"], "backups")
+
+	// Storage going away makes todo-backend unready, but not dead
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "attachments")))
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "unavailable", body["status"])
+	attachmentsCheck := body["checks"].(map[string]any)["attachments"].(map[string]any)
+	assert.Equal(t, healthStatusFail, attachmentsCheck["status"])
+	assert.NotEmpty(t, attachmentsCheck["error"])
+	code, _ = probe("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
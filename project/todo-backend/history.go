@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Past states of the list are rebuilt by replaying the audit trail, which works as an
+// event-sourced history: every mutation of a todo, including its comment count, is an
+// event carrying the todo as it was afterwards. Replaying the events up to an instant
+// gives the list as it was then, in creation order.
+//
+// Design choice: the history lives in memory with the trail, so it starts with the process.
+
+// TodoChange is a todo that differs between the two ends of a diff.
+type TodoChange struct {
+	UUID   string   `json:"uuid"`
+	Fields []string `json:"fields"` // JSON names of the fields that differ
+	Before Todo     `json:"before"`
+	After  Todo     `json:"after"`
+}
+
+// TodoDiff is the response of GET /todos/diff.
+type TodoDiff struct {
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Added   []Todo       `json:"added"`
+	Changed []TodoChange `json:"changed"`
+	Removed []Todo       `json:"removed"`
+}
+
+// TodoChangeEvent is a change to a todo in the feed of GET /todos/changes.
+type TodoChangeEvent struct {
+	Seq    int64         `json:"seq"`
+	At     time.Time     `json:"at"`
+	Type   TodoEventType `json:"type"`
+	Todo   Todo          `json:"todo"`             // after the change, or before the deletion
+	Fields []string      `json:"fields,omitempty"` // for updates, the JSON fields that changed
+}
+
+// TodoChanges is a page of the change feed.
+type TodoChanges struct {
+	Changes []TodoChangeEvent `json:"changes"`
+	// Next is the cursor to pass as after for the following page.
+	Next int64 `json:"next"`
+	// More tells that further changes are waiting after Next.
+	More bool `json:"more"`
+	// Missed tells that changes after the cursor are gone, because they were folded out of
+	// the trail or todo-backend restarted since.
+	Missed bool `json:"missed"`
+}
+
+// defaultChangesLimit is how many changes GET /todos/changes returns unless asked for fewer.
+const defaultChangesLimit = 100
+
+// maxChangesLimit caps the limit of GET /todos/changes, which anyone may call, since each
+// change is worked out under s.mu.
+const maxChangesLimit = 1000
+
+// Changes returns the changes to the todos after the sequence number of the audit trail,
+// oldest first, up to limit. The feed is what watchers see: it holds nothing GET /todos
+// did not show when the change happened, so unlike the trail it names no actors and is not
+// for admins only. Comment events appear as updates of their todo. A negative cursor
+// returns no changes, only the cursor of the latest one.
+//
+// Design choice: the cursor is the sequence number rather than a time, since events are
+// numbered under s.mu in the order they are applied, while their timestamps are taken
+// before it.
+func (s *TodoMgr) Changes(after int64, limit int) TodoChanges {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := TodoChanges{Changes: []TodoChangeEvent{}}
+	n := len(s.auditTrail)
+	if n == 0 {
+		out.Missed = after > 0
+		return out
+	}
+	first, last := s.auditTrail[0].Seq, s.auditTrail[n-1].Seq
+	out.Next = last
+	if after < 0 {
+		return out
+	}
+	// A cursor from before a restart may be ahead of the trail, start over
+	if after > last || after < first-1 {
+		out.Missed = true
+		after = first - 1
+	}
+
+	start := int(after - first + 1)
+	end := min(start+limit, n)
+	for i := start; i < end; i++ {
+		out.Changes = append(out.Changes, s.changeEvent(i))
+	}
+	out.Next = after + int64(end-start)
+	out.More = end < n
+	return out
+}
+
+// changeEvent turns the i-th event of the audit trail into a change of the feed.
+// Caller must hold s.mu.
+func (s *TodoMgr) changeEvent(i int) TodoChangeEvent {
+	ev := s.auditTrail[i]
+	change := TodoChangeEvent{Seq: ev.Seq, At: ev.At, Type: TodoUpdated, Todo: ev.Todo}
+	switch ev.Action {
+	case AuditTodoCreated:
+		change.Type = TodoCreated
+		return change
+	case AuditTodoDeleted:
+		change.Type = TodoDeleted
+		return change
+	}
+
+	// The previous state is the one of the last earlier event of the todo
+	for j := i - 1; j >= 0; j-- {
+		if prev := s.auditTrail[j]; prev.TodoUUID == ev.TodoUUID {
+			change.Fields = changedFields(prev.Todo, ev.Todo)
+			return change
+		}
+	}
+	for _, t := range s.auditBase {
+		if t.UUID == ev.TodoUUID {
+			change.Fields = changedFields(t, ev.Todo)
+			break
+		}
+	}
+	return change
+}
+
+// ListAsOf returns the todos as they were at the instant, in creation order.
+func (s *TodoMgr) ListAsOf(at time.Time) []Todo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.replay(at)
+}
+
+// Diff compares the list at from with the list at to. A todo created and deleted
+// in between is in neither.
+func (s *TodoMgr) Diff(from, to time.Time) TodoDiff {
+	s.mu.RLock()
+	before := s.replay(from)
+	after := s.replay(to)
+	s.mu.RUnlock()
+
+	diff := TodoDiff{From: from, To: to, Added: []Todo{}, Changed: []TodoChange{}, Removed: []Todo{}}
+	old := make(map[string]Todo, len(before))
+	for _, t := range before {
+		old[t.UUID] = t
+	}
+	for _, t := range after {
+		prev, ok := old[t.UUID]
+		delete(old, t.UUID)
+		if !ok {
+			diff.Added = append(diff.Added, t)
+			continue
+		}
+		if fields := changedFields(prev, t); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, TodoChange{UUID: t.UUID, Fields: fields, Before: prev, After: t})
+		}
+	}
+	for _, t := range before {
+		if _, removed := old[t.UUID]; removed {
+			diff.Removed = append(diff.Removed, t)
+		}
+	}
+	return diff
+}
+
+// replay rebuilds the list from the audit events that happened at or before the instant.
+// Instants before the oldest kept event get the list as it was before that event.
+// Caller must hold s.mu.
+func (s *TodoMgr) replay(at time.Time) []Todo {
+	return applyAuditEvents(s.auditBase, s.auditTrail, at)
+}
+
+// changedFields lists the JSON fields of a todo that differ between two versions of it.
+func changedFields(before, after Todo) []string {
+	var a, b map[string]any
+	// Marshalling a Todo cannot fail
+	data, _ := json.Marshal(before)
+	json.Unmarshal(data, &a)
+	data, _ = json.Marshal(after)
+	json.Unmarshal(data, &b)
+
+	fields := []string{}
+	for k, v := range b {
+		if !reflect.DeepEqual(a[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// getTodoChanges pages through the changes to the todos, for watchers.
+// @param after query int false "Only changes with a larger seq; without it, only the cursor of the latest change"
+// @param limit query int false "Maximum number of changes, default 100, at most 1000"
+// @success 200 {object} TodoChanges
+// @failure 400 {object} Problem
+func (s *TodoMgr) getTodoChanges(c *gin.Context) {
+	after, err := strconv.ParseInt(c.DefaultQuery("after", "-1"), 10, 64)
+	if err != nil || (after < 0 && c.Query("after") != "") {
+		abortWithValidationError(c, "after", "must be a non-negative integer")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultChangesLimit)))
+	if err != nil || limit < 1 {
+		abortWithValidationError(c, "limit", "must be a positive integer")
+		return
+	}
+
+	renderTodoChanges(c, http.StatusOK, s.Changes(after, min(limit, maxChangesLimit)))
+}
+
+// parseInstant reads an RFC 3339 query parameter, returning def if it is absent.
+func parseInstant(c *gin.Context, name string, def time.Time) (time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return def, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		abortWithValidationError(c, name, "must be an RFC 3339 date-time")
+		return time.Time{}, false
+	}
+	return t.UTC(), true
+}
+
+// getTodosDiff shows which todos were added, changed and removed between two instants.
+// @param from query string true "Start (RFC 3339)"
+// @param to query string false "End (RFC 3339), defaults to now"
+// @success 200 {object} TodoDiff
+// @failure 400 {object} Problem
+func (s *TodoMgr) getTodosDiff(c *gin.Context) {
+	if c.Query("from") == "" {
+		abortWithValidationError(c, "from", "is required")
+		return
+	}
+	from, ok := parseInstant(c, "from", time.Time{})
+	if !ok {
+		return
+	}
+	to, ok := parseInstant(c, "to", time.Now().UTC())
+	if !ok {
+		return
+	}
+	if !from.Before(to) {
+		abortWithValidationError(c, "to", "must be after from")
+		return
+	}
+
+	renderTodoDiff(c, http.StatusOK, s.Diff(from, to))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupHistory records a day by day history: "a" created on the 1st and completed on the
+// 3rd, "b" created on the 2nd and deleted on the 4th, "c" created on the 4th.
+func setupHistory() (*TodoMgr, func(int) time.Time) {
+	s := &TodoMgr{}
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+
+	a := Todo{UUID: "a", Description: "first", State: "backlog", CreatedAt: day(1), ChangedAt: day(1)}
+	b := Todo{UUID: "b", Description: "second", State: "backlog", CreatedAt: day(2), ChangedAt: day(2)}
+	c := Todo{UUID: "c", Description: "third", State: "backlog", CreatedAt: day(4), ChangedAt: day(4)}
+	s.record(day(1), "", AuditTodoCreated, a, nil)
+	s.record(day(2), "", AuditTodoCreated, b, nil)
+	aDone := a
+	aDone.State, aDone.Done, aDone.ChangedAt = "done", true, day(3)
+	s.record(day(3), "", AuditTodoUpdated, aDone, nil)
+	s.record(day(4), "", AuditTodoDeleted, b, nil)
+	s.record(day(4), "", AuditTodoCreated, c, nil)
+	s.todosSorted = []Todo{aDone, c}
+	return s, day
+}
+
+func TestListAsOf(t *testing.T) {
+	s, day := setupHistory()
+
+	assert.Empty(t, s.ListAsOf(day(1).Add(-time.Second)))
+
+	list := s.ListAsOf(day(2))
+	require.Len(t, list, 2)
+	assert.Equal(t, "a", list[0].UUID)
+	assert.False(t, list[0].Done)
+	assert.Equal(t, "b", list[1].UUID)
+
+	list = s.ListAsOf(day(3))
+	require.Len(t, list, 2)
+	assert.True(t, list[0].Done)
+
+	// Replaying the whole history gives the current list
+	assert.Equal(t, s.List(), s.ListAsOf(day(5)))
+}
+
+func TestListAsOf_EventsOutOfTimeOrder(t *testing.T) {
+	s, day := setupHistory()
+	// A mutator takes its timestamp before it gets the lock, so a slower one can record
+	// an earlier instant after a later one
+	d := Todo{UUID: "d", Description: "late", State: "backlog", CreatedAt: day(2), ChangedAt: day(2)}
+	s.record(day(2), "", AuditTodoCreated, d, nil)
+
+	list := s.ListAsOf(day(2))
+	require.Len(t, list, 3)
+	assert.Equal(t, "d", list[2].UUID)
+}
+
+func TestDiff(t *testing.T) {
+	s, day := setupHistory()
+
+	diff := s.Diff(day(2), day(4))
+	require.Len(t, diff.Added, 1)
+	assert.Equal(t, "c", diff.Added[0].UUID)
+	require.Len(t, diff.Removed, 1)
+	assert.Equal(t, "b", diff.Removed[0].UUID)
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, "a", diff.Changed[0].UUID)
+	assert.Equal(t, []string{"changed_at", "done", "state"}, diff.Changed[0].Fields)
+	assert.False(t, diff.Changed[0].Before.Done)
+	assert.True(t, diff.Changed[0].After.Done)
+
+	// "b" came and went between the two instants
+	diff = s.Diff(day(1), day(5))
+	assert.Len(t, diff.Added, 1)
+	assert.Empty(t, diff.Removed)
+}
+
+func TestGetTodos_AsOf(t *testing.T) {
+	s, _ := setupHistory()
+	router := setupRouter(s)
+
+	// Like the change feed, the history is not for the admins only
+	w := apiRequest(router, http.MethodGet, "/todos?as_of=2026-10-02T13:00:00Z", "alice", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 2)
+	assert.Equal(t, "b", list[1].UUID)
+
+	assert.Equal(t, http.StatusBadRequest, apiRequest(router, http.MethodGet, "/todos?as_of=yesterday", "root", "").Code)
+}
+
+func TestGetTodosDiff(t *testing.T) {
+	s, _ := setupHistory()
+	router := setupRouter(s)
+
+	path := "/todos/diff?from=2026-10-02T13:00:00Z&to=2026-10-04T13:00:00Z"
+	w := apiRequest(router, http.MethodGet, path, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var diff TodoDiff
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &diff))
+	assert.Len(t, diff.Added, 1)
+	assert.Len(t, diff.Changed, 1)
+	assert.Len(t, diff.Removed, 1)
+
+	for _, query := range []string{
+		"",
+		"?from=2026-10-04T00:00:00Z&to=2026-10-01T00:00:00Z",
+		"?from=last-week",
+	} {
+		assert.Equal(t, http.StatusBadRequest, apiRequest(router, http.MethodGet, "/todos/diff"+query, "root", "").Code, query)
+	}
+}
+
+func TestGetTodoChanges(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	s, _ := setupHistory()
+	router := setupRouter(s)
+
+	get := func(path string) TodoChanges {
+		t.Helper()
+		w := apiRequest(router, http.MethodGet, path, "alice", "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var changes TodoChanges
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+		return changes
+	}
+
+	// Without a cursor only the latest one is returned
+	changes := get("/todos/changes")
+	assert.Empty(t, changes.Changes)
+	assert.Equal(t, int64(5), changes.Next)
+
+	changes = get("/todos/changes?after=1&limit=2")
+	require.Len(t, changes.Changes, 2)
+	assert.Equal(t, TodoCreated, changes.Changes[0].Type)
+	assert.Equal(t, "b", changes.Changes[0].Todo.UUID)
+	assert.Equal(t, TodoUpdated, changes.Changes[1].Type)
+	assert.Equal(t, []string{"changed_at", "done", "state"}, changes.Changes[1].Fields)
+	assert.Equal(t, int64(3), changes.Next)
+	assert.True(t, changes.More)
+	assert.False(t, changes.Missed)
+
+	changes = get("/todos/changes?after=3")
+	require.Len(t, changes.Changes, 2)
+	assert.Equal(t, TodoDeleted, changes.Changes[0].Type)
+	assert.Equal(t, int64(5), changes.Next)
+	assert.False(t, changes.More)
+	assert.Empty(t, get("/todos/changes?after=5").Changes)
+
+	// A cursor from before a restart starts over
+	changes = get("/todos/changes?after=42")
+	assert.True(t, changes.Missed)
+	assert.Len(t, changes.Changes, 5)
+
+	w := apiRequest(router, http.MethodGet, "/api/v2/todos/changes?after=4", "alice", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"self":"/api/v2/todos/c"`)
+
+	for _, query := range []string{"?after=-1", "?after=x", "?limit=0"} {
+		assert.Equal(t, http.StatusBadRequest, apiRequest(router, http.MethodGet, "/todos/changes"+query, "alice", "").Code, query)
+	}
+}
+
+func TestChanges_FoldedEventsAreMissed(t *testing.T) {
+	s := &TodoMgr{MaxAuditEvents: 4}
+	for range 10 {
+		_, err := s.Create("", TodoInput{Description: "x"})
+		require.NoError(t, err)
+	}
+
+	changes := s.Changes(0, 100)
+	assert.True(t, changes.Missed)
+	require.NotEmpty(t, changes.Changes)
+	assert.Equal(t, s.auditTrail[0].Seq, changes.Changes[0].Seq)
+	assert.Equal(t, int64(10), changes.Next)
+
+	changes = s.Changes(changes.Next-1, 100)
+	assert.False(t, changes.Missed)
+	assert.Len(t, changes.Changes, 1)
+}
+
+func TestGetTodoChanges_LimitIsCapped(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	for i := 0; i < maxChangesLimit+10; i++ {
+		_, err := s.Create("", TodoInput{Description: "todo " + strconv.Itoa(i)})
+		require.NoError(t, err)
+	}
+
+	w := apiRequest(router, http.MethodGet, "/todos/changes?after=0&limit=1000000", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var changes TodoChanges
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &changes))
+	assert.Len(t, changes.Changes, maxChangesLimit)
+	assert.Equal(t, int64(maxChangesLimit), changes.Next)
+	assert.True(t, changes.More)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idempotency keys let clients retry a POST safely. The first response for a key is
+// stored and replayed for every retry with the same key and body, until the key expires.
+// Replays carry the headers the handler set, such as Parsed-Due-Date, along with the
+// status and body. The behaviour follows the IETF "The Idempotency-Key HTTP Header Field"
+// draft:
+//   - same key, same body, first request finished: replay the stored response
+//   - same key, same body, first request still running: 409 Conflict
+//   - same key, different body: 422 Unprocessable Entity
+//
+// Design choice: the store is in memory, like the todos themselves. With several
+// replicas a shared store (e.g. Valkey / Redis) would be needed.
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotentBodySize caps the request bodies buffered to compare retries.
+const maxIdempotentBodySize = 1 << 20
+
+// defaultIdempotencyTTL is how long keys are remembered unless IDEMPOTENCY_TTL says otherwise.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+type idempotencyState int
+
+const (
+	idempotencyNew idempotencyState = iota
+	idempotencyInFlight
+	idempotencyReplay
+	idempotencyMismatch
+)
+
+type idempotencyEntry struct {
+	bodyHash  [sha256.Size]byte
+	done      bool
+	status    int
+	header    http.Header // set by the handler, Content-Type included
+	body      []byte
+	expiresAt time.Time
+}
+
+// IdempotencyStore remembers responses by idempotency key.
+type IdempotencyStore struct {
+	mu          sync.Mutex
+	ttl         time.Duration
+	entries     map[string]*idempotencyEntry
+	lastPurgeAt time.Time
+	now         func() time.Time // replaceable in tests
+}
+
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// idempotencyTTLFromEnv reads IDEMPOTENCY_TTL (a Go duration such as "30m").
+// Invalid values fall back to the default.
+func idempotencyTTLFromEnv() time.Duration {
+	value := os.Getenv("IDEMPOTENCY_TTL")
+	if value == "" {
+		return defaultIdempotencyTTL
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Printf("Invalid IDEMPOTENCY_TTL %q, using %v", value, defaultIdempotencyTTL)
+		return defaultIdempotencyTTL
+	}
+	return ttl
+}
+
+// begin looks up the key and, if it is unknown, reserves it for the caller.
+// The returned entry is only meaningful for idempotencyReplay.
+func (st *IdempotencyStore) begin(key string, bodyHash [sha256.Size]byte) (idempotencyState, idempotencyEntry) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := st.now()
+	st.purgeExpired(now)
+
+	entry, ok := st.entries[key]
+	if !ok || now.After(entry.expiresAt) {
+		st.entries[key] = &idempotencyEntry{bodyHash: bodyHash, expiresAt: now.Add(st.ttl)}
+		return idempotencyNew, idempotencyEntry{}
+	}
+
+	switch {
+	case entry.bodyHash != bodyHash:
+		return idempotencyMismatch, idempotencyEntry{}
+	case !entry.done:
+		return idempotencyInFlight, idempotencyEntry{}
+	default:
+		return idempotencyReplay, *entry
+	}
+}
+
+// finish stores the response for a key reserved with begin.
+func (st *IdempotencyStore) finish(key string, status int, header http.Header, body []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if entry, ok := st.entries[key]; ok {
+		entry.done = true
+		entry.status = status
+		entry.header = header
+		entry.body = body
+	}
+}
+
+// release forgets a key reserved with begin, so the request can be retried.
+func (st *IdempotencyStore) release(key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.entries, key)
+}
+
+// purgeExpired drops expired keys, at most once a minute.
+// Caller must hold st.mu.
+func (st *IdempotencyStore) purgeExpired(now time.Time) {
+	if now.Sub(st.lastPurgeAt) < time.Minute {
+		return
+	}
+	st.lastPurgeAt = now
+	for key, entry := range st.entries {
+		if now.After(entry.expiresAt) {
+			delete(st.entries, key)
+		}
+	}
+}
+
+// idempotent returns a middleware honouring the Idempotency-Key header.
+// Requests without the header are passed through untouched.
+func idempotent(st *IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWithProblem(c, NewProblem(http.StatusRequestEntityTooLarge, "request body is too large"))
+			return
+		}
+		if err != nil {
+			abortWithProblem(c, NewProblem(http.StatusBadRequest, "cannot read request body: "+err.Error()))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Keys are scoped to the client and the endpoint, so clients choosing the same key
+		// do not get each other's responses, and a key can be used on different routes
+		scopedKey := rateLimitKey(c) + " " + c.Request.Method + " " + c.FullPath() + " " + key
+
+		state, entry := st.begin(scopedKey, sha256.Sum256(body))
+		switch state {
+		case idempotencyMismatch:
+			abortWithProblem(c, NewProblem(http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body"))
+			return
+		case idempotencyInFlight:
+			abortWithProblem(c, NewProblem(http.StatusConflict, "a request with this Idempotency-Key is still being processed"))
+			return
+		case idempotencyReplay:
+			for name, values := range entry.header {
+				c.Writer.Header()[name] = values
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(entry.status, entry.header.Get("Content-Type"), entry.body)
+			c.Abort()
+			return
+		}
+
+		// Server errors and panics are not stored so the client can retry them. The recovery
+		// middleware runs outside this one, so the release must be deferred.
+		finished := false
+		defer func() {
+			if !finished {
+				st.release(scopedKey)
+			}
+		}()
+
+		// Headers set before this middleware, like the rate limits, are set again on replays
+		before := c.Writer.Header().Clone()
+		recorder := &teeResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = recorder.ResponseWriter
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		st.finish(scopedKey, c.Writer.Status(), handlerHeaders(before, c.Writer.Header()), recorder.body.Bytes())
+		finished = true
+	}
+}
+
+// handlerHeaders returns the headers of after that are not in before.
+func handlerHeaders(before, after http.Header) http.Header {
+	out := http.Header{}
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			out[name] = slices.Clone(values)
+		}
+	}
+	return out
+}
+
+// teeResponseWriter passes the response through while keeping a copy of the body.
+type teeResponseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *teeResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *teeResponseWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
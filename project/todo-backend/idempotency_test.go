@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postTodo(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency_ReplaysResponse(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	first := postTodo(router, "key-1", `{"description":"buy milk"}`)
+	second := postTodo(router, "key-1", `{"description":"buy milk"}`)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	// Only one todo was created
+	assert.Equal(t, 1, len(s.todosSorted))
+}
+
+func TestIdempotency_DifferentBodyIsRejected(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	first := postTodo(router, "key-1", `{"description":"buy milk"}`)
+	second := postTodo(router, "key-1", `{"description":"buy bread"}`)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, second.Code)
+	assert.Equal(t, problemContentType, second.Header().Get("Content-Type"))
+	assert.Equal(t, 1, len(s.todosSorted))
+}
+
+func TestIdempotency_WithoutKeyCreatesDuplicates(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	postTodo(router, "", `{"description":"buy milk"}`)
+	postTodo(router, "", `{"description":"buy milk"}`)
+
+	assert.Equal(t, 2, len(s.todosSorted))
+}
+
+func TestIdempotency_ReplaysErrorsToo(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	first := postTodo(router, "key-1", `{"description":"   "}`)
+	second := postTodo(router, "key-1", `{"description":"   "}`)
+
+	assert.Equal(t, http.StatusBadRequest, first.Code)
+	assert.Equal(t, http.StatusBadRequest, second.Code)
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotency_ReplaysHandlerHeaders(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/todos?parse_dates=true", bytes.NewReader([]byte(`{"description":"pay rent tomorrow"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post()
+	require.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+	require.NotEmpty(t, first.Header().Get(parsedDueDateHeader))
+	second := post()
+	assert.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get(parsedDueDateHeader), second.Header().Get(parsedDueDateHeader))
+	assert.Equal(t, first.Header().Get("Content-Type"), second.Header().Get("Content-Type"))
+	// Headers of the middleware in front are set afresh, not replayed twice
+	assert.Len(t, second.Header().Values("Vary"), 1)
+}
+
+func TestIdempotencyStore_Expiry(t *testing.T) {
+	st := NewIdempotencyStore(time.Hour)
+	now := time.Now()
+	st.now = func() time.Time { return now }
+	hash := sha256.Sum256([]byte("body"))
+
+	state, _ := st.begin("k", hash)
+	assert.Equal(t, idempotencyNew, state)
+
+	// Not finished yet
+	state, _ = st.begin("k", hash)
+	assert.Equal(t, idempotencyInFlight, state)
+
+	st.finish("k", http.StatusCreated, http.Header{"Content-Type": {"application/json"}}, []byte(`{}`))
+	state, entry := st.begin("k", hash)
+	assert.Equal(t, idempotencyReplay, state)
+	assert.Equal(t, http.StatusCreated, entry.status)
+
+	// After the TTL the key can be reused for anything
+	now = now.Add(time.Hour + time.Second)
+	state, _ = st.begin("k", sha256.Sum256([]byte("other body")))
+	assert.Equal(t, idempotencyNew, state)
+}
+
+func TestIdempotencyStore_ReleaseAllowsRetry(t *testing.T) {
+	st := NewIdempotencyStore(time.Hour)
+	hash := sha256.Sum256([]byte("body"))
+
+	st.begin("k", hash)
+	st.release("k")
+
+	state, _ := st.begin("k", hash)
+	assert.Equal(t, idempotencyNew, state)
+}
+
+func TestIdempotencyTTLFromEnv(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TTL", "")
+	assert.Equal(t, defaultIdempotencyTTL, idempotencyTTLFromEnv())
+
+	t.Setenv("IDEMPOTENCY_TTL", "15m")
+	assert.Equal(t, 15*time.Minute, idempotencyTTLFromEnv())
+
+	t.Setenv("IDEMPOTENCY_TTL", "soon")
+	assert.Equal(t, defaultIdempotencyTTL, idempotencyTTLFromEnv())
+}
+
+func TestIdempotency_ReplayedBodyIsATodo(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	postTodo(router, "key-1", `{"description":"buy milk"}`)
+	w := postTodo(router, "key-1", `{"description":"buy milk"}`)
+
+	var todo Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+	assert.Equal(t, "buy milk", todo.Description)
+}
+
+func TestIdempotency_KeysAreScopedToTheClient(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	for _, user := range []string{"alice", "bob"} {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(`{"description":"import"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(idempotencyKeyHeader, "same-key")
+		req.Header.Set(identityHeader, user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"), user)
+	}
+	require.Len(t, s.List(), 2)
+	assert.Equal(t, "alice", s.List()[0].Owner)
+	assert.Equal(t, "bob", s.List()[1].Owner)
+}
+
+func TestIdempotency_PanicReleasesKey(t *testing.T) {
+	st := NewIdempotencyStore(time.Hour)
+	calls := 0
+	r := gin.New()
+	r.Use(gin.CustomRecovery(recovery))
+	r.POST("/", idempotent(st), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		c.JSON(http.StatusCreated, gin.H{"calls": calls})
+	})
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{}`)))
+		req.Header.Set(idempotencyKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusInternalServerError, post().Code)
+	assert.Equal(t, http.StatusCreated, post().Code, "the retry is not rejected as in flight")
+}
+
+func TestIdempotency_BodyTooLarge(t *testing.T) {
+	r := gin.New()
+	r.POST("/", idempotent(NewIdempotencyStore(time.Hour)), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, maxIdempotentBodySize+1)))
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
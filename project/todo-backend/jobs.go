@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Background work such as fetching link previews runs as jobs: a kind naming the handler,
+// a JSON payload and the time to run at. A pool of workers runs the jobs that are due;
+// a job whose handler fails is retried with exponential backoff until it has used up its
+// attempts, and is then kept as failed for an admin to look at and retry.
+//
+// Jobs are persisted in JOBS_FILE on the volume, encrypted if keys are configured, so pending and scheduled jobs survive
+// restarts. A job that was running when the process died runs again, so handlers must be
+// idempotent. Stop lets running jobs finish until its context expires; jobs cancelled
+// then are put back as pending without counting the attempt.
+//
+// Design choice: the whole queue is rewritten after every change. It only holds jobs still
+// to be done and the last MaxFailedJobs failed ones, which keeps it small. The file is
+// sealed and written outside q.mu, so workers and readers do not wait for the disk, and
+// changes made while a write is in progress are all saved by the next one. Taking up a
+// job is not persisted: a job found running after a restart is run again anyway.
+
+const (
+	defaultJobsFile       = "/app/data/jobs.json"
+	defaultJobWorkers     = 4
+	defaultJobMaxAttempts = 5
+	defaultMaxFailedJobs  = 100
+	maxJobBackoff         = time.Hour
+	jobsContext           = "jobs" // binds the encrypted queue to its file
+)
+
+// Job states. Jobs that succeed are removed from the queue.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+)
+
+// ErrJobNotFound is returned when the queue has no job with the given id.
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrJobNotFailed is returned when retrying a job that has not failed.
+var ErrJobNotFailed = errors.New("only failed jobs can be retried")
+
+// Job is a unit of background work.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"` // including the running one
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// JobHandler does the work of a job. Returning an error schedules a retry.
+type JobHandler func(ctx context.Context, job Job) error
+
+// JobQueue stores jobs and runs them with a pool of workers.
+type JobQueue struct {
+	mu       sync.Mutex
+	jobs     []*Job // in creation order
+	handlers map[string]JobHandler
+	path     string // empty for a queue that is not persisted
+	keys     *Keyring
+	workers  int
+	// wake is closed and replaced to wake all idle workers
+	wake    chan struct{}
+	quit    chan struct{}
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+	paused  bool
+	// version counts the changes; saveMu serializes the writes of the file and guards
+	// saved, the version last written
+	version int
+	saveMu  sync.Mutex
+	saved   int
+
+	// RetryBase is the delay before the first retry; it doubles for every further one.
+	RetryBase time.Duration
+	// MaxFailedJobs is how many failed jobs are kept; older ones are dropped.
+	MaxFailedJobs int
+}
+
+// NewJobQueue loads the jobs persisted at path, if any, and returns a queue run by the
+// given number of workers. An empty path keeps the jobs in memory only. Nil keys store
+// the jobs unencrypted.
+func NewJobQueue(path string, workers int, keys *Keyring) (*JobQueue, error) {
+	q := &JobQueue{
+		handlers:  map[string]JobHandler{},
+		path:      path,
+		keys:      keys,
+		workers:   max(workers, 1),
+		wake:      make(chan struct{}),
+		quit:      make(chan struct{}),
+		RetryBase: time.Second,
+
+		MaxFailedJobs: defaultMaxFailedJobs,
+	}
+	if path == "" {
+		return q, nil
+	}
+
+	data, err := readSealedFile(keys, path, jobsContext)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.jobs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, job := range q.jobs {
+		// The process stopped while the job was running; give it another go
+		if job.Status == JobRunning {
+			job.Status = JobPending
+		}
+	}
+	return q, nil
+}
+
+// NewJobQueueFromEnv returns a queue persisted in JOBS_FILE and run by JOB_WORKERS workers.
+func NewJobQueueFromEnv(keys *Keyring) (*JobQueue, error) {
+	path := os.Getenv("JOBS_FILE")
+	if path == "" {
+		path = defaultJobsFile
+	}
+	return NewJobQueue(path, jobWorkersFromEnv(), keys)
+}
+
+// jobWorkersFromEnv reads JOB_WORKERS, defaulting to defaultJobWorkers.
+func jobWorkersFromEnv() int {
+	value := os.Getenv("JOB_WORKERS")
+	if value == "" {
+		return defaultJobWorkers
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		log.Printf("Invalid JOB_WORKERS %q, using %d", value, defaultJobWorkers)
+		return defaultJobWorkers
+	}
+	return n
+}
+
+// Handle registers the handler of a kind of job. Handlers must be registered before Start.
+func (q *JobQueue) Handle(kind string, h JobHandler) {
+	q.handlers[kind] = h
+}
+
+// Enqueue adds a job running at runAt, or as soon as possible if runAt is zero. The
+// payload is stored as JSON; nil means none.
+func (q *JobQueue) Enqueue(kind string, payload any, runAt time.Time) (Job, error) {
+	var data json.RawMessage
+	if payload != nil {
+		var err error
+		if data, err = json.Marshal(payload); err != nil {
+			return Job{}, err
+		}
+	}
+	now := time.Now().UTC()
+	if runAt.IsZero() {
+		runAt = now
+	}
+	job := &Job{
+		ID:          uuid.New().String(),
+		Kind:        kind,
+		Payload:     data,
+		Status:      JobPending,
+		MaxAttempts: defaultJobMaxAttempts,
+		RunAt:       runAt.UTC(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	q.mu.Lock()
+	q.jobs = append(q.jobs, job)
+	created := *job
+	q.changed()
+	q.mu.Unlock()
+
+	q.save()
+	return created, nil
+}
+
+// Jobs returns the jobs with the given status, or all jobs if status is empty.
+func (q *JobQueue) Jobs(status string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	out := []Job{}
+	for _, job := range q.jobs {
+		if status == "" || job.Status == status {
+			out = append(out, *job)
+		}
+	}
+	return out
+}
+
+// Retry gives a failed job a new set of attempts, starting now.
+func (q *JobQueue) Retry(id string) (Job, error) {
+	job, err := q.retry(id)
+	if err == nil {
+		q.save()
+	}
+	return job, err
+}
+
+func (q *JobQueue) retry(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, job := range q.jobs {
+		if job.ID != id {
+			continue
+		}
+		if job.Status != JobFailed {
+			return Job{}, ErrJobNotFailed
+		}
+		job.Status = JobPending
+		job.Attempts = 0
+		job.RunAt = time.Now().UTC()
+		job.UpdatedAt = job.RunAt
+		q.changed()
+		return *job, nil
+	}
+	return Job{}, ErrJobNotFound
+}
+
+// SetPaused stops taking up jobs, or resumes. Running jobs are not interrupted.
+func (q *JobQueue) SetPaused(paused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.paused = paused
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// Start runs the workers until Stop is called.
+func (q *JobQueue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	for range q.workers {
+		q.running.Add(1)
+		go q.work(ctx)
+	}
+}
+
+// Stop stops taking up jobs and waits for the running ones to finish. When ctx expires
+// first, the running jobs are cancelled and put back as pending. It must be called once.
+func (q *JobQueue) Stop(ctx context.Context) error {
+	close(q.quit)
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if q.cancel != nil {
+			q.cancel()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+// work is the loop of one worker.
+func (q *JobQueue) work(ctx context.Context) {
+	defer q.running.Done()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-q.quit:
+			return
+		default:
+		}
+
+		job, wait, wake := q.next()
+		if job != nil {
+			q.run(ctx, job)
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case <-q.quit:
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next takes up the pending job that is due first. If none is due it returns how long to
+// wait for the next one, and the channel closed when jobs change.
+func (q *JobQueue) next() (*Job, time.Duration, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().UTC()
+	var due *Job
+	wait := time.Minute
+	if q.paused {
+		return nil, wait, q.wake
+	}
+	for _, job := range q.jobs {
+		if job.Status != JobPending {
+			continue
+		}
+		if job.RunAt.After(now) {
+			wait = min(wait, job.RunAt.Sub(now))
+			continue
+		}
+		if due == nil || job.RunAt.Before(due.RunAt) {
+			due = job
+		}
+	}
+	if due == nil {
+		return nil, wait, q.wake
+	}
+
+	due.Status = JobRunning
+	due.Attempts++
+	due.UpdatedAt = now
+	return due, 0, nil
+}
+
+// run calls the handler of the job and records the outcome.
+func (q *JobQueue) run(ctx context.Context, job *Job) {
+	q.mu.Lock()
+	h, ok := q.handlers[job.Kind]
+	snapshot := *job
+	q.mu.Unlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler for jobs of kind %q", job.Kind)
+	} else {
+		err = callJobHandler(ctx, h, snapshot)
+	}
+
+	q.record(ctx, job, ok, err)
+	q.save()
+}
+
+// record applies the outcome of a run to the job.
+func (q *JobQueue) record(ctx context.Context, job *Job, handled bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now().UTC()
+	job.UpdatedAt = now
+	switch {
+	case err == nil:
+		q.remove(job)
+	case ctx.Err() != nil:
+		// Cancelled by Stop; the attempt did not get a fair chance
+		job.Status = JobPending
+		job.Attempts--
+	case !handled || job.Attempts >= job.MaxAttempts:
+		job.Status = JobFailed
+		job.LastError = err.Error()
+		log.Printf("Job %s (%s) failed after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+		q.pruneFailed()
+	default:
+		job.Status = JobPending
+		job.LastError = err.Error()
+		job.RunAt = now.Add(q.backoff(job.Attempts))
+	}
+	q.changed()
+}
+
+// callJobHandler runs the handler, turning a panic into an error.
+func callJobHandler(ctx context.Context, h JobHandler, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// backoff returns the delay before retrying a job that failed its nth attempt.
+func (q *JobQueue) backoff(attempt int) time.Duration {
+	d := q.RetryBase
+	for i := 1; i < attempt && d < maxJobBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxJobBackoff)
+}
+
+// remove drops a finished job.
+// Caller must hold q.mu.
+func (q *JobQueue) remove(job *Job) {
+	for i, j := range q.jobs {
+		if j == job {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// pruneFailed drops the failed jobs that failed first, beyond MaxFailedJobs.
+// Caller must hold q.mu.
+func (q *JobQueue) pruneFailed() {
+	var failed []*Job
+	for _, job := range q.jobs {
+		if job.Status == JobFailed {
+			failed = append(failed, job)
+		}
+	}
+	if len(failed) <= q.MaxFailedJobs {
+		return
+	}
+	sort.SliceStable(failed, func(i, j int) bool { return failed[i].UpdatedAt.Before(failed[j].UpdatedAt) })
+	for _, job := range failed[:len(failed)-q.MaxFailedJobs] {
+		q.remove(job)
+	}
+}
+
+// changed marks the queue for saving and wakes the idle workers. The caller calls save
+// once it has released q.mu.
+// Caller must hold q.mu.
+func (q *JobQueue) changed() {
+	q.version++
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// save writes the queue to its file unless a write that started after the last change
+// has already done so. Failing to write only risks losing jobs on a restart, so the
+// error is logged.
+// Caller must not hold q.mu.
+func (q *JobQueue) save() {
+	if q.path == "" {
+		return
+	}
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	q.mu.Lock()
+	version := q.version
+	if version == q.saved {
+		q.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(q.jobs)
+	q.mu.Unlock()
+
+	if err == nil {
+		err = writeSealedFile(q.keys, q.path, jobsContext, data)
+	}
+	if err != nil {
+		log.Printf("Cannot persist the job queue: %v", err)
+		return
+	}
+	q.saved = version
+}
+
+// Rekey rewraps the data key of the queue file with the primary key, reporting whether
+// the file changed.
+func (q *JobQueue) Rekey() (bool, error) {
+	q.saveMu.Lock()
+	defer q.saveMu.Unlock()
+
+	if q.path == "" || q.keys == nil {
+		return false, nil
+	}
+	changed, err := rewrapFile(q.keys, q.path, jobsContext)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return changed, err
+}
+
+// getJobs lists the jobs of the background queue.
+// @param status query string false "pending (default), running, failed or all"
+// @success 200 {array} Job
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) getJobs(c *gin.Context) {
+	if s.Jobs == nil {
+		abortWithProblem(c, NewProblem(http.StatusNotFound, "the job queue is not enabled"))
+		return
+	}
+	status := c.DefaultQuery("status", JobPending)
+	switch status {
+	case JobPending, JobRunning, JobFailed:
+	case "all":
+		status = ""
+	default:
+		abortWithValidationError(c, "status", "must be pending, running, failed or all")
+		return
+	}
+	c.JSON(http.StatusOK, s.Jobs.Jobs(status))
+}
+
+// retryJob gives a failed job a new set of attempts.
+// @param id path string true "ID of the job"
+// @success 200 {object} Job
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+// @failure 409 {object} Problem
+func (s *TodoMgr) retryJob(c *gin.Context) {
+	if s.Jobs == nil {
+		abortWithProblem(c, NewProblem(http.StatusNotFound, "the job queue is not enabled"))
+		return
+	}
+	job, err := s.Jobs.Retry(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
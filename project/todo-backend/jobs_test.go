@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startJobQueue(t *testing.T, path string, workers int) *JobQueue {
+	t.Helper()
+	q, err := NewJobQueue(path, workers, nil)
+	require.NoError(t, err)
+	q.RetryBase = 10 * time.Millisecond
+	return q
+}
+
+func stopJobQueue(t *testing.T, q *JobQueue) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Stop(ctx))
+}
+
+func TestJobQueue_RunsJobs(t *testing.T) {
+	q := startJobQueue(t, "", 2)
+	got := make(chan string, 1)
+	q.Handle("greet", func(ctx context.Context, job Job) error {
+		var name string
+		require.NoError(t, json.Unmarshal(job.Payload, &name))
+		got <- name
+		return nil
+	})
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	_, err := q.Enqueue("greet", "world", time.Time{})
+	require.NoError(t, err)
+	select {
+	case name := <-got:
+		assert.Equal(t, "world", name)
+	case <-time.After(time.Second):
+		t.Fatal("job did not run")
+	}
+	// Succeeded jobs leave the queue
+	assert.Eventually(t, func() bool { return len(q.Jobs("")) == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestJobQueue_RetriesWithBackoff(t *testing.T) {
+	q := startJobQueue(t, "", 1)
+	var calls atomic.Int32
+	q.Handle("flaky", func(ctx context.Context, job Job) error {
+		if calls.Add(1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	_, err := q.Enqueue("flaky", nil, time.Time{})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return calls.Load() == 3 && len(q.Jobs("")) == 0 }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, 10*time.Millisecond, q.backoff(1))
+	assert.Equal(t, 40*time.Millisecond, q.backoff(3))
+	assert.Equal(t, maxJobBackoff, q.backoff(100))
+}
+
+func TestJobQueue_FailsAfterMaxAttempts(t *testing.T) {
+	q := startJobQueue(t, "", 1)
+	var calls atomic.Int32
+	q.Handle("broken", func(ctx context.Context, job Job) error {
+		calls.Add(1)
+		panic("boom")
+	})
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	job, err := q.Enqueue("broken", nil, time.Time{})
+	require.NoError(t, err)
+	_, err = q.Enqueue("unknown", nil, time.Time{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return len(q.Jobs(JobFailed)) == 2 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(defaultJobMaxAttempts), calls.Load())
+	failed := q.Jobs(JobFailed)
+	assert.Equal(t, "panic: boom", failed[0].LastError)
+	// A job without a handler is not retried
+	assert.Equal(t, 1, failed[1].Attempts)
+
+	_, err = q.Retry(job.ID)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return calls.Load() == 2*defaultJobMaxAttempts }, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestJobQueue_KeepsTheLastFailedJobs(t *testing.T) {
+	q := startJobQueue(t, "", 1)
+	q.MaxFailedJobs = 2
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		// No handler, so each job fails at once
+		job, err := q.Enqueue("unknown", nil, time.Time{})
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+		require.Eventually(t, func() bool {
+			for _, failed := range q.Jobs(JobFailed) {
+				if failed.ID == job.ID {
+					return true
+				}
+			}
+			return false
+		}, 2*time.Second, 5*time.Millisecond)
+	}
+
+	failed := q.Jobs(JobFailed)
+	require.Len(t, failed, 2)
+	assert.Equal(t, ids[2:], []string{failed[0].ID, failed[1].ID})
+}
+
+func TestJobQueue_ScheduledJobs(t *testing.T) {
+	q := startJobQueue(t, "", 1)
+	ran := make(chan time.Time, 1)
+	q.Handle("later", func(ctx context.Context, job Job) error {
+		ran <- time.Now()
+		return nil
+	})
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	runAt := time.Now().Add(100 * time.Millisecond)
+	_, err := q.Enqueue("later", nil, runAt)
+	require.NoError(t, err)
+	select {
+	case at := <-ran:
+		assert.False(t, at.Before(runAt))
+	case <-time.After(time.Second):
+		t.Fatal("scheduled job did not run")
+	}
+}
+
+func TestJobQueue_Concurrency(t *testing.T) {
+	q := startJobQueue(t, "", 3)
+	var active, peak atomic.Int32
+	release := make(chan struct{})
+	q.Handle("wait", func(ctx context.Context, job Job) error {
+		n := active.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		<-release
+		active.Add(-1)
+		return nil
+	})
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	for range 4 {
+		_, err := q.Enqueue("wait", nil, time.Time{})
+		require.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool { return active.Load() == 3 }, time.Second, 5*time.Millisecond)
+	close(release)
+	assert.Eventually(t, func() bool { return len(q.Jobs("")) == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(3), peak.Load())
+}
+
+func TestJobQueue_PersistsAndStopsCleanly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	q := startJobQueue(t, path, 1)
+	started := make(chan struct{})
+	q.Handle("slow", func(ctx context.Context, job Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	q.Start()
+
+	_, err := q.Enqueue("slow", nil, time.Time{})
+	require.NoError(t, err)
+	scheduled, err := q.Enqueue("slow", nil, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Stop(ctx), context.DeadlineExceeded)
+
+	// Both jobs are still there after a restart, the cancelled one without a used attempt
+	q, err = NewJobQueue(path, 1, nil)
+	require.NoError(t, err)
+	jobs := q.Jobs(JobPending)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, 0, jobs[0].Attempts)
+	assert.Equal(t, scheduled.ID, jobs[1].ID)
+	assert.WithinDuration(t, scheduled.RunAt, jobs[1].RunAt, 0)
+}
+
+func TestJobQueue_SavesOutsideTheLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	q, err := NewJobQueue(path, 1, testKeyring(t, "a"))
+	require.NoError(t, err)
+
+	// While a write is in progress the queue can still be read and added to
+	q.saveMu.Lock()
+	enqueued := make(chan struct{})
+	for range 10 {
+		go func() {
+			_, err := q.Enqueue("noop", nil, time.Time{})
+			assert.NoError(t, err)
+			enqueued <- struct{}{}
+		}()
+	}
+	require.Eventually(t, func() bool { return len(q.Jobs(JobPending)) == 10 }, time.Second, time.Millisecond)
+	q.saveMu.Unlock()
+	for range 10 {
+		<-enqueued
+	}
+
+	reloaded, err := NewJobQueue(path, 1, testKeyring(t, "a"))
+	require.NoError(t, err)
+	assert.Len(t, reloaded.Jobs(JobPending), 10)
+}
+
+func TestAdminJobs(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root, ops")
+	q := startJobQueue(t, "", 1)
+	failed, err := q.Enqueue("unknown", nil, time.Time{})
+	require.NoError(t, err)
+	pending, err := q.Enqueue("unknown", nil, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	// Run the first job once without starting the workers
+	job, _, _ := q.next()
+	q.run(context.Background(), job)
+
+	router := setupRouter(&TodoMgr{Jobs: q})
+	request := func(method, path, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if user != "" {
+			req.Header.Set(identityHeader, user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/admin/jobs", "").Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/admin/jobs", "alice").Code)
+
+	w := request(http.MethodGet, "/admin/jobs", "ops")
+	require.Equal(t, http.StatusOK, w.Code)
+	var jobs []Job
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 1)
+	assert.Equal(t, pending.ID, jobs[0].ID)
+
+	w = request(http.MethodGet, "/admin/jobs?status=failed", "root")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jobs))
+	require.Len(t, jobs, 1)
+	assert.Equal(t, failed.ID, jobs[0].ID)
+	assert.Contains(t, jobs[0].LastError, "no handler")
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/admin/jobs/"+failed.ID+"/retry", "root").Code)
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/admin/jobs/"+pending.ID+"/retry", "root").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/admin/jobs/nope/retry", "root").Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodGet, "/admin/jobs?status=done", "root").Code)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/jobs", nil)
+	req.Header.Set(identityHeader, "root")
+	setupRouter(&TodoMgr{}).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestLinkPreviews_RunAsJobs(t *testing.T) {
+	srv := previewServer(t)
+	q := startJobQueue(t, "", 1)
+	s := &TodoMgr{Previews: testPreviewer(), Jobs: q}
+	q.Handle(linkPreviewJob, s.runLinkPreviewJob)
+	q.Start()
+	defer stopJobQueue(t, q)
+
+	todo, err := s.Create("", TodoInput{Description: "read " + srv.URL + "/page and " + srv.URL + "/missing"})
+	require.NoError(t, err)
+
+	// The broken link is retried until its attempts are used up, then marked failed
+	require.Eventually(t, func() bool {
+		got, _ := s.Get(todo.UUID)
+		return got.Links[0].Status == LinkOK && got.Links[1].Status == LinkFailed
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Empty(t, q.Jobs(""))
+}
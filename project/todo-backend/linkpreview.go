@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// URLs in descriptions get previews: the title and description of the page, fetched in the
+// background so creating a todo never waits for someone else's web server. A new link
+// starts out pending and is updated (and a TodoUpdated event published) once its page has
+// been fetched. Changing the description keeps the previews of the links still in it.
+//
+// Fetching is limited by a timeout, a maximum page size and host allow and deny lists.
+// Private, loopback, carrier-grade NAT and NAT64 addresses are refused unless allowed, so
+// descriptions cannot be used to probe services inside the cluster.
+//
+// Design choice: previews are derived from the description, so their updates are not
+// recorded in the audit trail.
+
+// maxLinksPerTodo caps how many URLs of a description get previews.
+const maxLinksPerTodo = 5
+
+const (
+	defaultLinkPreviewTimeout  = 5 * time.Second
+	defaultLinkPreviewMaxBytes = 512 << 10
+)
+
+// maxPreviewTextLength caps the title and description of a preview.
+const maxPreviewTextLength = 300
+
+// LinkPreview states.
+const (
+	LinkPending = "pending"
+	LinkOK      = "ok"
+	LinkFailed  = "failed"
+	LinkBlocked = "blocked"
+)
+
+// ErrLinkBlocked is returned for URLs the allow and deny lists do not permit.
+var ErrLinkBlocked = errors.New("host is not allowed")
+
+// LinkPreview describes a URL found in a todo description.
+type LinkPreview struct {
+	URL         string     `json:"url"`
+	Status      string     `json:"status"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	FetchedAt   *time.Time `json:"fetched_at,omitempty"`
+}
+
+// urlPattern finds http and https URLs in free text.
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// detectLinks returns the URLs of a description, in order and without duplicates.
+func detectLinks(description string) []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, raw := range urlPattern.FindAllString(description, -1) {
+		// Punctuation ending a sentence is not part of the URL
+		raw = strings.TrimRight(raw, ".,;:!?)]}'")
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || seen[raw] {
+			continue
+		}
+		seen[raw] = true
+		links = append(links, raw)
+		if len(links) == maxLinksPerTodo {
+			break
+		}
+	}
+	return links
+}
+
+// linksFor returns the previews of the links in the description, keeping those already in
+// previous. Links seen for the first time are pending.
+func linksFor(description string, previous []LinkPreview) []LinkPreview {
+	urls := detectLinks(description)
+	if len(urls) == 0 {
+		return nil
+	}
+	links := make([]LinkPreview, len(urls))
+	for i, u := range urls {
+		links[i] = LinkPreview{URL: u, Status: LinkPending}
+		for _, p := range previous {
+			if p.URL == u {
+				links[i] = p
+				break
+			}
+		}
+	}
+	return links
+}
+
+// LinkPreviewer fetches the pages of links.
+type LinkPreviewer struct {
+	client       *http.Client
+	maxBytes     int64
+	allow        []string
+	deny         []string
+	allowPrivate bool
+	// slots bounds the number of pages fetched at the same time
+	slots chan struct{}
+}
+
+// LinkPreviewConfig configures a LinkPreviewer.
+type LinkPreviewConfig struct {
+	Timeout  time.Duration
+	MaxBytes int64
+	// Allow and Deny list hosts; an entry also matches its subdomains. An empty Allow
+	// list allows every host not denied.
+	Allow, Deny []string
+	// AllowPrivate permits private, loopback and link-local addresses, e.g. for tests.
+	AllowPrivate bool
+	Concurrency  int
+}
+
+// NewLinkPreviewer returns a previewer with the given limits.
+func NewLinkPreviewer(cfg LinkPreviewConfig) *LinkPreviewer {
+	p := &LinkPreviewer{
+		maxBytes:     cfg.MaxBytes,
+		allow:        normalizeHosts(cfg.Allow),
+		deny:         normalizeHosts(cfg.Deny),
+		allowPrivate: cfg.AllowPrivate,
+		slots:        make(chan struct{}, max(cfg.Concurrency, 1)),
+	}
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: p.checkAddress}
+	p.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return p.checkURL(req.URL)
+		},
+	}
+	return p
+}
+
+// NewLinkPreviewerFromEnv configures link previews from LINK_PREVIEW_TIMEOUT,
+// LINK_PREVIEW_MAX_BYTES and the comma separated LINK_PREVIEW_ALLOW and LINK_PREVIEW_DENY
+// host lists. LINK_PREVIEWS=false disables them and returns nil.
+func NewLinkPreviewerFromEnv() *LinkPreviewer {
+	if os.Getenv("LINK_PREVIEWS") == "false" {
+		return nil
+	}
+	timeout := defaultLinkPreviewTimeout
+	if value := os.Getenv("LINK_PREVIEW_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			log.Printf("Invalid LINK_PREVIEW_TIMEOUT %q, using %v", value, defaultLinkPreviewTimeout)
+		} else {
+			timeout = d
+		}
+	}
+	return NewLinkPreviewer(LinkPreviewConfig{
+		Timeout:     timeout,
+		MaxBytes:    sizeFromEnv("LINK_PREVIEW_MAX_BYTES", defaultLinkPreviewMaxBytes),
+		Allow:       strings.Split(os.Getenv("LINK_PREVIEW_ALLOW"), ","),
+		Deny:        strings.Split(os.Getenv("LINK_PREVIEW_DENY"), ","),
+		Concurrency: 4,
+	})
+}
+
+func normalizeHosts(hosts []string) []string {
+	var out []string
+	for _, h := range hosts {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			out = append(out, strings.TrimPrefix(h, "."))
+		}
+	}
+	return out
+}
+
+func hostMatches(host string, patterns []string) bool {
+	for _, p := range patterns {
+		if host == p || strings.HasSuffix(host, "."+p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL applies the scheme and the allow and deny lists.
+func (p *LinkPreviewer) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %s", ErrLinkBlocked, u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if hostMatches(host, p.deny) || (len(p.allow) > 0 && !hostMatches(host, p.allow)) {
+		return ErrLinkBlocked
+	}
+	return nil
+}
+
+// blockedPrefixes are ranges reaching inside networks that the net/netip predicates miss:
+// carrier-grade NAT, which some clusters use for pods, and NAT64, which embeds any IPv4
+// address, private ones included.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// checkAddress refuses connections to private addresses. It runs after name resolution,
+// so a public name pointing to a private address is refused as well.
+func (p *LinkPreviewer) checkAddress(network, address string, _ syscall.RawConn) error {
+	if p.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || isPrivateAddress(ip) {
+		return fmt.Errorf("%w: private address %s", ErrLinkBlocked, host)
+	}
+	return nil
+}
+
+// isPrivateAddress reports whether ip is not a public unicast address. IPv4-mapped IPv6
+// addresses are judged by their IPv4 address.
+func isPrivateAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch returns the preview of a URL. It does not fail: errors are reported in the preview.
+func (p *LinkPreviewer) Fetch(ctx context.Context, rawURL string) LinkPreview {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+	return p.preview(ctx, rawURL)
+}
+
+// FetchInBackground fetches the preview of a URL in a goroutine and passes it to done. It
+// returns false without fetching if all slots are taken, so a flood of links cannot pile
+// up goroutines.
+func (p *LinkPreviewer) FetchInBackground(rawURL string, done func(LinkPreview)) bool {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return false
+	}
+	go func() {
+		defer func() { <-p.slots }()
+		done(p.preview(context.Background(), rawURL))
+	}()
+	return true
+}
+
+func (p *LinkPreviewer) preview(ctx context.Context, rawURL string) LinkPreview {
+	now := time.Now().UTC()
+	preview := LinkPreview{URL: rawURL, FetchedAt: &now}
+	title, description, err := p.fetch(ctx, rawURL)
+	switch {
+	case errors.Is(err, ErrLinkBlocked):
+		preview.Status, preview.Error = LinkBlocked, err.Error()
+	case err != nil:
+		preview.Status, preview.Error = LinkFailed, err.Error()
+	default:
+		preview.Status, preview.Title, preview.Description = LinkOK, title, description
+	}
+	return preview
+}
+
+func (p *LinkPreviewer) fetch(ctx context.Context, rawURL string) (title, description string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	if err := p.checkURL(u); err != nil {
+		return "", "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", "todo-backend link preview")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		// Not a page, e.g. a PDF; the link is fine but there is nothing to show
+		return "", "", nil
+	}
+	if resp.ContentLength > p.maxBytes {
+		return "", "", fmt.Errorf("page is larger than %d bytes", p.maxBytes)
+	}
+
+	// Titles are in the head, so a page cut at the limit still has them
+	title, description = parseHTMLMeta(io.LimitReader(resp.Body, p.maxBytes))
+	return title, description, nil
+}
+
+// parseHTMLMeta reads the title and description of a page, preferring the Open Graph ones.
+func parseHTMLMeta(r io.Reader) (title, description string) {
+	var ogTitle, ogDescription string
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return previewText(ogTitle, title), previewText(ogDescription, description)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "name", "property":
+						key = strings.ToLower(string(v))
+					case "content":
+						content = string(v)
+					}
+				}
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				}
+			case "body":
+				// Everything a preview needs is in the head
+				return previewText(ogTitle, title), previewText(ogDescription, description)
+			}
+		case html.TextToken:
+			if inTitle {
+				title += string(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+// previewText returns the first non-empty text, with whitespace collapsed and cut to length.
+func previewText(texts ...string) string {
+	for _, t := range texts {
+		t = strings.Join(strings.Fields(t), " ")
+		if t == "" {
+			continue
+		}
+		if runes := []rune(t); len(runes) > maxPreviewTextLength {
+			t = string(runes[:maxPreviewTextLength-1]) + "…"
+		}
+		return t
+	}
+	return ""
+}
+
+// previewLinks returns the links of a description, or nil if previews are disabled.
+func (s *TodoMgr) previewLinks(description string, previous []LinkPreview) []LinkPreview {
+	if s.Previews == nil {
+		return nil
+	}
+	return linksFor(description, previous)
+}
+
+// linkPreviewJob is the kind of job fetching the preview of one link.
+const linkPreviewJob = "link.preview"
+
+type linkPreviewPayload struct {
+	TodoUUID string `json:"todo_uuid"`
+	URL      string `json:"url"`
+}
+
+// requestPreviews fetches the pending links of the todo in the background.
+// Caller must not hold s.mu: queueing a job writes the job file, and a fetch finishing
+// quickly takes s.mu to store its preview.
+func (s *TodoMgr) requestPreviews(t Todo) {
+	if s.Previews == nil {
+		return
+	}
+	for _, link := range t.Links {
+		if link.Status != LinkPending {
+			continue
+		}
+		if s.Jobs != nil {
+			if _, err := s.Jobs.Enqueue(linkPreviewJob, linkPreviewPayload{TodoUUID: t.UUID, URL: link.URL}, time.Time{}); err != nil {
+				log.Printf("Cannot queue the link preview of %s: %v", link.URL, err)
+			}
+			continue
+		}
+		todoUUID, rawURL := t.UUID, link.URL
+		started := s.Previews.FetchInBackground(rawURL, func(preview LinkPreview) {
+			if preview.Status == LinkFailed {
+				log.Printf("Link preview of %s failed: %s", rawURL, preview.Error)
+			}
+			s.setPreview(todoUUID, preview)
+		})
+		if !started {
+			// Without a job queue there is nothing to retry it later
+			now := time.Now().UTC()
+			s.setPreview(todoUUID, LinkPreview{URL: rawURL, Status: LinkFailed, Error: "too many link previews in progress", FetchedAt: &now})
+		}
+	}
+}
+
+// runLinkPreviewJob fetches a preview from the job queue. Failed fetches are retried
+// while the job has attempts left; the link stays pending meanwhile.
+func (s *TodoMgr) runLinkPreviewJob(ctx context.Context, job Job) error {
+	var p linkPreviewPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return err
+	}
+	if s.Previews == nil {
+		return nil
+	}
+	preview := s.Previews.Fetch(ctx, p.URL)
+	if preview.Status == LinkFailed && job.Attempts < job.MaxAttempts {
+		return errors.New(preview.Error)
+	}
+	s.setPreview(p.TodoUUID, preview)
+	return nil
+}
+
+// setPreview stores a fetched preview, unless the link has left the description meanwhile.
+func (s *TodoMgr) setPreview(todoUUID string, preview LinkPreview) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(todoUUID)
+	if i < 0 {
+		return
+	}
+	t := &s.todosSorted[i]
+	for j, link := range t.Links {
+		if link.URL != preview.URL || link.Status != LinkPending {
+			continue
+		}
+		// The audit trail shares the slice with the todo, so change a copy
+		links := make([]LinkPreview, len(t.Links))
+		copy(links, t.Links)
+		links[j] = preview
+		t.Links = links
+		s.publish(TodoEvent{Type: TodoUpdated, Todo: *t})
+		return
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const previewPage = `<!doctype html><html><head>
+<title>  Plain
+ title </title>
+<meta name="description" content="What the page is about">
+<meta property="og:title" content="Open Graph title">
+</head><body><title>not this one</title></body></html>`
+
+func previewServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, previewPage)
+	})
+	mux.HandleFunc("/file.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		fmt.Fprint(w, "%PDF-1.7")
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", "100000")
+		w.Write(make([]byte, 100000))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://denied.example/", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testPreviewer(deny ...string) *LinkPreviewer {
+	return NewLinkPreviewer(LinkPreviewConfig{
+		Timeout:      200 * time.Millisecond,
+		MaxBytes:     10000,
+		Deny:         deny,
+		AllowPrivate: true,
+		Concurrency:  2,
+	})
+}
+
+func TestDetectLinks(t *testing.T) {
+	links := detectLinks("read https://go.dev/blog/intro, then (http://example.com/a?b=c). Again: https://go.dev/blog/intro ftp://x.org")
+	assert.Equal(t, []string{"https://go.dev/blog/intro", "http://example.com/a?b=c"}, links)
+
+	many := strings.Repeat("https://example.com/x ", 3) + "https://a.example https://b.example https://c.example https://d.example https://e.example"
+	assert.Len(t, detectLinks(many), maxLinksPerTodo)
+	assert.Empty(t, detectLinks("no links here"))
+}
+
+func TestLinkPreviewer_Fetch(t *testing.T) {
+	srv := previewServer(t)
+	p := testPreviewer("denied.example")
+	ctx := context.Background()
+
+	preview := p.Fetch(ctx, srv.URL+"/page")
+	assert.Equal(t, LinkOK, preview.Status)
+	assert.Equal(t, "Open Graph title", preview.Title)
+	assert.Equal(t, "What the page is about", preview.Description)
+	assert.NotNil(t, preview.FetchedAt)
+
+	preview = p.Fetch(ctx, srv.URL+"/file.pdf")
+	assert.Equal(t, LinkOK, preview.Status)
+	assert.Empty(t, preview.Title)
+
+	for _, path := range []string{"/missing", "/huge", "/slow"} {
+		preview = p.Fetch(ctx, srv.URL+path)
+		assert.Equal(t, LinkFailed, preview.Status, path)
+		assert.NotEmpty(t, preview.Error, path)
+	}
+
+	assert.Equal(t, LinkBlocked, p.Fetch(ctx, "http://denied.example/page").Status)
+	assert.Equal(t, LinkBlocked, p.Fetch(ctx, "http://sub.denied.example/page").Status)
+	// Redirects are checked too
+	assert.Equal(t, LinkBlocked, p.Fetch(ctx, srv.URL+"/redirect").Status)
+}
+
+func TestLinkPreviewer_AllowList(t *testing.T) {
+	srv := previewServer(t)
+	u, _ := url.Parse(srv.URL)
+	p := NewLinkPreviewer(LinkPreviewConfig{Timeout: time.Second, MaxBytes: 10000, Allow: []string{u.Hostname()}, AllowPrivate: true})
+
+	assert.Equal(t, LinkOK, p.Fetch(context.Background(), srv.URL+"/page").Status)
+	assert.Equal(t, LinkBlocked, p.Fetch(context.Background(), "http://elsewhere.example/").Status)
+}
+
+func TestLinkPreviewer_RefusesPrivateAddresses(t *testing.T) {
+	srv := previewServer(t)
+	p := NewLinkPreviewer(LinkPreviewConfig{Timeout: time.Second, MaxBytes: 10000})
+
+	// The test server listens on a loopback address
+	preview := p.Fetch(context.Background(), srv.URL+"/page")
+	assert.Equal(t, LinkBlocked, preview.Status)
+	assert.Contains(t, preview.Error, "private address")
+}
+
+func TestIsPrivateAddress(t *testing.T) {
+	for _, tt := range []struct {
+		ip      string
+		private bool
+	}{
+		{"93.184.216.34", false},
+		{"2606:2800:220:1::1", false},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"100.128.0.1", false},
+		{"224.0.0.1", true},
+		{"ff02::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:93.184.216.34", false},
+		{"64:ff9b::a00:1", true},
+		{"64:ff9b:1::1", true},
+		{"0.1.2.3", true},
+		{"::", true},
+	} {
+		assert.Equal(t, tt.private, isPrivateAddress(netip.MustParseAddr(tt.ip)), tt.ip)
+	}
+}
+
+func TestLinkPreviewer_FetchInBackgroundIsBounded(t *testing.T) {
+	srv := previewServer(t)
+	p := testPreviewer()
+	results := make(chan LinkPreview, 2)
+
+	// Both slots are busy with slow pages, so a third fetch is not started
+	require.True(t, p.FetchInBackground(srv.URL+"/slow", func(lp LinkPreview) { results <- lp }))
+	require.True(t, p.FetchInBackground(srv.URL+"/slow", func(lp LinkPreview) { results <- lp }))
+	assert.False(t, p.FetchInBackground(srv.URL+"/page", func(LinkPreview) { t.Error("fetched beyond the limit") }))
+
+	<-results
+	<-results
+	require.Eventually(t, func() bool {
+		return p.FetchInBackground(srv.URL+"/page", func(lp LinkPreview) { results <- lp })
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, LinkOK, (<-results).Status)
+}
+
+func TestTodoLinks_PreviewedInBackground(t *testing.T) {
+	srv := previewServer(t)
+	s := &TodoMgr{Previews: testPreviewer()}
+	events, cancel := s.Subscribe()
+	defer cancel()
+
+	todo, err := s.Create("", TodoInput{Description: "read " + srv.URL + "/page"})
+	require.NoError(t, err)
+	require.Len(t, todo.Links, 1)
+	assert.Equal(t, LinkPending, todo.Links[0].Status)
+
+	require.Eventually(t, func() bool {
+		got, _ := s.Get(todo.UUID)
+		return got.Links[0].Status == LinkOK
+	}, 2*time.Second, 10*time.Millisecond)
+	got, _ := s.Get(todo.UUID)
+	assert.Equal(t, "Open Graph title", got.Links[0].Title)
+
+	// Created, then updated with the preview
+	assert.Equal(t, TodoCreated, (<-events).Type)
+	ev := <-events
+	assert.Equal(t, TodoUpdated, ev.Type)
+	assert.Equal(t, LinkOK, ev.Todo.Links[0].Status)
+	// The audit trail keeps the todo as it was created
+	assert.Equal(t, LinkPending, s.AuditTrail(todo.UUID, 0, 0)[0].Todo.Links[0].Status)
+
+	// Editing the description keeps the previews of the links still in it
+	description := "read " + srv.URL + "/page and " + srv.URL + "/file.pdf"
+	updated, err := s.Update("", todo.UUID, TodoPatch{Description: &description})
+	require.NoError(t, err)
+	require.Len(t, updated.Links, 2)
+	assert.Equal(t, LinkOK, updated.Links[0].Status)
+	assert.Equal(t, LinkPending, updated.Links[1].Status)
+}
+
+func TestTodoLinks_DisabledWithoutPreviewer(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "read https://go.dev/"})
+	require.NoError(t, err)
+	assert.Empty(t, todo.Links)
+}
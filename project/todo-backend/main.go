@@ -1,200 +1,444 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
 )
 
-const TODOMAXLENGTTH = 140
-
-// Todo represents a single todo item.
-type Todo struct {
-	UUID        string    `json:"uuid"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	ChangedAt   time.Time `json:"changed_at,omitempty"`
-}
-
-// TodoMgr holds in-memory todos and a mutex for concurrency.
-type TodoMgr struct {
-	mu          sync.RWMutex
-	todosSorted []Todo
-}
-
 func main() {
-	s := &TodoMgr{}
-	r := setupRouter(s)
+	keys, err := keyringFromEnv()
+	if err != nil {
+		log.Fatalf("Todo-backend cannot load the encryption keys: %v", err)
+	}
+	workflow, err := workflowFromEnv()
+	if err != nil {
+		log.Fatalf("Todo-backend cannot load the workflow: %v", err)
+	}
+	tenantSources, err := tenantSourcesFromEnv()
+	if err != nil {
+		log.Fatalf("Todo-backend cannot read TENANT_SOURCES: %v", err)
+	}
+
+	var r *gin.Engine
+	var grpcServer *grpc.Server
+	// With tenants, maintenance mode and jobs are per tenant; the signals act on all of them
+	var toggleMaintenance func() bool
+	var stopJobs func(ctx context.Context) error
+	if len(tenantSources) > 0 {
+		base := &TodoMgr{MaxAuditEvents: auditMaxEventsFromEnv(), Workflow: workflow, Previews: NewLinkPreviewerFromEnv(), Keys: keys}
+		tenants, err := NewTenantRegistryFromEnv(tenantSources, keys, tenantMgrFactory(base, jobWorkersFromEnv()))
+		if err != nil {
+			log.Fatalf("Todo-backend cannot load the tenants: %v", err)
+		}
+		r = newTenantRouter(tenants)
+		grpcServer = newTenantGRPCServer(tenants, trustedProxiesFromEnv())
+		toggleMaintenance = tenants.ToggleMaintenance
+		stopJobs = tenants.Stop
+		prometheus.MustRegister(newTenantTodoCountCollector(tenants))
+	} else {
+		s := newTodoMgrFromEnv(workflow, keys)
+		r = setupRouter(s)
+		grpcServer = newGRPCServer(s, trustedProxiesFromEnv())
+		toggleMaintenance = func() bool { return s.ToggleMaintenance().Enabled }
+		stopJobs = s.Jobs.Stop
+		prometheus.MustRegister(newTodoCountCollector(s))
+	}
 
 	// Default port if not set via environment variable
 	if os.Getenv("PORT") == "" {
 		os.Setenv("PORT", "8080")
 	}
+	if os.Getenv("GRPC_PORT") == "" {
+		os.Setenv("GRPC_PORT", "9090")
+	}
+
+	// The gRPC API is served on its own port next to the REST API
+	lis, err := net.Listen("tcp", "0.0.0.0:"+os.Getenv("GRPC_PORT"))
+	if err != nil {
+		log.Fatalf("Todo-backend failed to listen for gRPC: %v", err)
+	}
+	go func() {
+		log.Println("Starting todo-backend gRPC server on port", os.Getenv("GRPC_PORT"))
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("Todo-backend gRPC server failed: %v", err)
+		}
+	}()
 
-	log.Println("Starting todo-backend on port", os.Getenv("PORT"))
-	if err := r.Run("0.0.0.0:" + os.Getenv("PORT")); err != nil {
-		log.Fatalf("Todo-backend failed to start: %v", err)
+	srv := &http.Server{Addr: "0.0.0.0:" + os.Getenv("PORT"), Handler: r}
+	go func() {
+		log.Println("Starting todo-backend on port", os.Getenv("PORT"))
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Todo-backend failed to start: %v", err)
+		}
+	}()
+
+	// SIGUSR1 toggles maintenance mode
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, syscall.SIGUSR1)
+	go func() {
+		for range toggle {
+			if toggleMaintenance() {
+				log.Println("Todo-backend is read-only for maintenance")
+			} else {
+				log.Println("Todo-backend is writable again")
+			}
+		}
+	}()
+
+	// Kubernetes sends SIGTERM and waits terminationGracePeriodSeconds (30s by default)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	log.Println("Shutting down todo-backend")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Todo-backend did not finish all requests: %v", err)
 	}
+	// Streaming calls such as WatchTodos never end by themselves, so the graceful stop
+	// is cut short when the timeout hits
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Println("Todo-backend did not finish all gRPC calls")
+		grpcServer.Stop()
+	}
+	// Jobs still running when the timeout hits are run again after the restart
+	if err := stopJobs(ctx); err != nil {
+		log.Printf("Todo-backend cancelled running jobs: %v", err)
+	}
+}
+
+// newTodoMgrFromEnv returns the TodoMgr of a single tenant, with its attachments, jobs
+// and backups configured from the environment.
+func newTodoMgrFromEnv(workflow *Workflow, keys *Keyring) *TodoMgr {
+	attachments, err := NewAttachmentStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Todo-backend cannot use the attachments directory: %v", err)
+	}
+	attachments.Keys = keys
+	jobs, err := NewJobQueueFromEnv(keys)
+	if err != nil {
+		log.Fatalf("Todo-backend cannot load the job queue: %v", err)
+	}
+	backups, err := NewBackupStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Todo-backend cannot use the backup directory: %v", err)
+	}
+	backups.Keys = keys
+	s := &TodoMgr{
+		MaxTodosPerOwner: maxTodosPerOwnerFromEnv(),
+		MaxAuditEvents:   auditMaxEventsFromEnv(),
+		Attachments:      attachments,
+		Workflow:         workflow,
+		Previews:         NewLinkPreviewerFromEnv(),
+		Jobs:             jobs,
+		Backups:          backups,
+		Keys:             keys,
+	}
+	jobs.Handle(linkPreviewJob, s.runLinkPreviewJob)
+	jobs.Start()
+	return s
+}
+
+// shutdownTimeout is how long requests and jobs get to finish on shutdown, within the
+// default termination grace period of Kubernetes.
+const shutdownTimeout = 20 * time.Second
+
+// maxTodosPerOwnerFromEnv reads MAX_TODOS_PER_OWNER, defaulting to 500. Zero disables the quota.
+func maxTodosPerOwnerFromEnv() int {
+	value := os.Getenv("MAX_TODOS_PER_OWNER")
+	if value == "" {
+		return 500
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Fatalf("Invalid MAX_TODOS_PER_OWNER %q", value)
+	}
+	return n
 }
 
 func setupRouter(s *TodoMgr) *gin.Engine {
-	r := gin.Default()
-	r.GET("/todos", s.getTodos)
-	r.POST("/todos", s.createTodo)
-	r.DELETE("/todos/:uuid", s.deleteTodo)
-	r.PATCH("/todos/:uuid", s.patchTodo)
-	// Disable unsupported methods
-	r.DELETE("/todos", func(c *gin.Context) {
-		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "DELETE /todos is not allowed"})
-	})
-	r.PATCH("/todos", func(c *gin.Context) {
-		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "PATCH /todos is not allowed"})
-	})
+	r := gin.New()
+	r.Use(gin.Logger(), gin.CustomRecovery(recovery), instrumented())
+	trustProxies(r, trustedProxiesFromEnv(), identityHeader)
+	r.NoRoute(noRoute)
+
+	spec := mustLoadOpenAPISpec()
+	// Validate traffic against the spec in tests, or when explicitly asked to (e.g. in a dev cluster)
+	if gin.Mode() == gin.TestMode || os.Getenv("OPENAPI_VALIDATION") == "true" {
+		r.Use(openAPIValidator(spec))
+	}
+	// Registered after the validator, so the 503s are checked against the spec too
+	r.Use(s.readOnlyDuringMaintenance())
+	r.GET("/openapi.json", getOpenAPISpec(spec))
+	r.GET("/docs", getDocs)
+	r.GET("/healthz", healthHandler(s.livenessChecks()))
+	r.GET("/metrics", getMetrics)
+	r.GET("/readyz", s.getReadiness)
+
+	// Retried POSTs with the same Idempotency-Key do not create duplicates
+	idempotencyStore := NewIdempotencyStore(idempotencyTTLFromEnv())
+
+	// Every route has its own budget, so e.g. comments do not eat into creating todos. The
+	// class of a route sets its default limit
+	read := routeRateLimited(rateLimitFromEnv("RATE_LIMIT_READ", defaultReadRateLimit))
+	create := routeRateLimited(rateLimitFromEnv("RATE_LIMIT_CREATE", defaultCreateRateLimit))
+	write := routeRateLimited(rateLimitFromEnv("RATE_LIMIT_WRITE", defaultWriteRateLimit))
+
+	// The todo routes are served as /api/v1, /api/v2 and the deprecated legacy /todos
+	todoRoutes := func(g *gin.RouterGroup) {
+		g.GET("/todos", read, negotiated(todoMediaTypes...), s.getTodos)
+		// The history and the change feed show no more than the list did, without the actors
+		// of the audit trail, so they are for everyone
+		g.GET("/todos/diff", read, s.getTodosDiff)
+		g.GET("/todos/changes", read, s.getTodoChanges)
+		g.POST("/todos", create, negotiated(todoMediaTypes...), idempotent(idempotencyStore), s.createTodo)
+		g.DELETE("/todos/:uuid", write, s.deleteTodo)
+		g.PATCH("/todos/:uuid", write, negotiated(todoMediaTypes...), s.patchTodo)
+		g.GET("/todos/:uuid/occurrences", read, s.getOccurrences)
+		g.GET("/todos/:uuid/comments", read, s.getComments)
+		g.POST("/todos/:uuid/comments", create, s.createComment)
+		g.PATCH("/todos/:uuid/comments/:id", write, s.patchComment)
+		g.DELETE("/todos/:uuid/comments/:id", write, s.deleteComment)
+		g.GET("/todos/:uuid/attachments", read, s.getAttachments)
+		g.POST("/todos/:uuid/attachments", create, s.createAttachment)
+		g.GET("/todos/:uuid/attachments/:id", read, s.getAttachment)
+		g.DELETE("/todos/:uuid/attachments/:id", write, s.deleteAttachment)
+		g.POST("/todos/:uuid/transition", write, negotiated(todoMediaTypes...), s.transitionTodo)
+
+		// Disable unsupported methods
+		g.DELETE("/todos", func(c *gin.Context) {
+			abortWithProblem(c, NewProblem(http.StatusMethodNotAllowed, "DELETE /todos is not allowed"))
+		})
+		g.PATCH("/todos", func(c *gin.Context) {
+			abortWithProblem(c, NewProblem(http.StatusMethodNotAllowed, "PATCH /todos is not allowed"))
+		})
+	}
+	todoRoutes(r.Group("", deprecatedRoute(legacyDeprecatedAt, legacySunset)))
+	todoRoutes(r.Group(apiV1.prefix, useAPIVersion(apiV1)))
+	todoRoutes(r.Group(apiV2.prefix, useAPIVersion(apiV2)))
+
+	r.GET("/workflow", read, s.getWorkflow)
+	r.GET("/board", read, s.getBoard)
+	r.GET("/audit", requireAdmin(adminsFromEnv()), read, s.getAudit)
+	r.GET("/stats", read, s.getStats)
+	r.GET("/templates", read, s.getTemplates)
+	r.POST("/templates", create, s.createTemplate)
+	r.GET("/templates/:id", read, s.getTemplate)
+	r.PUT("/templates/:id", write, s.putTemplate(adminsFromEnv()))
+	r.DELETE("/templates/:id", write, s.deleteTemplate(adminsFromEnv()))
+	r.POST("/templates/:id/instantiate", create, idempotent(idempotencyStore), s.instantiateTemplate)
+	r.GET("/shares", read, s.getShares(adminsFromEnv()))
+	r.POST("/shares", create, s.createShare)
+	r.DELETE("/shares/:id", write, s.revokeShare(adminsFromEnv()))
+	sharedRoutes(r, read, s.SharedTodos)
+
+	admin := r.Group("/admin", requireAdmin(adminsFromEnv()), write)
+	admin.GET("/jobs", s.getJobs)
+	admin.POST("/jobs/:id/retry", s.retryJob)
+	admin.POST("/rekey", postRekey(s.Rekey))
+	admin.GET("/maintenance", s.getMaintenance)
+	admin.PUT("/maintenance", s.putMaintenance)
+	admin.DELETE("/maintenance", s.deleteMaintenance)
+	admin.POST("/backup", s.postBackup)
+	admin.GET("/backups", s.getBackups)
+	admin.POST("/restore", s.postRestore)
 	return r
 }
 
-// getTodos handles retrieval of all todo items.
+// getTodos handles retrieval of all todo items, now or as they were at a past instant.
+// @param as_of query string false "Instant (RFC 3339) to rebuild the list at"
 // @success 200 {array} Todo
+// @failure 400 {object} Problem
 func (s *TodoMgr) getTodos(c *gin.Context) {
-	if len(s.todosSorted) == 0 {
-		c.JSON(http.StatusOK, []Todo{})
+	if c.Query("as_of") == "" {
+		renderTodos(c, http.StatusOK, s.List())
 		return
 	}
-
-	s.mu.RLock()
-	// return a copy to avoid racey access by callers
-	out := make([]Todo, len(s.todosSorted))
-	copy(out, s.todosSorted)
-	s.mu.RUnlock()
-
-	c.JSON(http.StatusOK, out)
+	at, ok := parseInstant(c, "as_of", time.Time{})
+	if !ok {
+		return
+	}
+	renderTodos(c, http.StatusOK, s.ListAsOf(at))
 }
 
 // createTodo handles the creation of a new todo item.
 // @param description body string true "Description of the todo"
+// @param due_at body string false "Due date (RFC 3339)"
+// @param rrule body string false "RFC 5545 RRULE making the todo recurring"
+// @param parse_dates query bool false "Take a due date typed into the description out into due_at"
+// @param Parse-Dates header bool false "Same as parse_dates"
+// @param tz query string false "IANA time zone of typed dates, default UTC"
+// @param Idempotency-Key header string false "Replays the first response for retries with the same key"
 // @success 201 {object} Todo
-// @failure 400 {object} map[string]string
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 409 {object} Problem
+// @failure 422 {object} Problem
+// @failure 429 {object} Problem
 func (s *TodoMgr) createTodo(c *gin.Context) {
 	var req struct {
-		Description string `json:"description"`
+		Description string     `json:"description"`
+		DueAt       *time.Time `json:"due_at"`
+		RRule       string     `json:"rrule"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request" + err.Error()})
+	if !bindBody(c, &req) {
 		return
 	}
 
-	req.Description = strings.TrimSpace(req.Description)
-	if req.Description == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "description is required"})
+	in := TodoInput{Description: req.Description, DueAt: req.DueAt, RRule: req.RRule}
+	parse, ok := parseDatesRequested(c)
+	if !ok {
 		return
 	}
+	// An explicit due_at wins over anything written in the description
+	var parsed ParsedDueDate
+	if parse && in.DueAt == nil {
+		loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+		if err != nil {
+			abortWithValidationError(c, "tz", "is not a known time zone")
+			return
+		}
+		if parsed, ok = parseDueDate(in.Description, time.Now(), loc); ok {
+			in.Description, in.DueAt = parsed.Description, &parsed.DueAt
+		}
+	}
 
-	if len(req.Description) > TODOMAXLENGTTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "description exceeds maximum length"})
+	t, err := s.Create(requestIdentity(c), in)
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	// Create new todo
-
-	t := Todo{
-		UUID:        uuid.New().String(),
-		Description: req.Description,
-		CreatedAt:   time.Now().UTC(),
-		ChangedAt:   time.Now().UTC(),
+	if parsed.Expression != "" {
+		c.Header(parsedDueDateHeader, parsed.Expression)
 	}
-
-	s.mu.Lock()
-	s.todosSorted = append(s.todosSorted, t)
-	s.mu.Unlock()
-
-	c.JSON(http.StatusCreated, t)
+	renderTodo(c, http.StatusCreated, t)
 }
 
 // deleteTodo handles deletion of a todo by UUID.
 // @param uuid path string true "UUID of the todo to delete"
 // @success 200 {object} map[string]string
-// @failure 400 {object} map[string]string
-// @failure 404 {object} map[string]string
+// @failure 400 {object} Problem
+// @failure 404 {object} Problem
 func (s *TodoMgr) deleteTodo(c *gin.Context) {
-	UUID := c.Param("uuid")
-
+	UUID := strings.TrimSpace(c.Param("uuid"))
 	if UUID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "uuid is required"})
+		abortWithValidationError(c, "uuid", "is required")
 		return
 	}
 
-	UUID = strings.TrimSpace(UUID)
-	if UUID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "uuid is required"})
+	if _, err := s.Delete(requestIdentity(c), UUID); err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	for i, t := range s.todosSorted {
-		if t.UUID == UUID {
-			// Remove the todo from the slice
-			s.todosSorted = append(s.todosSorted[:i], s.todosSorted[i+1:]...)
-			c.JSON(http.StatusOK, gin.H{"message": "todo deleted"})
-			return
-		}
-	}
-
-	c.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+	c.JSON(http.StatusOK, gin.H{"message": "todo deleted"})
 }
 
-// patchTodo handles partial updates to a todo's description.
+// patchTodo handles partial updates to a todo. Completing a recurring todo creates its next occurrence.
 // @param uuid path string true "UUID of the todo to update"
-// @param description body string true "New description for the todo"
+// @param description body string false "New description for the todo"
+// @param done body bool false "Marks the todo completed or open"
+// @param due_at body string false "New due date (RFC 3339)"
+// @param rrule body string false "New RRULE, empty to stop the recurrence"
 // @success 200 {object} Todo
-// @failure 400 {object} map[string]string
-// @failure 404 {object} map[string]string
+// @failure 400 {object} Problem
+// @failure 404 {object} Problem
 func (s *TodoMgr) patchTodo(c *gin.Context) {
-	UUID := c.Param("uuid")
+	UUID := strings.TrimSpace(c.Param("uuid"))
 	if UUID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "uuid is required"})
+		abortWithValidationError(c, "uuid", "is required")
 		return
 	}
 
 	var req struct {
-		Description string `json:"description"`
+		Description *string    `json:"description"`
+		Done        *bool      `json:"done"`
+		DueAt       *time.Time `json:"due_at"`
+		RRule       *string    `json:"rrule"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+	if !bindBody(c, &req) {
 		return
 	}
 
-	UUID = strings.TrimSpace(UUID)
-	req.Description = strings.TrimSpace(req.Description)
-
-	if req.Description == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "new description is required"})
+	t, err := s.Update(requestIdentity(c), UUID, TodoPatch{Description: req.Description, Done: req.Done, DueAt: req.DueAt, RRule: req.RRule})
+	if err != nil {
+		abortWithError(c, err)
 		return
 	}
 
-	if len(req.Description) > TODOMAXLENGTTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "description exceeds maximum length"})
+	renderTodo(c, http.StatusOK, t)
+}
+
+// getOccurrences expands the upcoming due dates of a todo.
+// @param uuid path string true "UUID of the todo"
+// @param from query string false "Start of the range (RFC 3339), defaults to now"
+// @param to query string false "End of the range (RFC 3339), defaults to 90 days after from"
+// @success 200 {object} map[string]any
+// @failure 400 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) getOccurrences(c *gin.Context) {
+	UUID := strings.TrimSpace(c.Param("uuid"))
+	if UUID == "" {
+		abortWithValidationError(c, "uuid", "is required")
 		return
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	// For proper patch, we should check which fields are provided and different, and only update those.
-	for i, t := range s.todosSorted {
-		if t.UUID == UUID {
-			s.todosSorted[i].Description = req.Description
-			s.todosSorted[i].ChangedAt = time.Now().UTC()
-			c.JSON(http.StatusOK, s.todosSorted[i])
+	from := time.Now().UTC()
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			abortWithValidationError(c, "from", "must be an RFC 3339 date-time")
+			return
+		}
+		from = parsed.UTC()
+	}
+	to := from.AddDate(0, 0, 90)
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			abortWithValidationError(c, "to", "must be an RFC 3339 date-time")
 			return
 		}
+		to = parsed.UTC()
+	}
+	if to.Before(from) {
+		abortWithValidationError(c, "to", "must not be before from")
+		return
 	}
 
-	c.JSON(http.StatusNotFound, gin.H{"error": "todo not found"})
+	occurrences, truncated, err := s.Occurrences(UUID, from, to)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uuid":        UUID,
+		"from":        from,
+		"to":          to,
+		"occurrences": occurrences,
+		"truncated":   truncated,
+	})
 }
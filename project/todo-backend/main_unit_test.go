@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
@@ -14,14 +15,39 @@ import (
 )
 
 func TestMain(m *testing.M) {
-	// Silence Gin during tests
-	gin.SetMode(gin.ReleaseMode)
+	// Silence Gin during tests. Test mode also turns on OpenAPI request/response validation.
+	gin.SetMode(gin.TestMode)
 	gin.DefaultWriter = io.Discard
 	gin.DefaultErrorWriter = io.Discard
+	// httptest requests come from 192.0.2.1, trust them to carry X-Forwarded-User
+	os.Setenv("TRUSTED_PROXIES", "192.0.2.0/24")
 
 	m.Run()
 }
 
+// apiRequest sends a request to the router as user, anonymously when user is empty. A
+// non-empty body is sent as JSON.
+func apiRequest(router http.Handler, method, path, user, body string) *httptest.ResponseRecorder {
+	return serve(router, newAPIRequest(method, path, user, body))
+}
+
+func newAPIRequest(method, path, user, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != "" {
+		req.Header.Set(identityHeader, user)
+	}
+	return req
+}
+
+func serve(router http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
 func TestSetupRouter(t *testing.T) {
 	s := &TodoMgr{}
 	router := setupRouter(s)
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"fazstrac/project/todo-backend/todopb"
+)
+
+// Maintenance mode freezes writes, e.g. during a storage migration, without taking the API
+// down: reads keep working, while every request that would change something, admin ones
+// such as restores included, gets a 503 with Retry-After and the reason of the maintenance
+// in the problem. Background jobs are paused too. Admins switch it on with
+// PUT /admin/maintenance and off with DELETE, or by sending todo-backend SIGUSR1, which
+// toggles it.
+//
+// Design choice: the pod stays ready during maintenance, otherwise Kubernetes would stop
+// sending it the reads as well. /readyz reports the mode instead.
+
+const (
+	defaultMaintenanceReason     = "maintenance"
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+	maxMaintenanceRetryAfter     = 24 * time.Hour
+)
+
+// maintenanceWritable are the routes that stay writable during maintenance: switching it
+// off, and taking backups, which do not change the todos and are wanted right before a
+// migration.
+var maintenanceWritable = map[string]bool{
+	"/admin/maintenance": true,
+	"/admin/backup":      true,
+}
+
+// maintenanceReasonPattern keeps reasons machine-readable, e.g. storage-migration.
+var maintenanceReasonPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// MaintenanceStatus describes the maintenance mode.
+type MaintenanceStatus struct {
+	Enabled    bool       `json:"enabled"`
+	Reason     string     `json:"reason,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	RetryAfter int        `json:"retry_after,omitempty"` // seconds
+	Since      *time.Time `json:"since,omitempty"`
+}
+
+// MaintenanceInput holds the fields of PUT /admin/maintenance.
+type MaintenanceInput struct {
+	Reason     string `json:"reason"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"` // seconds
+}
+
+// StartMaintenance makes todo-backend read-only. Calling it again updates the reason.
+func (s *TodoMgr) StartMaintenance(in MaintenanceInput) (MaintenanceStatus, error) {
+	in.Reason = strings.TrimSpace(in.Reason)
+	if in.Reason == "" {
+		in.Reason = defaultMaintenanceReason
+	}
+	if !maintenanceReasonPattern.MatchString(in.Reason) {
+		return MaintenanceStatus{}, &ValidationError{Field: "reason", Message: "must be lower case letters, digits and dashes"}
+	}
+	retryAfter := time.Duration(in.RetryAfter) * time.Second
+	if in.RetryAfter == 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+	if retryAfter < time.Second || retryAfter > maxMaintenanceRetryAfter {
+		return MaintenanceStatus{}, &ValidationError{Field: "retry_after", Message: "must be between 1 second and 24 hours"}
+	}
+
+	since := time.Now().UTC()
+	if current := s.maintenance.Load(); current != nil {
+		since = *current.Since
+	}
+	m := &MaintenanceStatus{
+		Enabled:    true,
+		Reason:     in.Reason,
+		Message:    strings.TrimSpace(in.Message),
+		RetryAfter: int(retryAfter / time.Second),
+		Since:      &since,
+	}
+	s.maintenance.Store(m)
+	if s.Jobs != nil {
+		s.Jobs.SetPaused(true)
+	}
+	return *m, nil
+}
+
+// EndMaintenance makes todo-backend writable again.
+func (s *TodoMgr) EndMaintenance() {
+	s.endMaintenance(true)
+}
+
+// endMaintenance makes todo-backend writable again, resuming the jobs only if resumeJobs
+// is set, since a suspended tenant keeps them paused.
+func (s *TodoMgr) endMaintenance(resumeJobs bool) {
+	s.maintenance.Store(nil)
+	if s.Jobs != nil && resumeJobs {
+		s.Jobs.SetPaused(false)
+	}
+}
+
+// Maintenance returns the current maintenance mode.
+func (s *TodoMgr) Maintenance() MaintenanceStatus {
+	if m := s.maintenance.Load(); m != nil {
+		return *m
+	}
+	return MaintenanceStatus{}
+}
+
+// ToggleMaintenance switches the maintenance mode on with the defaults, or off.
+func (s *TodoMgr) ToggleMaintenance() MaintenanceStatus {
+	if s.Maintenance().Enabled {
+		s.EndMaintenance()
+		return MaintenanceStatus{}
+	}
+	m, _ := s.StartMaintenance(MaintenanceInput{})
+	return m
+}
+
+// readOnlyDuringMaintenance rejects requests that change something while in maintenance.
+// Only the maintenanceWritable routes are exempt; restores, rekeys and job retries wait like
+// every other write.
+func (s *TodoMgr) readOnlyDuringMaintenance() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		m := s.Maintenance()
+		if !m.Enabled || maintenanceWritable[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		detail := "todo-backend is read-only during maintenance"
+		if m.Message != "" {
+			detail += ": " + m.Message
+		}
+		p := NewProblem(http.StatusServiceUnavailable, detail)
+		p.Reason = m.Reason
+		c.Header("Retry-After", strconv.Itoa(m.RetryAfter))
+		abortWithProblem(c, p)
+	}
+}
+
+// readOnlyMethods are the gRPC methods allowed during maintenance.
+var readOnlyMethods = map[string]bool{
+	todopb.TodoService_ListTodos_FullMethodName:  true,
+	todopb.TodoService_GetTodo_FullMethodName:    true,
+	todopb.TodoService_WatchTodos_FullMethodName: true,
+}
+
+// maintenanceInterceptor is the gRPC counterpart of readOnlyDuringMaintenance.
+func (s *TodoMgr) maintenanceInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	m := s.Maintenance()
+	if !m.Enabled || readOnlyMethods[info.FullMethod] || !strings.HasPrefix(info.FullMethod, "/todo.") {
+		return handler(ctx, req)
+	}
+	st := status.New(codes.Unavailable, "todo-backend is read-only during maintenance")
+	detailed, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: m.Reason, Domain: "todo-backend"},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(m.RetryAfter) * time.Second)},
+	)
+	if err != nil {
+		return nil, st.Err()
+	}
+	return nil, detailed.Err()
+}
+
+// getMaintenance returns the maintenance mode.
+// @success 200 {object} MaintenanceStatus
+// @failure 403 {object} Problem
+func (s *TodoMgr) getMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Maintenance())
+}
+
+// putMaintenance switches maintenance mode on.
+// @param reason body string false "Machine-readable reason, default maintenance"
+// @param message body string false "Explanation for humans"
+// @param retry_after body int false "Seconds clients should wait before retrying, default 300"
+// @success 200 {object} MaintenanceStatus
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+func (s *TodoMgr) putMaintenance(c *gin.Context) {
+	var req MaintenanceInput
+	// An empty body takes the defaults, like SIGUSR1
+	if !bindOptionalBody(c, &req) {
+		return
+	}
+	m, err := s.StartMaintenance(req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// deleteMaintenance switches maintenance mode off.
+// @success 200 {object} MaintenanceStatus
+// @failure 403 {object} Problem
+func (s *TodoMgr) deleteMaintenance(c *gin.Context) {
+	s.EndMaintenance()
+	c.JSON(http.StatusOK, s.Maintenance())
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"fazstrac/project/todo-backend/todopb"
+)
+
+func TestMaintenance_FreezesWrites(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "existing"})
+	require.NoError(t, err)
+	router := setupRouter(s)
+
+	assert.Equal(t, http.StatusForbidden, apiRequest(router, http.MethodPut, "/admin/maintenance", "alice", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, apiRequest(router, http.MethodPut, "/admin/maintenance", "root", `{"reason":"Storage Migration"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, apiRequest(router, http.MethodPut, "/admin/maintenance", "root", `{"retry_after":-5}`).Code)
+
+	w := apiRequest(router, http.MethodPut, "/admin/maintenance", "root",
+		`{"reason":"storage-migration","message":"moving to the new volume","retry_after":120}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var m MaintenanceStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.True(t, m.Enabled)
+	assert.Equal(t, "storage-migration", m.Reason)
+	assert.Equal(t, 120, m.RetryAfter)
+
+	// Reads keep working
+	w = apiRequest(router, http.MethodGet, "/todos", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "existing")
+
+	for _, r := range []struct{ method, path, body string }{
+		{http.MethodPost, "/todos", `{"description":"new"}`},
+		{http.MethodPatch, "/todos/" + todo.UUID, `{"done":true}`},
+		{http.MethodDelete, "/todos/" + todo.UUID, ""},
+		{http.MethodPost, "/templates", `{"name":"release","items":["tag"]}`},
+		{http.MethodPost, "/admin/restore", `{"id":"backup"}`},
+		{http.MethodPost, "/admin/rekey", ""},
+		{http.MethodPost, "/admin/jobs/x/retry", ""},
+	} {
+		w := apiRequest(router, r.method, r.path, "root", r.body)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code, r.method+" "+r.path)
+		assert.Equal(t, "120", w.Header().Get("Retry-After"))
+		var p Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		assert.Equal(t, "storage-migration", p.Reason)
+		assert.Contains(t, p.Detail, "moving to the new volume")
+	}
+	_, err = s.Get(todo.UUID)
+	assert.NoError(t, err)
+
+	w = apiRequest(router, http.MethodGet, "/readyz", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"read-only"`)
+
+	// Backups can still be taken; this store has none configured
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodPost, "/admin/backup", "root", "").Code)
+
+	// Admins can still end maintenance
+	require.Equal(t, http.StatusOK, apiRequest(router, http.MethodDelete, "/admin/maintenance", "root", "").Code)
+	assert.Equal(t, http.StatusCreated, apiRequest(router, http.MethodPost, "/todos", "", `{"description":"new"}`).Code)
+	w = apiRequest(router, http.MethodGet, "/readyz", "", "")
+	assert.Contains(t, w.Body.String(), `"status":"ready"`)
+	w = apiRequest(router, http.MethodGet, "/admin/maintenance", "root", "")
+	assert.JSONEq(t, `{"enabled":false}`, w.Body.String())
+}
+
+func TestMaintenance_Toggle(t *testing.T) {
+	s := &TodoMgr{}
+	m := s.ToggleMaintenance()
+	assert.True(t, m.Enabled)
+	assert.Equal(t, defaultMaintenanceReason, m.Reason)
+	assert.Equal(t, 300, m.RetryAfter)
+
+	// Updating the reason keeps the start
+	updated, err := s.StartMaintenance(MaintenanceInput{Reason: "reindex"})
+	require.NoError(t, err)
+	assert.Equal(t, m.Since, updated.Since)
+
+	assert.False(t, s.ToggleMaintenance().Enabled)
+	assert.False(t, s.Maintenance().Enabled)
+}
+
+func TestMaintenance_StartBodies(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	// Without a body the defaults apply, like for SIGUSR1
+	w := apiRequest(router, http.MethodPut, "/admin/maintenance", "root", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, defaultMaintenanceReason, s.Maintenance().Reason)
+
+	// Chunked requests have no Content-Length to tell that the body is empty. The OpenAPI
+	// validation of the tests buffers the body and sets one, so call the handler directly.
+	w = httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader(""))
+	c.Request.ContentLength = -1
+	s.putMaintenance(c)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	req := httptest.NewRequest(http.MethodPut, "/admin/maintenance", strings.NewReader("reason: reindex\nretry_after: 60\n"))
+	req.Header.Set("Content-Type", "application/yaml")
+	req.Header.Set(identityHeader, "root")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "reindex", s.Maintenance().Reason)
+	assert.Equal(t, 60, s.Maintenance().RetryAfter)
+}
+
+func TestMaintenance_GRPC(t *testing.T) {
+	s := &TodoMgr{}
+	client := todopb.NewTodoServiceClient(setupGRPCTest(t, s))
+	ctx := context.Background()
+	_, err := s.StartMaintenance(MaintenanceInput{Reason: "storage-migration", RetryAfter: 60})
+	require.NoError(t, err)
+
+	_, err = client.ListTodos(ctx, &todopb.ListTodosRequest{})
+	require.NoError(t, err)
+
+	_, err = client.CreateTodo(ctx, &todopb.CreateTodoRequest{Description: "new"})
+	st := status.Convert(err)
+	require.Equal(t, codes.Unavailable, st.Code())
+	var reason string
+	var delay time.Duration
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.GetReason()
+		case *errdetails.RetryInfo:
+			delay = d.GetRetryDelay().AsDuration()
+		}
+	}
+	assert.Equal(t, "storage-migration", reason)
+	assert.Equal(t, time.Minute, delay)
+}
+
+func TestMaintenance_PausesJobs(t *testing.T) {
+	q := startJobQueue(t, "", 1)
+	var runs atomic.Int32
+	q.Handle("count", func(ctx context.Context, job Job) error {
+		runs.Add(1)
+		return nil
+	})
+	q.Start()
+	defer stopJobQueue(t, q)
+	s := &TodoMgr{Jobs: q}
+	_, err := s.StartMaintenance(MaintenanceInput{})
+	require.NoError(t, err)
+
+	_, err = q.Enqueue("count", nil, time.Time{})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Zero(t, runs.Load())
+
+	s.EndMaintenance()
+	assert.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text format. They live in the
+// default registry, which also has the Go runtime and process metrics, and are shared by
+// all tenants; only the todo count is per tenant.
+
+const metricsNamespace = "todo_backend"
+
+// unmatchedRoute is the route label of requests no route matched, so that probing for
+// random paths does not create new series.
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	mutations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mutations_total",
+		Help:      "Changes to todos and comments by type, as recorded in the audit trail.",
+	}, []string{"type"})
+
+	validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "validation_failures_total",
+		Help:      "Requests rejected for an invalid field, by field.",
+	}, []string{"field"})
+
+	storeOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of store operations, including waiting for the lock.",
+		// 10µs to about 2.6s; the store is in memory, but backups and restores are not
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"operation"})
+)
+
+var todoCountDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "todos"),
+	"Current number of todos, by tenant (empty without tenants).",
+	[]string{"tenant"}, nil)
+
+// todoCountCollector reports the todo count of the stores at scrape time.
+type todoCountCollector struct {
+	each func(fn func(tenant string, s *TodoMgr))
+}
+
+// newTodoCountCollector returns a collector of the todos of a single TodoMgr.
+func newTodoCountCollector(s *TodoMgr) prometheus.Collector {
+	return todoCountCollector{each: func(fn func(string, *TodoMgr)) { fn("", s) }}
+}
+
+// newTenantTodoCountCollector returns a collector of the todos of every tenant.
+func newTenantTodoCountCollector(reg *TenantRegistry) prometheus.Collector {
+	return todoCountCollector{each: reg.Each}
+}
+
+func (c todoCountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- todoCountDesc
+}
+
+func (c todoCountCollector) Collect(ch chan<- prometheus.Metric) {
+	c.each(func(tenant string, s *TodoMgr) {
+		ch <- prometheus.MustNewConstMetric(todoCountDesc, prometheus.GaugeValue, float64(s.Count()), tenant)
+	})
+}
+
+// storeTimer starts timing a store operation; call ObserveDuration when it is done.
+func storeTimer(operation string) *prometheus.Timer {
+	return prometheus.NewTimer(storeOperationDuration.WithLabelValues(operation))
+}
+
+// instrumented counts the requests and observes their latency by route and status.
+func instrumented() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// getMetrics serves the metrics in the Prometheus text format.
+// @success 200 {string} string
+var getMetrics = gin.WrapH(promhttp.Handler())
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Requests(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	created := httpRequests.WithLabelValues(http.MethodPost, "/todos", "201")
+	invalid := httpRequests.WithLabelValues(http.MethodPost, "/todos", "400")
+	unmatched := httpRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")
+	createdBefore, invalidBefore, unmatchedBefore := testutil.ToFloat64(created), testutil.ToFloat64(invalid), testutil.ToFloat64(unmatched)
+	tooLong := validationFailures.WithLabelValues("description")
+	tooLongBefore := testutil.ToFloat64(tooLong)
+	mutationsBefore := testutil.ToFloat64(mutations.WithLabelValues(string(AuditTodoCreated)))
+
+	w := apiRequest(router, http.MethodPost, "/todos", "", `{"description":"counted"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	w = apiRequest(router, http.MethodPost, "/todos", "", `{"description":"`+strings.Repeat("x", TODOMAXLENGTTH+1)+`"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	apiRequest(router, http.MethodGet, "/no/such/route", "", "")
+
+	assert.Equal(t, createdBefore+1, testutil.ToFloat64(created))
+	assert.Equal(t, invalidBefore+1, testutil.ToFloat64(invalid))
+	assert.Equal(t, unmatchedBefore+1, testutil.ToFloat64(unmatched), "unknown paths share one series")
+	assert.Equal(t, tooLongBefore+1, testutil.ToFloat64(tooLong))
+	assert.Equal(t, mutationsBefore+1, testutil.ToFloat64(mutations.WithLabelValues(string(AuditTodoCreated))))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `todo_backend_http_requests_total{method="POST",route="/todos",status="201"}`)
+	assert.Contains(t, body, `todo_backend_http_request_duration_seconds_bucket{method="POST",route="/todos",status="201",le=`)
+	assert.Contains(t, body, `todo_backend_store_operation_duration_seconds_count{operation="create"}`)
+	assert.Contains(t, body, `todo_backend_validation_failures_total{field="description"}`)
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestMetrics_Mutations(t *testing.T) {
+	s := &TodoMgr{}
+	counts := func() (created, updated, deleted, commented float64) {
+		return testutil.ToFloat64(mutations.WithLabelValues(string(AuditTodoCreated))),
+			testutil.ToFloat64(mutations.WithLabelValues(string(AuditTodoUpdated))),
+			testutil.ToFloat64(mutations.WithLabelValues(string(AuditTodoDeleted))),
+			testutil.ToFloat64(mutations.WithLabelValues(string(AuditCommentCreated)))
+	}
+	created, updated, deleted, commented := counts()
+
+	todo, err := s.Create("", TodoInput{Description: "mutated"})
+	require.NoError(t, err)
+	done := true
+	_, err = s.Update("", todo.UUID, TodoPatch{Done: &done})
+	require.NoError(t, err)
+	_, err = s.AddComment("", todo.UUID, "noted")
+	require.NoError(t, err)
+	_, err = s.Delete("", todo.UUID)
+	require.NoError(t, err)
+
+	c, u, d, cc := counts()
+	assert.Equal(t, created+1, c)
+	assert.Equal(t, updated+1, u)
+	assert.Equal(t, deleted+1, d)
+	assert.Equal(t, commented+1, cc)
+}
+
+func TestMetrics_TodoCount(t *testing.T) {
+	s := &TodoMgr{}
+	_, err := s.Create("", TodoInput{Description: "one"})
+	require.NoError(t, err)
+	assert.NoError(t, testutil.CollectAndCompare(newTodoCountCollector(s), strings.NewReader(`
+# HELP todo_backend_todos Current number of todos, by tenant (empty without tenants).
+# TYPE todo_backend_todos gauge
+todo_backend_todos{tenant=""} 1
+`)))
+
+	reg, err := NewTenantRegistry(t.TempDir(), nil, inMemoryTenants)
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "globex"})
+	require.NoError(t, err)
+	acme, err := reg.resolve("acme")
+	require.NoError(t, err)
+	_, err = acme.mgr.Create("", TodoInput{Description: "for acme"})
+	require.NoError(t, err)
+	assert.NoError(t, testutil.CollectAndCompare(newTenantTodoCountCollector(reg), strings.NewReader(`
+# HELP todo_backend_todos Current number of todos, by tenant (empty without tenants).
+# TYPE todo_backend_todos gauge
+todo_backend_todos{tenant="acme"} 1
+todo_backend_todos{tenant="globex"} 0
+`)))
+}
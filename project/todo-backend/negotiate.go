@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Todos are served in the media type the client asks for in Accept, so scripts can read
+// them without a JSON parser:
+//
+//	application/json     the default, also for */* or no Accept header
+//	application/yaml
+//	application/msgpack
+//	text/csv             a header row and one row per todo
+//
+// A request accepting none of them gets a 406. Request bodies of creating and updating
+// todos can be sent in the same media types, as named by Content-Type; a CSV body is a
+// header row with the field names and one row with their values.
+//
+// Design choice: YAML and MessagePack are converted from the JSON representation, so the
+// field names, omitted fields and date formats are the same in all of them.
+
+const (
+	mediaJSON    = "application/json"
+	mediaYAML    = "application/yaml"
+	mediaMsgpack = "application/msgpack"
+	mediaCSV     = "text/csv"
+)
+
+// todoMediaTypes are the media types of todos, in order of preference.
+var todoMediaTypes = []string{mediaJSON, mediaYAML, mediaMsgpack, mediaCSV}
+
+// mediaAliases maps other names in use to the media types above.
+var mediaAliases = map[string]string{
+	"application/x-yaml":      mediaYAML,
+	"text/yaml":               mediaYAML,
+	"application/x-msgpack":   mediaMsgpack,
+	"application/vnd.msgpack": mediaMsgpack,
+}
+
+const mediaTypeKey = "mediaType"
+
+// errUnsupportedMediaType is returned for request bodies of an unsupported media type.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// negotiateMediaType returns the offer the Accept header prefers, or "" if it accepts
+// none. Ties go to the earlier offer.
+func negotiateMediaType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The most specific range matching the offer decides its quality
+		q, specificity := 0.0, -1
+		for _, part := range strings.Split(accept, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			if alias, ok := mediaAliases[mediaRange]; ok {
+				mediaRange = alias
+			}
+			s := matchMediaRange(mediaRange, offer)
+			if s <= specificity {
+				continue
+			}
+			rangeQ := 1.0
+			if value, ok := params["q"]; ok {
+				if rangeQ, err = strconv.ParseFloat(value, 64); err != nil {
+					rangeQ = 0
+				}
+			}
+			q, specificity = rangeQ, s
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// matchMediaRange returns how specifically the range matches the media type: 2 for an
+// exact match, 1 for type/*, 0 for */* and -1 for no match.
+func matchMediaRange(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+// negotiated picks the media type of the response before the handler does any work,
+// or rejects the request with a 406.
+func negotiated(offers ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mediaType := negotiateMediaType(c.GetHeader("Accept"), offers)
+		if mediaType == "" {
+			abortWithProblem(c, NewProblem(http.StatusNotAcceptable,
+				"acceptable media types are "+strings.Join(offers, ", ")))
+			return
+		}
+		c.Set(mediaTypeKey, mediaType)
+		c.Header("Vary", "Accept")
+		c.Next()
+	}
+}
+
+// respond writes value in the negotiated media type. CSV is written from the rows
+// instead, the first being the header.
+func respond(c *gin.Context, status int, value any, rows func() [][]string) {
+	mediaType := c.GetString(mediaTypeKey)
+	if mediaType == "" || mediaType == mediaJSON {
+		c.JSON(status, value)
+		return
+	}
+
+	var data []byte
+	var err error
+	switch mediaType {
+	case mediaCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.WriteAll(rows())
+		data, err = buf.Bytes(), w.Error()
+		mediaType += "; charset=utf-8; header=present"
+	case mediaYAML:
+		var generic any
+		if generic, err = jsonGeneric(value); err == nil {
+			data, err = yaml.Marshal(generic)
+		}
+	case mediaMsgpack:
+		var generic any
+		if generic, err = jsonGeneric(value); err == nil {
+			data, err = msgpack.Marshal(generic)
+		}
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Data(status, mediaType, data)
+}
+
+// jsonGeneric returns the value as decoded from its JSON representation.
+func jsonGeneric(value any) (any, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	return generic, json.Unmarshal(data, &generic)
+}
+
+// bindBody decodes the request body into obj, a pointer to a struct with json tags,
+// according to Content-Type. It aborts the request and reports false on failure.
+func bindBody(c *gin.Context, obj any) bool {
+	err := decodeBody(c.ContentType(), c.Request.Body, obj)
+	if errors.Is(err, errUnsupportedMediaType) {
+		abortWithProblem(c, NewProblem(http.StatusUnsupportedMediaType,
+			"request bodies must be "+strings.Join(todoMediaTypes, ", ")))
+		return false
+	}
+	if err != nil {
+		abortWithProblem(c, NewProblem(http.StatusBadRequest, "invalid request: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+// bindOptionalBody is bindBody for requests whose body may be left out, in which case obj
+// is left as it is. Chunked requests carry no Content-Length, so the body is peeked at.
+func bindOptionalBody(c *gin.Context, obj any) bool {
+	body := bufio.NewReader(c.Request.Body)
+	if _, err := body.Peek(1); err == io.EOF {
+		return true
+	}
+	c.Request.Body = io.NopCloser(body)
+	return bindBody(c, obj)
+}
+
+// decodeBody decodes a body of the media type into obj via its JSON representation.
+func decodeBody(mediaType string, body io.Reader, obj any) error {
+	if alias, ok := mediaAliases[mediaType]; ok {
+		mediaType = alias
+	}
+	var generic any
+	switch mediaType {
+	case "", mediaJSON:
+		return json.NewDecoder(body).Decode(obj)
+	case mediaYAML:
+		if err := yaml.NewDecoder(body).Decode(&generic); err != nil {
+			return err
+		}
+	case mediaMsgpack:
+		if err := msgpack.NewDecoder(body).Decode(&generic); err != nil {
+			return err
+		}
+	case mediaCSV:
+		record, err := readCSVRecord(body)
+		if err != nil {
+			return err
+		}
+		if generic, err = csvRecordValues(record, obj); err != nil {
+			return err
+		}
+	default:
+		return errUnsupportedMediaType
+	}
+
+	data, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// readCSVRecord reads a header row and one row of values, returning the non-empty
+// values by column name.
+func readCSVRecord(body io.Reader) (map[string]string, error) {
+	rows, err := csv.NewReader(body).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) != 2 {
+		return nil, errors.New("a CSV body must have a header row and one row of values")
+	}
+	record := map[string]string{}
+	for i, name := range rows[0] {
+		if rows[1][i] != "" {
+			record[strings.TrimSpace(name)] = rows[1][i]
+		}
+	}
+	return record, nil
+}
+
+// csvRecordValues types the CSV values by the fields of obj they go to: booleans and
+// numbers are parsed, everything else stays a string.
+func csvRecordValues(record map[string]string, obj any) (map[string]any, error) {
+	kinds := map[string]reflect.Kind{}
+	typ := reflect.TypeOf(obj).Elem()
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		ft := field.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		kinds[name] = ft.Kind()
+	}
+
+	values := map[string]any{}
+	for name, value := range record {
+		switch kinds[name] {
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be true or false", name)
+			}
+			values[name] = b
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an integer", name)
+			}
+			values[name] = n
+		default:
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+// csvTime formats an optional time for a CSV cell.
+func csvTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
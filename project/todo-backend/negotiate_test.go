@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNegotiateMediaType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", mediaJSON},
+		{"*/*", mediaJSON},
+		{"application/json", mediaJSON},
+		{"text/csv", mediaCSV},
+		{"text/*", mediaCSV},
+		{"application/x-yaml", mediaYAML},
+		{"application/vnd.msgpack", mediaMsgpack},
+		{"application/json;q=0.5, application/yaml", mediaYAML},
+		{"text/csv;q=0.9, */*;q=0.8", mediaCSV},
+		{"*/*;q=0.8, application/json;q=0", mediaYAML},
+		{"text/html", ""},
+		{"application/xml, text/html;q=0.9", ""},
+		{"application/json;q=0", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, negotiateMediaType(tt.accept, todoMediaTypes), tt.accept)
+	}
+}
+
+func negotiationRequest(router *gin.Engine, method, path, contentType, accept, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestContentNegotiation_Responses(t *testing.T) {
+	s := &TodoMgr{}
+	due := time.Date(2026, 10, 20, 17, 0, 0, 0, time.UTC)
+	todo, err := s.Create("alice", TodoInput{Description: "ship, then \"celebrate\"", DueAt: &due})
+	require.NoError(t, err)
+	router := setupRouter(s)
+
+	w := negotiationRequest(router, http.MethodGet, "/todos", "", "text/csv", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, todoCSVHeaderV1, rows[0])
+	assert.Equal(t, todo.UUID, rows[1][0])
+	assert.Equal(t, `ship, then "celebrate"`, rows[1][1])
+	assert.Equal(t, "2026-10-20T17:00:00Z", rows[1][5])
+
+	w = negotiationRequest(router, http.MethodGet, "/api/v2/todos", "", "text/csv", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	rows, err = csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, todoCSVHeaderV2, rows[0])
+	assert.Equal(t, "/api/v2/todos/"+todo.UUID, rows[1][len(rows[1])-1])
+
+	// YAML and MessagePack have the same fields as JSON
+	w = negotiationRequest(router, http.MethodGet, "/todos", "", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var fromJSON []map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fromJSON))
+
+	w = negotiationRequest(router, http.MethodGet, "/todos", "", "application/yaml", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mediaYAML, w.Header().Get("Content-Type"))
+	var fromYAML []map[string]any
+	require.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &fromYAML))
+	assert.Equal(t, fromJSON[0]["description"], fromYAML[0]["description"])
+	assert.Equal(t, fromJSON[0]["due_at"], fromYAML[0]["due_at"])
+	assert.ElementsMatch(t, keys(fromJSON[0]), keys(fromYAML[0]))
+
+	w = negotiationRequest(router, http.MethodGet, "/todos", "", "application/msgpack", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var fromMsgpack []map[string]any
+	require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &fromMsgpack))
+	assert.Equal(t, fromJSON[0]["uuid"], fromMsgpack[0]["uuid"])
+	assert.ElementsMatch(t, keys(fromJSON[0]), keys(fromMsgpack[0]))
+
+	// Single todos are negotiated too
+	w = negotiationRequest(router, http.MethodPatch, "/todos/"+todo.UUID, "application/json", "application/yaml", `{"done":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var patched map[string]any
+	require.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &patched))
+	assert.Equal(t, true, patched["done"])
+
+	w = negotiationRequest(router, http.MethodGet, "/todos", "", "text/html", "")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+	// Nothing is created for a response the client cannot take
+	w = negotiationRequest(router, http.MethodPost, "/todos", "application/json", "application/xml", `{"description":"lost"}`)
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Len(t, s.List(), 1)
+}
+
+func TestContentNegotiation_RequestBodies(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	yamlBody := "description: from yaml\ndue_at: \"2026-10-20T17:00:00Z\"\n"
+	w := negotiationRequest(router, http.MethodPost, "/todos", "application/yaml", "", yamlBody)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "from yaml", created.Description)
+	require.NotNil(t, created.DueAt)
+
+	msgpackBody, err := msgpack.Marshal(map[string]any{"description": "from msgpack"})
+	require.NoError(t, err)
+	w = negotiationRequest(router, http.MethodPost, "/todos", "application/msgpack", "application/msgpack", string(msgpackBody))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var fromMsgpack map[string]any
+	require.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &fromMsgpack))
+	assert.Equal(t, "from msgpack", fromMsgpack["description"])
+
+	w = negotiationRequest(router, http.MethodPost, "/todos", "text/csv", "", "description,due_at\n\"from csv, quoted\",\n")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var fromCSV Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fromCSV))
+	assert.Equal(t, "from csv, quoted", fromCSV.Description)
+	assert.Nil(t, fromCSV.DueAt)
+
+	// Empty cells leave the fields as they are
+	w = negotiationRequest(router, http.MethodPatch, "/todos/"+fromCSV.UUID, "text/csv", "", "description,done\n,true\n")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var patched Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &patched))
+	assert.True(t, patched.Done)
+	assert.Equal(t, "from csv, quoted", patched.Description)
+
+	w = negotiationRequest(router, http.MethodPatch, "/todos/"+fromCSV.UUID, "text/csv", "", "done\nmaybe\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = negotiationRequest(router, http.MethodPost, "/todos", "text/csv", "", "description\none\ntwo\n")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = negotiationRequest(router, http.MethodPost, "/todos", "application/xml", "", "<todo/>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Len(t, s.List(), 3)
+}
+
+func TestBindBody_UnsupportedMediaType(t *testing.T) {
+	// Without the validator of test mode in front, bindBody rejects the body itself
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte("<todo/>")))
+	c.Request.Header.Set("Content-Type", "application/xml")
+	var req struct {
+		Description string `json:"description"`
+	}
+	assert.False(t, bindBody(c, &req))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func keys(m map[string]any) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
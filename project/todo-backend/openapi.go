@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The OpenAPI document is hand-maintained next to the handlers and embedded into the binary,
+// so the served spec always matches the build that serves it.
+//
+//go:embed openapi.yaml
+var openAPISpecYAML []byte
+
+// docsPage renders the embedded spec with Swagger UI loaded from a CDN.
+// Keeping the UI assets out of the binary keeps the scratch image small.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>todo-backend API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+    };
+  </script>
+</body>
+</html>
+`
+
+// loadOpenAPISpec parses and validates the embedded OpenAPI document.
+func loadOpenAPISpec() (*openapi3.T, error) {
+	data, err := expandAPIVersions(openAPISpecYAML)
+	if err != nil {
+		return nil, err
+	}
+	loader := openapi3.NewLoader()
+	spec, err := loader.LoadFromData(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := spec.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// mustLoadOpenAPISpec is like loadOpenAPISpec but panics on error.
+// The spec is embedded at build time, so a broken spec is a programming error.
+func mustLoadOpenAPISpec() *openapi3.T {
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		panic("invalid embedded OpenAPI spec: " + err.Error())
+	}
+	return spec
+}
+
+// getOpenAPISpec serves the OpenAPI document as JSON.
+func getOpenAPISpec(spec *openapi3.T) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, spec)
+	}
+}
+
+// getDocs serves a Swagger UI page for the OpenAPI document.
+func getDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
+
+// kin-openapi knows how to decode JSON, YAML and CSV bodies, but not MessagePack or
+// the HTML of shared todos.
+func init() {
+	openapi3filter.RegisterBodyDecoder(mediaMsgpack, msgpackBodyDecoder)
+	openapi3filter.RegisterBodyDecoder(mediaHTML, openapi3filter.FileBodyDecoder)
+}
+
+// msgpackBodyDecoder decodes a MessagePack body into the values a JSON body would give.
+func msgpackBodyDecoder(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (any, error) {
+	var value any
+	if err := msgpack.NewDecoder(body).Decode(&value); err != nil {
+		return nil, &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, &openapi3filter.ParseError{Kind: openapi3filter.KindInvalidFormat, Cause: err}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// openAPIValidator returns a middleware that validates requests and responses against the spec.
+//
+// Requests that do not match are rejected with 400 before they reach the handler. Responses
+// that do not match are replaced with a 500, so a handler drifting from the documented shape
+// fails loudly in tests instead of silently breaking the TS client. Routes that are not in the
+// spec pass through unvalidated; TestOpenAPISpecCoversRoutes makes sure there are none.
+//
+// Responses are buffered in full, so this is meant for test mode, not production traffic.
+func openAPIValidator(spec *openapi3.T) gin.HandlerFunc {
+	router, err := legacy.NewRouter(spec)
+	if err != nil {
+		panic("cannot build OpenAPI router: " + err.Error())
+	}
+
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		requestInput := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), requestInput); err != nil {
+			abortWithProblem(c, requestValidationProblem(err))
+			return
+		}
+
+		writer := &bufferedResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if err := validateResponse(c.Request.Context(), requestInput, writer); err != nil {
+			log.Printf("Response to %s %s does not match the API specification: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.Writer.Header().Del("Content-Length")
+			abortWithProblem(c, NewProblem(http.StatusInternalServerError, "response does not match the API specification: "+err.Error()))
+			return
+		}
+
+		c.Writer.WriteHeader(writer.Status())
+		c.Writer.Write(writer.body.Bytes())
+	}
+}
+
+// requestValidationProblem converts a request validation error into a 400 problem,
+// pointing at the offending body field when the error comes from a schema.
+func requestValidationProblem(err error) *Problem {
+	// A body in a media type the operation does not take, like bindBody reports it, for
+	// the operations negotiating their request bodies
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) && strings.HasPrefix(requestErr.Reason, "header Content-Type has unexpected value") &&
+		requestErr.Input != nil && requestErr.Input.Route != nil &&
+		requestErr.Input.Route.Operation.Responses.Status(http.StatusUnsupportedMediaType) != nil {
+		return NewProblem(http.StatusUnsupportedMediaType, "request does not match the API specification: "+err.Error())
+	}
+
+	p := NewProblem(http.StatusBadRequest, "request does not match the API specification: "+err.Error())
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		field := strings.Join(schemaErr.JSONPointer(), ".")
+		if field == "" {
+			field = "body"
+		}
+		p.WithFieldError(field, schemaErr.Reason)
+	}
+	return p
+}
+
+func validateResponse(ctx context.Context, requestInput *openapi3filter.RequestValidationInput, w *bufferedResponseWriter) error {
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: requestInput,
+		Status:                 w.Status(),
+		Header:                 w.Header(),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	}
+	responseInput.SetBodyBytes(w.body.Bytes())
+	return openapi3filter.ValidateResponse(ctx, responseInput)
+}
+
+// bufferedResponseWriter holds back the status and body written by a handler
+// so they can be inspected before anything reaches the client.
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeaderNow() {}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedResponseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *bufferedResponseWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedResponseWriter) Written() bool {
+	return w.status != 0 || w.body.Len() > 0
+}
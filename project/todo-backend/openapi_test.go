@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Routes that intentionally are not described in openapi.yaml
+var undocumentedRoutes = map[string]bool{
+	"GET /openapi.json": true,
+	"GET /docs":         true,
+}
+
+func TestLoadOpenAPISpec(t *testing.T) {
+	spec, err := loadOpenAPISpec()
+	require.NoError(t, err)
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+}
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	spec := mustLoadOpenAPISpec()
+	router := setupRouter(&TodoMgr{})
+	tenants, err := NewTenantRegistry(t.TempDir(), nil, func(id, dir string) (*TodoMgr, error) { return &TodoMgr{}, nil })
+	require.NoError(t, err)
+
+	// Gin uses :param, OpenAPI uses {param}
+	ginParam := regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+	for _, route := range append(router.Routes(), newTenantRouter(tenants).Routes()...) {
+		if undocumentedRoutes[route.Method+" "+route.Path] {
+			continue
+		}
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		item := spec.Paths.Find(path)
+		if !assert.NotNil(t, item, "route %s %s is missing from openapi.yaml", route.Method, route.Path) {
+			continue
+		}
+		assert.NotNil(t, item.GetOperation(route.Method), "operation %s %s is missing from openapi.yaml", route.Method, route.Path)
+	}
+}
+
+func TestGetOpenAPISpec(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+	assert.Contains(t, doc["paths"], "/todos")
+}
+
+func TestGetDocs(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/docs", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, w.Body.String(), "/openapi.json")
+}
+
+func TestOpenAPIValidator_RejectsRequestNotMatchingSpec(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(`{"description": 42}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "API specification")
+	assert.Equal(t, 0, len(s.todosSorted))
+}
+
+func TestOpenAPIValidator_RejectsResponseNotMatchingSpec(t *testing.T) {
+	router := gin.New()
+	router.Use(openAPIValidator(mustLoadOpenAPISpec()))
+	// A handler that has drifted from the documented Todo shape
+	router.GET("/todos", func(c *gin.Context) {
+		c.JSON(http.StatusOK, []gin.H{{"id": 1, "text": "buy milk"}})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "response does not match")
+}
+
+func TestOpenAPIValidator_PassesMatchingResponse(t *testing.T) {
+	router := gin.New()
+	router.Use(openAPIValidator(mustLoadOpenAPISpec()))
+	router.GET("/todos", func(c *gin.Context) {
+		c.JSON(http.StatusOK, []Todo{{UUID: "abc", Description: "buy milk"}})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "buy milk")
+}
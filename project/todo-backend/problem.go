@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors are reported as RFC 7807 problem details (application/problem+json).
+// The same small model is used by every service in this repo; each service keeps
+// its own copy because the services are built as separate modules and images.
+
+const problemContentType = "application/problem+json"
+
+// ProblemTypeDefault is used when the HTTP status code says all there is to say.
+// Per RFC 7807 the title is then the standard status text.
+const ProblemTypeDefault = "about:blank"
+
+// FieldError describes a validation failure of a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// Reason is a machine-readable cause of a 503, e.g. storage-migration.
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewProblem returns a problem of the default type for the given status.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   ProblemTypeDefault,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WithFieldError appends a field-level validation error to the problem.
+func (p *Problem) WithFieldError(field, message string) *Problem {
+	p.Errors = append(p.Errors, FieldError{Field: field, Message: message})
+	return p
+}
+
+// abortWithProblem writes the problem as the response and stops the handler chain.
+// The request path is used as the instance if none was set.
+func abortWithProblem(c *gin.Context, p *Problem) {
+	if p.Instance == "" && c.Request != nil {
+		p.Instance = c.Request.URL.Path
+	}
+	// render.JSON only sets Content-Type if it is not set already
+	c.Header("Content-Type", problemContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+// abortWithValidationError is a shorthand for a 400 caused by a single invalid field.
+func abortWithValidationError(c *gin.Context, field, message string) {
+	validationFailures.WithLabelValues(field).Inc()
+	abortWithProblem(c, NewProblem(http.StatusBadRequest, field+" "+message).WithFieldError(field, message))
+}
+
+// abortWithError maps an error returned by TodoMgr to the matching problem.
+func abortWithError(c *gin.Context, err error) {
+	var validationErr *ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		abortWithValidationError(c, validationErr.Field, validationErr.Message)
+	case errors.Is(err, ErrTodoNotFound), errors.Is(err, ErrAttachmentNotFound), errors.Is(err, ErrCommentNotFound),
+		errors.Is(err, ErrTemplateNotFound), errors.Is(err, ErrJobNotFound), errors.Is(err, ErrBackupNotFound),
+		errors.Is(err, ErrBackupsDisabled), errors.Is(err, ErrTenantNotFound), errors.Is(err, ErrShareNotFound),
+		errors.Is(err, ErrShareLinkInvalid), errors.Is(err, ErrEncryptionDisabled):
+		abortWithProblem(c, NewProblem(http.StatusNotFound, err.Error()))
+	case errors.Is(err, ErrTransitionNotAllowed), errors.Is(err, ErrJobNotFailed), errors.Is(err, ErrTenantExists),
+		errors.Is(err, ErrTenantActive):
+		abortWithProblem(c, NewProblem(http.StatusConflict, err.Error()))
+	case errors.Is(err, ErrNotCommentAuthor), errors.Is(err, ErrNotShareCreator), errors.Is(err, ErrNotTemplateOwner):
+		abortWithProblem(c, NewProblem(http.StatusForbidden, err.Error()))
+	case errors.Is(err, ErrAttachmentTooLarge):
+		abortWithProblem(c, NewProblem(http.StatusRequestEntityTooLarge, err.Error()))
+	case errors.Is(err, ErrNoTenant):
+		abortWithProblem(c, NewProblem(http.StatusBadRequest, err.Error()))
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrTenantSuspended):
+		abortWithProblem(c, NewProblem(http.StatusForbidden, err.Error()))
+	case errors.Is(err, ErrInvalidBackup):
+		abortWithProblem(c, NewProblem(http.StatusUnprocessableEntity, err.Error()))
+	default:
+		abortWithInternalError(c, "internal server error", err)
+	}
+}
+
+// abortWithInternalError logs the cause of a 500 and answers with a generic problem, so
+// file paths and internal errors do not reach clients.
+func abortWithInternalError(c *gin.Context, detail string, err error) {
+	if c.Request != nil {
+		log.Printf("%s %s: %s: %v", c.Request.Method, c.Request.URL.Path, detail, err)
+	} else {
+		log.Printf("%s: %v", detail, err)
+	}
+	abortWithProblem(c, NewProblem(http.StatusInternalServerError, detail))
+}
+
+// noRoute replaces Gin's plain text 404 response.
+func noRoute(c *gin.Context) {
+	abortWithProblem(c, NewProblem(http.StatusNotFound, "no such route"))
+}
+
+// recovery turns a panicking handler into a 500 problem instead of an empty response.
+func recovery(c *gin.Context, err any) {
+	abortWithProblem(c, NewProblem(http.StatusInternalServerError, "internal server error"))
+}
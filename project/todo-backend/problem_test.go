@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) Problem {
+	t.Helper()
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	var p Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	return p
+}
+
+func TestNewProblem(t *testing.T) {
+	p := NewProblem(http.StatusNotFound, "todo not found")
+
+	assert.Equal(t, ProblemTypeDefault, p.Type)
+	assert.Equal(t, "Not Found", p.Title)
+	assert.Equal(t, http.StatusNotFound, p.Status)
+	assert.Equal(t, "todo not found", p.Detail)
+	assert.Empty(t, p.Errors)
+}
+
+func TestCreateTodo_ProblemForTooLongDescription(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	b, _ := json.Marshal(map[string]string{"description": strings.Repeat("a", TODOMAXLENGTTH+1)})
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	p := decodeProblem(t, w)
+	assert.Equal(t, http.StatusBadRequest, p.Status)
+	assert.Equal(t, "/todos", p.Instance)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, "description", p.Errors[0].Field)
+}
+
+func TestCreateTodo_ProblemForInvalidJSON(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(`notjson`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	p := decodeProblem(t, w)
+	assert.NotEmpty(t, p.Detail)
+}
+
+func TestPatchTodo_ProblemForUnknownTodo(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	req := httptest.NewRequest(http.MethodPatch, "/todos/nope", bytes.NewReader([]byte(`{"description":"x"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	p := decodeProblem(t, w)
+	assert.Equal(t, "/todos/nope", p.Instance)
+	assert.Equal(t, "todo not found", p.Detail)
+}
+
+func TestNoRoute_Problem(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nothing-here", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	decodeProblem(t, w)
+}
+
+func TestRecovery_Problem(t *testing.T) {
+	router := gin.New()
+	router.Use(gin.CustomRecovery(recovery))
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	p := decodeProblem(t, w)
+	assert.NotContains(t, p.Detail, "boom")
+}
+
+func TestOpenAPIValidator_ProblemPointsAtField(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(`{"description": 42}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	p := decodeProblem(t, w)
+	require.Len(t, p.Errors, 1)
+	assert.Equal(t, "description", p.Errors[0].Field)
+}
+
+func TestAbortWithError_HidesInternalErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/todos", nil)
+	abortWithError(c, errors.New("open /app/data/secret.json: permission denied"))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	p := decodeProblem(t, w)
+	assert.Equal(t, "internal server error", p.Detail)
+	assert.NotContains(t, w.Body.String(), "/app/data")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate limiting uses one token bucket per client and route. A bucket holds up to Burst
+// tokens and refills continuously over Period; each request takes one token. Each route
+// takes the limit of its class (reads, writes or creates) unless its own variable is set.
+// Responses carry RateLimit-* headers (IETF httpapi ratelimit-headers draft), and a
+// 429 also tells the client when to come back via Retry-After.
+//
+// Design choice: buckets live in memory per replica. With N replicas a client effectively
+// gets N times the limit. A shared store (Valkey / Redis) would be the follow-up.
+
+// identityHeader carries the user authenticated by the proxy in front of todo-backend
+// (e.g. oauth2-proxy behind a Traefik forward-auth middleware). todo-backend does no
+// authentication itself: the ingress strips this header from client requests, and
+// todo-backend drops it from requests that do not come from a trusted proxy.
+const identityHeader = "X-Forwarded-User"
+
+// trustedProxiesFromEnv returns the addresses in the comma separated TRUSTED_PROXIES, as
+// IPs or CIDRs. Only requests from these peers may carry identityHeader and X-Forwarded-For;
+// without TRUSTED_PROXIES no peer is trusted and every request is anonymous.
+func trustedProxiesFromEnv() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, value := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				log.Fatalf("Invalid TRUSTED_PROXIES entry %q", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies
+}
+
+// trustProxies makes r take the client IP from X-Forwarded-For and the headers from the
+// proxy only for requests from the proxies, and drops the headers from all other requests.
+func trustProxies(r *gin.Engine, proxies []netip.Prefix, headers ...string) {
+	cidrs := make([]string, len(proxies))
+	for i, prefix := range proxies {
+		cidrs[i] = prefix.String()
+	}
+	// Cannot fail, the prefixes are parsed already
+	_ = r.SetTrustedProxies(cidrs)
+
+	r.Use(func(c *gin.Context) {
+		if !fromTrustedProxy(c.Request.RemoteAddr, proxies) {
+			for _, header := range headers {
+				c.Request.Header.Del(header)
+			}
+		}
+		c.Next()
+	})
+}
+
+// fromTrustedProxy reports whether the peer at remoteAddr ("ip:port") is one of the proxies.
+func fromTrustedProxy(remoteAddr string, proxies []netip.Prefix) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit is the size of a token bucket: Burst requests, refilled over Period.
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%v", l.Burst, l.Period)
+}
+
+// Default limits per route class, overridable with RATE_LIMIT_READ, RATE_LIMIT_WRITE and
+// RATE_LIMIT_CREATE, and per route with the variables named by routeRateLimitEnv.
+var (
+	defaultCreateRateLimit = RateLimit{Burst: 20, Period: time.Minute}
+	defaultWriteRateLimit  = RateLimit{Burst: 60, Period: time.Minute}
+	defaultReadRateLimit   = RateLimit{Burst: 120, Period: time.Minute}
+)
+
+// parseRateLimit parses "<requests>/<duration>", e.g. "20/1m".
+func parseRateLimit(value string) (RateLimit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not of the form <requests>/<duration>", value)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || burst <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: requests must be a positive integer", value)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q: period must be a positive duration", value)
+	}
+	return RateLimit{Burst: burst, Period: d}, nil
+}
+
+// rateLimitFromEnv reads a limit from the environment variable, falling back to def.
+func rateLimitFromEnv(name string, def RateLimit) RateLimit {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	limit, err := parseRateLimit(value)
+	if err != nil {
+		log.Printf("Invalid %s: %v. Using %v", name, err, def)
+		return def
+	}
+	return limit
+}
+
+// routeRateLimitEnv names the variable holding the limit of a route, given as
+// "<method> <path>": "POST /todos/:uuid/comments" is RATE_LIMIT_POST_TODOS_UUID_COMMENTS.
+func routeRateLimitEnv(route string) string {
+	words := strings.FieldsFunc(strings.ToUpper(route), func(r rune) bool {
+		return (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+	})
+	return "RATE_LIMIT_" + strings.Join(words, "_")
+}
+
+// requestIdentity returns the authenticated user, or "" for anonymous requests.
+func requestIdentity(c *gin.Context) string {
+	return strings.TrimSpace(c.GetHeader(identityHeader))
+}
+
+// rateLimitKey identifies the client: the authenticated user if known, otherwise the client IP.
+func rateLimitKey(c *gin.Context) string {
+	if identity := requestIdentity(c); identity != "" {
+		return "user:" + identity
+	}
+	return "ip:" + c.ClientIP()
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// RateLimiter keeps the token buckets of one route.
+type RateLimiter struct {
+	mu          sync.Mutex
+	limit       RateLimit
+	buckets     map[string]*tokenBucket
+	lastPurgeAt time.Time
+	now         func() time.Time // replaceable in tests
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// rateLimitDecision is the outcome of taking a token, with what the headers need.
+type rateLimitDecision struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next token, when not allowed
+}
+
+// take tries to take a token from the client's bucket.
+func (l *RateLimiter) take(key string) rateLimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.purgeFull(now)
+
+	perSecond := float64(l.limit.Burst) / l.limit.Period.Seconds()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit.Burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*perSecond)
+	b.updatedAt = now
+
+	d := rateLimitDecision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = secondsToDuration((1 - b.tokens) / perSecond)
+	}
+	d.remaining = int(math.Floor(b.tokens))
+	d.reset = secondsToDuration((float64(l.limit.Burst) - b.tokens) / perSecond)
+	return d
+}
+
+// purgeFull forgets buckets that have refilled completely, at most once a minute.
+// A missing bucket behaves exactly like a full one.
+// Caller must hold l.mu.
+func (l *RateLimiter) purgeFull(now time.Time) {
+	if now.Sub(l.lastPurgeAt) < time.Minute {
+		return
+	}
+	l.lastPurgeAt = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updatedAt) >= l.limit.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ceilSeconds rounds up, so clients never come back too early.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimited returns a middleware enforcing the limiter's limit per client.
+func rateLimited(l *RateLimiter) gin.HandlerFunc {
+	policy := fmt.Sprintf("%d;w=%s", l.limit.Burst, ceilSeconds(l.limit.Period))
+
+	return func(c *gin.Context) {
+		d := l.take(rateLimitKey(c))
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(l.limit.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(d.remaining))
+		c.Header("RateLimit-Reset", ceilSeconds(d.reset))
+
+		if !d.allowed {
+			c.Header("Retry-After", ceilSeconds(d.retryAfter))
+			abortWithProblem(c, NewProblem(http.StatusTooManyRequests, "rate limit exceeded, retry after "+ceilSeconds(d.retryAfter)+" seconds"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// routeRateLimited returns a middleware giving every route it guards its own limiter, so
+// that e.g. posting comments does not use up the budget for creating todos. A route takes
+// the limit from its variable, see routeRateLimitEnv, or def. The API versions serve the
+// same routes, so they share the limiters.
+func routeRateLimited(def RateLimit) gin.HandlerFunc {
+	var mu sync.Mutex
+	limited := make(map[string]gin.HandlerFunc)
+
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + strings.TrimPrefix(c.FullPath(), requestAPIVersion(c).prefix)
+		mu.Lock()
+		h, ok := limited[route]
+		if !ok {
+			h = rateLimited(NewRateLimiter(rateLimitFromEnv(routeRateLimitEnv(route), def)))
+			limited[route] = h
+		}
+		mu.Unlock()
+		h(c)
+	}
+}
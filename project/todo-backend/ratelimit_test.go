@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := parseRateLimit("20/1m")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Burst: 20, Period: time.Minute}, limit)
+
+	for _, bad := range []string{"20", "x/1m", "0/1m", "20/never", "20/-1s"} {
+		_, err := parseRateLimit(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestRateLimitFromEnv(t *testing.T) {
+	def := RateLimit{Burst: 1, Period: time.Second}
+
+	t.Setenv("RATE_LIMIT_TEST", "")
+	assert.Equal(t, def, rateLimitFromEnv("RATE_LIMIT_TEST", def))
+
+	t.Setenv("RATE_LIMIT_TEST", "5/10s")
+	assert.Equal(t, RateLimit{Burst: 5, Period: 10 * time.Second}, rateLimitFromEnv("RATE_LIMIT_TEST", def))
+
+	t.Setenv("RATE_LIMIT_TEST", "garbage")
+	assert.Equal(t, def, rateLimitFromEnv("RATE_LIMIT_TEST", def))
+}
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	l := NewRateLimiter(RateLimit{Burst: 2, Period: 10 * time.Second})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	d := l.take("a")
+	assert.True(t, d.allowed)
+	assert.Equal(t, 1, d.remaining)
+
+	d = l.take("a")
+	assert.True(t, d.allowed)
+	assert.Equal(t, 0, d.remaining)
+
+	// Bucket empty: one token comes back every 5 seconds
+	d = l.take("a")
+	assert.False(t, d.allowed)
+	assert.Equal(t, 5*time.Second, d.retryAfter)
+
+	// Other clients have their own bucket
+	assert.True(t, l.take("b").allowed)
+
+	now = now.Add(5 * time.Second)
+	assert.True(t, l.take("a").allowed)
+	assert.False(t, l.take("a").allowed)
+}
+
+func TestRateLimited_Returns429WithHeaders(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CREATE", "2/1m")
+	router := setupRouter(&TodoMgr{})
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(`{"description":"spam `+strconv.Itoa(i)+`"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+
+	// Reads have a separate budget
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRateLimited_PerRoute(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CREATE", "1/1m")
+	t.Setenv("RATE_LIMIT_POST_TODOS_UUID_COMMENTS", "2/1m")
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("alice", TodoInput{Description: "discuss me"})
+	require.NoError(t, err)
+
+	// Comments have their own budget, with the limit of their own variable
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusCreated, apiRequest(router, http.MethodPost, "/todos/"+todo.UUID+"/comments", "alice", `{"body":"hi"}`).Code)
+	}
+	w := apiRequest(router, http.MethodPost, "/api/v2/todos/"+todo.UUID+"/comments", "alice", `{"body":"hi"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+
+	// and leave the one of creating todos, which the API versions share, alone
+	assert.Equal(t, http.StatusCreated, apiRequest(router, http.MethodPost, "/api/v1/todos", "alice", `{"description":"still"}`).Code)
+	assert.Equal(t, http.StatusTooManyRequests, apiRequest(router, http.MethodPost, "/todos", "alice", `{"description":"spam"}`).Code)
+}
+
+func TestRouteRateLimitEnv(t *testing.T) {
+	assert.Equal(t, "RATE_LIMIT_POST_TODOS_UUID_COMMENTS", routeRateLimitEnv("POST /todos/:uuid/comments"))
+	assert.Equal(t, "RATE_LIMIT_GET_SHARED_TOKEN", routeRateLimitEnv("GET /shared/:token"))
+}
+
+func TestRateLimited_KeyedByIdentity(t *testing.T) {
+	t.Setenv("RATE_LIMIT_READ", "1/1m")
+	router := setupRouter(&TodoMgr{})
+
+	get := func(user string) int {
+		req := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		if user != "" {
+			req.Header.Set(identityHeader, user)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Same client IP, but different authenticated users get separate buckets
+	assert.Equal(t, http.StatusOK, get("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, get("alice"))
+	assert.Equal(t, http.StatusOK, get("bob"))
+	assert.Equal(t, http.StatusOK, get(""))
+	assert.Equal(t, http.StatusTooManyRequests, get(""))
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("RATE_LIMIT_READ", "1/1m")
+	t.Setenv("ADMIN_USERS", "root")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1, 10.1.0.0/16")
+	router := setupRouter(&TodoMgr{})
+
+	get := func(path, remoteAddr string, headers ...string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Only the proxies can name the user
+	assert.Equal(t, http.StatusOK, get("/admin/maintenance", "10.1.2.3:4000", identityHeader, "root"))
+	assert.Equal(t, http.StatusForbidden, get("/admin/maintenance", "203.0.113.7:4000", identityHeader, "root"))
+
+	// Clients cannot pick a fresh bucket with X-Forwarded-For or X-Forwarded-User
+	assert.Equal(t, http.StatusOK, get("/todos", "203.0.113.7:4000"))
+	assert.Equal(t, http.StatusTooManyRequests, get("/todos", "203.0.113.7:4000", "X-Forwarded-For", "198.51.100.1"))
+	assert.Equal(t, http.StatusTooManyRequests, get("/todos", "203.0.113.7:4000", identityHeader, "mallory"))
+
+	// Behind the proxy the client IP comes from X-Forwarded-For
+	assert.Equal(t, http.StatusOK, get("/todos", "10.0.0.1:4000", "X-Forwarded-For", "198.51.100.1"))
+	assert.Equal(t, http.StatusOK, get("/todos", "10.0.0.1:4000", "X-Forwarded-For", "198.51.100.2"))
+	assert.Equal(t, http.StatusTooManyRequests, get("/todos", "10.0.0.1:4000", "X-Forwarded-For", "198.51.100.2"))
+}
+
+func TestTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	assert.Empty(t, trustedProxiesFromEnv())
+
+	t.Setenv("TRUSTED_PROXIES", " 10.42.0.0/16 ,fd00::1, 192.168.1.7/24")
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.42.0.0/16"),
+		netip.MustParsePrefix("fd00::1/128"),
+		netip.MustParsePrefix("192.168.1.0/24"),
+	}, trustedProxiesFromEnv())
+
+	assert.False(t, fromTrustedProxy("192.168.1.9:80", nil))
+	assert.True(t, fromTrustedProxy("[::ffff:192.168.1.9]:80", trustedProxiesFromEnv()))
+	assert.False(t, fromTrustedProxy("bufconn", trustedProxiesFromEnv()))
+}
+
+func TestCreateTodo_QuotaPerOwner(t *testing.T) {
+	s := &TodoMgr{MaxTodosPerOwner: 1}
+	router := setupRouter(s)
+
+	post := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/todos", bytes.NewReader([]byte(`{"description":"mine"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(identityHeader, user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("alice")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"owner":"alice"`)
+
+	w = post("alice")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusCreated, post("bob").Code)
+	assert.Equal(t, 2, len(s.todosSorted))
+}
+
+func TestMaxTodosPerOwnerFromEnv(t *testing.T) {
+	t.Setenv("MAX_TODOS_PER_OWNER", "")
+	assert.Equal(t, 500, maxTodosPerOwnerFromEnv())
+
+	t.Setenv("MAX_TODOS_PER_OWNER", "0")
+	assert.Equal(t, 0, maxTodosPerOwnerFromEnv())
+}
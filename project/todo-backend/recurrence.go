@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/teambition/rrule-go"
+)
+
+// Recurring todos follow an RFC 5545 RRULE such as "FREQ=WEEKLY;BYDAY=MO". The series
+// starts at RecurrenceStart (the DTSTART of the rule), which is the due date given when the
+// rule was set, or the time it was set if there was none. Only the open occurrence exists
+// as a todo; completing it creates the next one, due at the first instance of the rule
+// after the completed todo's due date. All times are in UTC.
+
+// maxOccurrences caps how many instances GET /todos/:uuid/occurrences expands.
+const maxOccurrences = 500
+
+// maxOccurrencesAhead bounds how far in the future the expansion may start. The rule is
+// iterated from its series start, so a far away start would cost an iteration per instance
+// up to it.
+const maxOccurrencesAhead = 366 * 24 * time.Hour
+
+// maxRecurrenceAge bounds how far in the past a series may start when its rule is set, for
+// the same reason: every expansion iterates the instances since the series start.
+const maxRecurrenceAge = 366 * 24 * time.Hour
+
+// maxOccurrenceSteps caps how many instances of the rule the expansion walks through,
+// counting those before the requested window. At the hourly limit of parseRRule it covers
+// more than ten years of a series.
+const maxOccurrenceSteps = 100000
+
+// parseRRule validates the rule and returns it in canonical form, without the optional
+// "RRULE:" prefix. DTSTART is not accepted; the series start comes from the todo.
+func parseRRule(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "RRULE:"), "rrule:")
+
+	if strings.ContainsAny(value, "\r\n") {
+		return "", &ValidationError{Field: "rrule", Message: "must be a single RRULE without DTSTART"}
+	}
+	opt, err := rrule.StrToROption(value)
+	if err != nil {
+		return "", &ValidationError{Field: "rrule", Message: "is not a valid RRULE: " + err.Error()}
+	}
+	// A todo repeating every minute is a mistake, and those rules are expensive to expand.
+	// Any other frequency gives at most one instance an hour unless BYMINUTE or BYSECOND
+	// list several values.
+	if opt.Freq == rrule.SECONDLY || opt.Freq == rrule.MINUTELY || len(opt.Byminute) > 1 || len(opt.Bysecond) > 1 {
+		return "", &ValidationError{Field: "rrule", Message: "must not repeat more often than hourly"}
+	}
+	if _, err := rrule.NewRRule(*opt); err != nil {
+		return "", &ValidationError{Field: "rrule", Message: "is not a valid RRULE: " + err.Error()}
+	}
+	return opt.RRuleString(), nil
+}
+
+// recurrenceRule returns the rule of a recurring todo, anchored at its series start.
+func recurrenceRule(t Todo) (*rrule.RRule, error) {
+	opt, err := rrule.StrToROption(t.RRule)
+	if err != nil {
+		return nil, err
+	}
+	opt.Dtstart = t.RecurrenceStart.Truncate(time.Second)
+	return rrule.NewRRule(*opt)
+}
+
+// setRecurrence makes t recur by the rule, starting a new series at its due date (or now).
+// The due date moves to the first instance of the rule, since the start need not be one.
+func setRecurrence(t *Todo, value string, now time.Time) error {
+	canonical, err := parseRRule(value)
+	if err != nil {
+		return err
+	}
+
+	start := now.Truncate(time.Second)
+	if t.DueAt != nil {
+		start = t.DueAt.Truncate(time.Second)
+	}
+	if start.Before(now.Add(-maxRecurrenceAge)) {
+		return &ValidationError{Field: "due_at", Message: "must be at most a year in the past for a recurring todo"}
+	}
+	candidate := *t
+	candidate.RRule = canonical
+	candidate.RecurrenceStart = &start
+
+	r, err := recurrenceRule(candidate)
+	if err != nil {
+		return &ValidationError{Field: "rrule", Message: "is not a valid RRULE: " + err.Error()}
+	}
+	first := r.After(start, true)
+	if first.IsZero() {
+		return &ValidationError{Field: "rrule", Message: "has no occurrences"}
+	}
+	candidate.DueAt = &first
+	// A new rule starts a new series
+	candidate.NextUUID = ""
+
+	*t = candidate
+	return nil
+}
+
+// nextOccurrence returns the todo following the completed recurring todo t, starting
+// in the given workflow state, or nil if the series has ended.
+func nextOccurrence(t *Todo, state string, now time.Time) *Todo {
+	r, err := recurrenceRule(*t)
+	if err != nil {
+		// The rule was validated when it was set
+		return nil
+	}
+	after := *t.RecurrenceStart
+	if t.DueAt != nil {
+		after = *t.DueAt
+	}
+	due := r.After(after, false)
+	if due.IsZero() {
+		return nil
+	}
+
+	return &Todo{
+		UUID:            uuid.New().String(),
+		Description:     t.Description,
+		Owner:           t.Owner,
+		State:           state,
+		DueAt:           &due,
+		CreatedAt:       now,
+		ChangedAt:       now,
+		RRule:           t.RRule,
+		RecurrenceStart: t.RecurrenceStart,
+		Links:           t.Links,
+	}
+}
+
+// Occurrences lists the upcoming due dates of a todo within [from, to]: the open occurrence
+// and the ones completing it would generate. The list is cut at maxOccurrences, or once
+// maxOccurrenceSteps instances have been walked through, in which case truncated is true. A
+// todo without a rule has at most its own due date. from must be within maxOccurrencesAhead
+// from now.
+func (s *TodoMgr) Occurrences(UUID string, from, to time.Time) (occurrences []time.Time, truncated bool, err error) {
+	if from.After(time.Now().Add(maxOccurrencesAhead)) {
+		return nil, false, &ValidationError{Field: "from", Message: "must be at most a year from now"}
+	}
+	t, err := s.Get(UUID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	occurrences = []time.Time{}
+	if t.RRule == "" || t.DueAt == nil {
+		if t.DueAt != nil && !t.DueAt.Before(from) && !t.DueAt.After(to) {
+			occurrences = append(occurrences, *t.DueAt)
+		}
+		return occurrences, false, nil
+	}
+
+	r, err := recurrenceRule(t)
+	if err != nil {
+		return nil, false, err
+	}
+	// Instances before the open occurrence have already been completed
+	if from.Before(*t.DueAt) {
+		from = *t.DueAt
+	}
+
+	next := r.Iterator()
+	for steps := 0; ; steps++ {
+		due, ok := next()
+		if !ok || due.After(to) {
+			return occurrences, false, nil
+		}
+		if len(occurrences) == maxOccurrences || steps == maxOccurrenceSteps {
+			return occurrences, true, nil
+		}
+		if due.Before(from) {
+			continue
+		}
+		occurrences = append(occurrences, due)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func patchTodo(router *gin.Engine, UUID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/todos/"+UUID, bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func decodeTodo(t *testing.T, w *httptest.ResponseRecorder) Todo {
+	t.Helper()
+	var todo Todo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &todo))
+	return todo
+}
+
+// upcomingMonday returns 09:00 UTC on the next Monday, so that the series of the tests start
+// within maxRecurrenceAge of the clock.
+func upcomingMonday() time.Time {
+	d := time.Now().UTC().Truncate(24 * time.Hour).Add(9 * time.Hour)
+	for d.Weekday() != time.Monday || d.Before(time.Now()) {
+		d = d.AddDate(0, 0, 1)
+	}
+	return d
+}
+
+func TestParseRRule(t *testing.T) {
+	rule, err := parseRRule("RRULE:FREQ=WEEKLY;BYDAY=MO")
+	require.NoError(t, err)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO", rule)
+
+	for _, bad := range []string{
+		"FREQ=FORTNIGHTLY",
+		"BYDAY=MO",
+		"FREQ=MINUTELY",
+		"FREQ=HOURLY;BYMINUTE=0,30",
+		"FREQ=DAILY;BYHOUR=9;BYSECOND=0,1",
+		"DTSTART:20261005T090000Z\nRRULE:FREQ=DAILY",
+	} {
+		_, err := parseRRule(bad)
+		var validationErr *ValidationError
+		assert.ErrorAs(t, err, &validationErr, bad)
+	}
+}
+
+func TestCreateTodo_RecurringDueDateMovesToFirstInstance(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	// The first Monday after a Wednesday is five days later
+	monday := upcomingMonday()
+	wednesday := monday.AddDate(0, 0, -5)
+	w := postTodo(router, "", `{"description":"check backups","due_at":"`+wednesday.Format(time.RFC3339)+`","rrule":"FREQ=WEEKLY;BYDAY=MO"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	todo := decodeTodo(t, w)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO", todo.RRule)
+	assert.Equal(t, monday, *todo.DueAt)
+	assert.Equal(t, wednesday, *todo.RecurrenceStart)
+	assert.False(t, todo.Done)
+
+	w = postTodo(router, "", `{"description":"never","rrule":"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "rrule", decodeProblem(t, w).Errors[0].Field)
+}
+
+func TestPatchTodo_CompletingRecurringTodoCreatesNextOccurrence(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	monday := upcomingMonday()
+	w := postTodo(router, "", `{"description":"ops checklist","due_at":"`+monday.Format(time.RFC3339)+`","rrule":"FREQ=WEEKLY;COUNT=2"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	first := decodeTodo(t, w)
+
+	w = patchTodo(router, first.UUID, `{"done":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	completed := decodeTodo(t, w)
+	assert.True(t, completed.Done)
+	assert.NotNil(t, completed.CompletedAt)
+	require.NotEmpty(t, completed.NextUUID)
+
+	next, err := s.Get(completed.NextUUID)
+	require.NoError(t, err)
+	assert.Equal(t, "ops checklist", next.Description)
+	assert.Equal(t, monday.AddDate(0, 0, 7), *next.DueAt)
+	assert.False(t, next.Done)
+
+	// Reopening and completing again does not generate a duplicate
+	require.Equal(t, http.StatusOK, patchTodo(router, first.UUID, `{"done":false}`).Code)
+	require.Equal(t, http.StatusOK, patchTodo(router, first.UUID, `{"done":true}`).Code)
+	assert.Equal(t, 2, len(s.todosSorted))
+
+	// COUNT=2: the series ends with the second occurrence
+	w = patchTodo(router, next.UUID, `{"done":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, decodeTodo(t, w).NextUUID)
+	assert.Equal(t, 2, len(s.todosSorted))
+}
+
+func TestPatchTodo_EmptyPatchIsRejected(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("", TodoInput{Description: "x"})
+	require.NoError(t, err)
+
+	w := patchTodo(router, todo.UUID, `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetOccurrences(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	monday := upcomingMonday()
+	w := postTodo(router, "", `{"description":"ops checklist","due_at":"`+monday.Format(time.RFC3339)+`","rrule":"FREQ=WEEKLY"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	todo := decodeTodo(t, w)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/occurrences"+query, nil))
+		return w
+	}
+
+	from := monday.AddDate(0, 0, -4)
+	w = get("?from=" + from.Format(time.RFC3339) + "&to=" + monday.AddDate(0, 0, 21).Format(time.RFC3339))
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp struct {
+		Occurrences []time.Time `json:"occurrences"`
+		Truncated   bool        `json:"truncated"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Occurrences, 4)
+	assert.Equal(t, monday, resp.Occurrences[0])
+	assert.Equal(t, monday.AddDate(0, 0, 21), resp.Occurrences[3])
+	assert.False(t, resp.Truncated)
+
+	// Instances before the open occurrence are not listed once it has moved on
+	w = patchTodo(router, todo.UUID, `{"done":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	occurrences, _, err := s.Occurrences(decodeTodo(t, w).NextUUID, from, monday.AddDate(0, 0, 26))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{
+		monday.AddDate(0, 0, 7),
+		monday.AddDate(0, 0, 14),
+		monday.AddDate(0, 0, 21),
+	}, occurrences)
+
+	// A long range is truncated
+	w = get("?from=" + from.Format(time.RFC3339) + "&to=" + from.AddDate(20, 0, 0).Format(time.RFC3339))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Occurrences, maxOccurrences)
+	assert.True(t, resp.Truncated)
+
+	assert.Equal(t, http.StatusBadRequest, get("?from="+from.Format(time.RFC3339)+"&to="+from.AddDate(0, -1, 0).Format(time.RFC3339)).Code)
+	assert.Equal(t, http.StatusBadRequest, get("?from=tomorrow").Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/nope/occurrences", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestOccurrences_FarFutureFrom(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "hourly", RRule: "FREQ=HOURLY"})
+	require.NoError(t, err)
+	router := setupRouter(s)
+
+	// Would iterate every hour until the year 9000
+	start := time.Now()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todos/"+todo.UUID+"/occurrences?from=9000-01-01T00:00:00Z", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"from"`)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, _, err = s.Occurrences(todo.UUID, time.Now().AddDate(2, 0, 0), time.Now().AddDate(3, 0, 0))
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+
+	occurrences, truncated, err := s.Occurrences(todo.UUID, time.Now().AddDate(0, 11, 0), time.Now().AddDate(1, 0, 0))
+	require.NoError(t, err)
+	assert.Len(t, occurrences, maxOccurrences)
+	assert.True(t, truncated)
+}
+
+func TestCreateTodo_RecurringSeriesStartingLongAgo(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	// Every expansion would iterate each hour since 1900
+	w := postTodo(router, "", `{"description":"hourly","due_at":"1900-01-01T00:00:00Z","rrule":"FREQ=HOURLY"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "due_at", decodeProblem(t, w).Errors[0].Field)
+
+	old := time.Date(1000, 1, 1, 0, 0, 0, 0, time.UTC)
+	todo, err := s.Create("", TodoInput{Description: "hourly", DueAt: &old})
+	require.NoError(t, err)
+	rule := "FREQ=HOURLY"
+	_, err = s.Update("", todo.UUID, TodoPatch{RRule: &rule})
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "due_at", validationErr.Field)
+
+	// A series started most of a year ago still answers a one-day window quickly
+	start := time.Now().UTC().Add(-maxRecurrenceAge + time.Hour).Truncate(time.Hour)
+	todo, err = s.Create("", TodoInput{Description: "hourly", DueAt: &start, RRule: rule})
+	require.NoError(t, err)
+	from := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	began := time.Now()
+	occurrences, truncated, err := s.Occurrences(todo.UUID, from, from.Add(23*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, occurrences, 24)
+	assert.False(t, truncated)
+	assert.Less(t, time.Since(began), time.Second)
+}
+
+func TestOccurrences_DenseRuleIsRejected(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	// 86,400 instances a day on an hourly rule
+	minutes, seconds := make([]string, 60), make([]string, 60)
+	for i := range minutes {
+		minutes[i] = strconv.Itoa(i)
+		seconds[i] = strconv.Itoa(i)
+	}
+	rule := "FREQ=HOURLY;BYMINUTE=" + strings.Join(minutes, ",") + ";BYSECOND=" + strings.Join(seconds, ",")
+	w := postTodo(router, "", `{"description":"tick","rrule":"`+rule+`"}`)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "rrule", decodeProblem(t, w).Errors[0].Field)
+}
+
+func TestOccurrences_StepsAreCapped(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "hourly", RRule: "FREQ=HOURLY"})
+	require.NoError(t, err)
+
+	// A series that has been running for twenty years
+	old := time.Now().UTC().AddDate(-20, 0, 0).Truncate(time.Hour)
+	s.todosSorted[0].RecurrenceStart = &old
+	s.todosSorted[0].DueAt = &old
+
+	start := time.Now()
+	occurrences, truncated, err := s.Occurrences(todo.UUID, time.Now(), time.Now().AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Empty(t, occurrences)
+	assert.True(t, truncated)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestOccurrences_NonRecurringTodo(t *testing.T) {
+	s := &TodoMgr{}
+	due := time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)
+	todo, err := s.Create("", TodoInput{Description: "once", DueAt: &due})
+	require.NoError(t, err)
+
+	occurrences, _, err := s.Occurrences(todo.UUID, due.AddDate(0, 0, -1), due.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{due}, occurrences)
+
+	occurrences, _, err = s.Occurrences(todo.UUID, due.AddDate(0, 0, 1), due.AddDate(0, 0, 2))
+	require.NoError(t, err)
+	assert.Empty(t, occurrences)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"html/template"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Shares give people outside the team a read-only view of the todos through a link,
+// without an account. A share is scoped to the whole list or to a tag, a #word in the
+// descriptions such as #release. POST /shares mints a share and returns its token once;
+// GET /shared/:token then serves the todos in its scope as JSON, or as a simple HTML page
+// to browsers, and every other method on it is rejected. Shares can expire, and their
+// creator or an admin can revoke them.
+//
+// Design choice: only the SHA-256 of a token is kept, so listing the shares does not
+// reveal working links. Like the todos, shares are kept in memory and are not part of
+// the backups.
+
+// Scopes of shares.
+const (
+	ShareScopeList = "list" // all todos
+	ShareScopeTag  = "tag"  // the todos tagged with the tag of the share
+)
+
+const mediaHTML = "text/html"
+
+// ErrShareNotFound is returned when no share has the given id.
+var ErrShareNotFound = errors.New("share not found")
+
+// ErrShareLinkInvalid is returned for unknown, revoked and expired tokens alike.
+var ErrShareLinkInvalid = errors.New("the share link does not exist, was revoked or has expired")
+
+// ErrNotShareCreator is returned when someone else than the creator or an admin revokes
+// a share.
+var ErrNotShareCreator = errors.New("only the creator of a share or an admin can revoke it")
+
+// tagPattern matches the tags of a description: words after a # at the start or after
+// a space, so URLs with fragments are not tags.
+var tagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+
+// validTag matches a tag without its #.
+var validTag = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// Share is a link to a read-only view of the todos. The token itself is only returned
+// when the share is created.
+type Share struct {
+	ID        string     `json:"id"`
+	Scope     string     `json:"scope"`
+	Tag       string     `json:"tag,omitempty"` // for the tag scope, lowercase and without the #
+	Owner     string     `json:"owner,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	tokenHash [sha256.Size]byte
+}
+
+// ShareInput holds the fields of a new share.
+type ShareInput struct {
+	Scope     string     `json:"scope"`
+	Tag       string     `json:"tag"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// NewShare is a created share with its token and the path serving it.
+type NewShare struct {
+	Share
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+// SharedTodo is a todo as shares show it, without the owner and the other details
+// meant for the team.
+type SharedTodo struct {
+	UUID        string     `json:"uuid"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	Done        bool       `json:"done"`
+	Overdue     bool       `json:"overdue"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// SharedTodos is the response of GET /shared/:token.
+type SharedTodos struct {
+	Scope     string       `json:"scope"`
+	Tag       string       `json:"tag,omitempty"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+	Todos     []SharedTodo `json:"todos"`
+}
+
+// tagsOf returns the tags in a description, lowercase and without the #.
+func tagsOf(description string) []string {
+	var tags []string
+	for _, m := range tagPattern.FindAllStringSubmatch(description, -1) {
+		tags = append(tags, strings.ToLower(m[1]))
+	}
+	return tags
+}
+
+// normalizeShare checks the input and returns it with the tag normalized.
+func normalizeShare(in ShareInput, now time.Time) (ShareInput, error) {
+	in.Tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(in.Tag), "#"))
+	switch in.Scope {
+	case ShareScopeList:
+		if in.Tag != "" {
+			return in, &ValidationError{Field: "tag", Message: "is only allowed with the tag scope"}
+		}
+	case ShareScopeTag:
+		if in.Tag == "" {
+			return in, &ValidationError{Field: "tag", Message: "is required"}
+		}
+		if !validTag.MatchString(in.Tag) {
+			return in, &ValidationError{Field: "tag", Message: "must consist of letters, digits, _ and -"}
+		}
+	case "":
+		return in, &ValidationError{Field: "scope", Message: "is required"}
+	default:
+		return in, &ValidationError{Field: "scope", Message: "must be list or tag"}
+	}
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(now) {
+		return in, &ValidationError{Field: "expires_at", Message: "must be in the future"}
+	}
+	in.ExpiresAt = utcPtr(in.ExpiresAt)
+	return in, nil
+}
+
+// active reports whether the share's link works at the instant.
+func (sh *Share) active(now time.Time) bool {
+	return sh.RevokedAt == nil && (sh.ExpiresAt == nil || now.Before(*sh.ExpiresAt))
+}
+
+// includes reports whether the todo is in the scope of the share.
+func (sh *Share) includes(t Todo) bool {
+	return sh.Scope == ShareScopeList || slices.Contains(tagsOf(t.Description), sh.Tag)
+}
+
+// Shares returns all shares in creation order, including revoked and expired ones.
+func (s *TodoMgr) Shares() []Share {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Share, len(s.shares))
+	copy(out, s.shares)
+	return out
+}
+
+// CreateShare validates the input and mints a share with a new token.
+func (s *TodoMgr) CreateShare(owner string, in ShareInput) (NewShare, error) {
+	now := time.Now().UTC()
+	in, err := normalizeShare(in, now)
+	if err != nil {
+		return NewShare{}, err
+	}
+
+	token := rand.Text()
+	sh := Share{
+		ID:        uuid.New().String(),
+		Scope:     in.Scope,
+		Tag:       in.Tag,
+		Owner:     owner,
+		CreatedAt: now,
+		ExpiresAt: in.ExpiresAt,
+		tokenHash: sha256.Sum256([]byte(token)),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shares = append(s.shares, sh)
+	return NewShare{Share: sh, Token: token, Path: "/shared/" + token}, nil
+}
+
+// RevokeShare ends a share, which only its creator or an admin may do. Revoking a
+// revoked share changes nothing.
+func (s *TodoMgr) RevokeShare(id, actor string, admin bool) (Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.shares {
+		sh := &s.shares[i]
+		if sh.ID != id {
+			continue
+		}
+		if sh.Owner != actor && !admin {
+			return Share{}, ErrNotShareCreator
+		}
+		if sh.RevokedAt == nil {
+			now := time.Now().UTC()
+			sh.RevokedAt = &now
+		}
+		return *sh, nil
+	}
+	return Share{}, ErrShareNotFound
+}
+
+// SharedTodos returns the todos in the scope of the share with the token, in creation
+// order.
+func (s *TodoMgr) SharedTodos(token string) (SharedTodos, error) {
+	hash := sha256.Sum256([]byte(token))
+	now := time.Now().UTC()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := slices.IndexFunc(s.shares, func(sh Share) bool { return sh.tokenHash == hash })
+	if i < 0 || !s.shares[i].active(now) {
+		return SharedTodos{}, ErrShareLinkInvalid
+	}
+	sh := &s.shares[i]
+	out := SharedTodos{Scope: sh.Scope, Tag: sh.Tag, ExpiresAt: sh.ExpiresAt, Todos: []SharedTodo{}}
+	for _, t := range s.todosSorted {
+		if !sh.includes(t) {
+			continue
+		}
+		out.Todos = append(out.Todos, SharedTodo{
+			UUID:        t.UUID,
+			Description: t.Description,
+			State:       t.State,
+			Done:        t.Done,
+			Overdue:     isOverdue(t, now),
+			DueAt:       t.DueAt,
+			CompletedAt: t.CompletedAt,
+		})
+	}
+	return out, nil
+}
+
+// sharedRoutes registers the public routes of the shares, serving the todos of the
+// token from lookup.
+func sharedRoutes(g gin.IRoutes, limited gin.HandlerFunc, lookup func(token string) (SharedTodos, error)) {
+	g.GET("/shared/:token", limited, negotiated(mediaJSON, mediaHTML), getShared(lookup))
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		g.Handle(method, "/shared/:token", rejectSharedMutation)
+	}
+}
+
+// getShares returns the handler listing the shares of the caller, or all of them for the
+// admins.
+// @success 200 {array} Share
+func (s *TodoMgr) getShares(admins map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := requestIdentity(c)
+		shares := s.Shares()
+		if !admins[actor] {
+			shares = slices.DeleteFunc(shares, func(sh Share) bool { return sh.Owner != actor })
+		}
+		c.JSON(http.StatusOK, shares)
+	}
+}
+
+// createShare mints a share.
+// @param scope body string true "list or tag"
+// @param tag body string false "Tag of the todos to share, for the tag scope"
+// @param expires_at body string false "Expiry (RFC 3339)"
+// @success 201 {object} NewShare
+// @failure 400 {object} Problem
+func (s *TodoMgr) createShare(c *gin.Context) {
+	var req ShareInput
+	if !bindBody(c, &req) {
+		return
+	}
+
+	share, err := s.CreateShare(requestIdentity(c), req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, share)
+}
+
+// revokeShare returns the handler revoking a share, for its creator or the admins.
+// @param id path string true "ID of the share"
+// @success 200 {object} Share
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) revokeShare(admins map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := requestIdentity(c)
+		share, err := s.RevokeShare(c.Param("id"), actor, admins[actor])
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, share)
+	}
+}
+
+// sharedPage renders SharedTodos for browsers.
+var sharedPage = template.Must(template.New("shared").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>{{.Title}}</title>
+  <style>
+    body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+    li { margin: 0.4em 0; }
+    small { color: #666; }
+    .done { text-decoration: line-through; color: #666; }
+    .overdue small { color: #b00; }
+  </style>
+</head>
+<body>
+  <h1>{{.Title}}</h1>
+  {{- if .Todos}}
+  <ul>
+    {{- range .Todos}}
+    <li{{if .Done}} class="done"{{else if .Overdue}} class="overdue"{{end}}>{{.Description}}
+      <small>{{.State}}{{with .DueAt}}, due {{.Format "2006-01-02 15:04 MST"}}{{end}}</small></li>
+    {{- end}}
+  </ul>
+  {{- else}}
+  <p>Nothing here yet.</p>
+  {{- end}}
+  {{- with .ExpiresAt}}
+  <p><small>This link expires on {{.Format "2006-01-02 15:04 MST"}}.</small></p>
+  {{- end}}
+</body>
+</html>
+`))
+
+// getShared returns the public handler serving the todos of a share.
+// @param token path string true "Token of the share"
+// @success 200 {object} SharedTodos
+// @failure 404 {object} Problem
+// @failure 406 {object} Problem
+func getShared(lookup func(token string) (SharedTodos, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Revoking a share must take effect at once, and the token must not leak
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+
+		shared, err := lookup(c.Param("token"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if c.GetString(mediaTypeKey) != mediaHTML {
+			c.JSON(http.StatusOK, shared)
+			return
+		}
+
+		title := "Shared todos"
+		if shared.Scope == ShareScopeTag {
+			title = "Todos tagged #" + shared.Tag
+		}
+		var buf bytes.Buffer
+		if err := sharedPage.Execute(&buf, struct {
+			Title string
+			SharedTodos
+		}{title, shared}); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, mediaHTML+"; charset=utf-8", buf.Bytes())
+	}
+}
+
+// rejectSharedMutation answers every method but GET on shared todos.
+func rejectSharedMutation(c *gin.Context) {
+	c.Header("Allow", http.MethodGet)
+	abortWithProblem(c, NewProblem(http.StatusMethodNotAllowed, "shared todos are read-only"))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createShare(t *testing.T, router *gin.Engine, user, body string) NewShare {
+	t.Helper()
+	w := apiRequest(router, http.MethodPost, "/shares", user, body)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var share NewShare
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &share))
+	return share
+}
+
+func getSharedTodos(t *testing.T, router *gin.Engine, path string) SharedTodos {
+	t.Helper()
+	w := apiRequest(router, http.MethodGet, path, "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var shared SharedTodos
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	return shared
+}
+
+func TestShares_ListScope(t *testing.T) {
+	s := &TodoMgr{}
+	_, err := s.Create("alice", TodoInput{Description: "Buy <b>milk</b>"})
+	require.NoError(t, err)
+	_, err = s.Create("bob", TodoInput{Description: "File taxes"})
+	require.NoError(t, err)
+	router := setupRouter(s)
+
+	share := createShare(t, router, "alice", `{"scope":"list"}`)
+	assert.Equal(t, "alice", share.Owner)
+	assert.Equal(t, "/shared/"+share.Token, share.Path)
+
+	w := apiRequest(router, http.MethodGet, share.Path, "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.NotContains(t, w.Body.String(), "alice", "owners are not shared")
+	var shared SharedTodos
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+	assert.Equal(t, ShareScopeList, shared.Scope)
+	require.Len(t, shared.Todos, 2)
+	assert.Equal(t, "Buy <b>milk</b>", shared.Todos[0].Description)
+
+	req := httptest.NewRequest(http.MethodGet, share.Path, nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "<title>Shared todos</title>")
+	assert.Contains(t, w.Body.String(), "Buy &lt;b&gt;milk&lt;/b&gt;")
+	assert.Contains(t, w.Body.String(), "File taxes")
+
+	// The token is only shown once
+	w = apiRequest(router, http.MethodGet, "/shares", "alice", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), share.ID)
+	assert.NotContains(t, w.Body.String(), share.Token)
+}
+
+func TestShares_TagScope(t *testing.T) {
+	s := &TodoMgr{}
+	for _, description := range []string{
+		"Tag v1.4 #release",
+		"#Release notes",
+		"Plan the next #releases",
+		"Read https://example.com/docs#release",
+		"Untagged",
+	} {
+		_, err := s.Create("", TodoInput{Description: description})
+		require.NoError(t, err)
+	}
+	router := setupRouter(s)
+
+	share := createShare(t, router, "", `{"scope":"tag","tag":"#RELEASE"}`)
+	assert.Equal(t, "release", share.Tag)
+
+	shared := getSharedTodos(t, router, share.Path)
+	assert.Equal(t, "release", shared.Tag)
+	var descriptions []string
+	for _, todo := range shared.Todos {
+		descriptions = append(descriptions, todo.Description)
+	}
+	assert.Equal(t, []string{"Tag v1.4 #release", "#Release notes"}, descriptions)
+
+	// Newly tagged todos show up, the scope is not a snapshot
+	_, err := s.Create("", TodoInput{Description: "Announce it #release"})
+	require.NoError(t, err)
+	assert.Len(t, getSharedTodos(t, router, share.Path).Todos, 3)
+}
+
+func TestShares_RejectMutations(t *testing.T) {
+	s := &TodoMgr{}
+	todo, err := s.Create("", TodoInput{Description: "Read only"})
+	require.NoError(t, err)
+	router := setupRouter(s)
+	share := createShare(t, router, "", `{"scope":"list"}`)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		w := apiRequest(router, method, share.Path, "", `{"description":"changed"}`)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code, method)
+		assert.Equal(t, http.MethodGet, w.Header().Get("Allow"), method)
+	}
+	got, err := s.Get(todo.UUID)
+	require.NoError(t, err)
+	assert.Equal(t, "Read only", got.Description)
+}
+
+func TestShares_RevokeAndExpire(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	s := &TodoMgr{}
+	router := setupRouter(s)
+
+	share := createShare(t, router, "alice", `{"scope":"list"}`)
+	assert.Equal(t, http.StatusForbidden, apiRequest(router, http.MethodDelete, "/shares/"+share.ID, "bob", "").Code)
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodDelete, "/shares/nope", "alice", "").Code)
+	getSharedTodos(t, router, share.Path)
+
+	w := apiRequest(router, http.MethodDelete, "/shares/"+share.ID, "alice", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var revoked Share
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &revoked))
+	assert.NotNil(t, revoked.RevokedAt)
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodGet, share.Path, "", "").Code)
+
+	// Admins can revoke the shares of others
+	other := createShare(t, router, "alice", `{"scope":"list"}`)
+	assert.Equal(t, http.StatusOK, apiRequest(router, http.MethodDelete, "/shares/"+other.ID, "root", "").Code)
+
+	expiring := createShare(t, router, "alice", `{"scope":"tag","tag":"ops","expires_at":"`+time.Now().Add(time.Hour).Format(time.RFC3339)+`"}`)
+	require.NotNil(t, expiring.ExpiresAt)
+	getSharedTodos(t, router, expiring.Path)
+	s.mu.Lock()
+	expired := time.Now().Add(-time.Minute)
+	s.shares[2].ExpiresAt = &expired
+	s.mu.Unlock()
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodGet, expiring.Path, "", "").Code)
+
+	assert.Equal(t, http.StatusNotFound, apiRequest(router, http.MethodGet, "/shared/guessed", "", "").Code)
+	assert.Len(t, s.Shares(), 3, "revoked and expired shares stay listed")
+}
+
+func TestShares_ListOnlyOwnUnlessAdmin(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	router := setupRouter(&TodoMgr{})
+	alices := createShare(t, router, "alice", `{"scope":"list"}`)
+	bobs := createShare(t, router, "bob", `{"scope":"tag","tag":"ops"}`)
+
+	list := func(user string) []string {
+		w := apiRequest(router, http.MethodGet, "/shares", user, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var shares []Share
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shares))
+		var ids []string
+		for _, sh := range shares {
+			ids = append(ids, sh.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{alices.ID}, list("alice"))
+	assert.Equal(t, []string{bobs.ID}, list("bob"))
+	assert.Empty(t, list(""))
+	assert.Equal(t, []string{alices.ID, bobs.ID}, list("root"))
+}
+
+func TestShares_Validation(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	for _, tt := range []struct{ body, field string }{
+		{`{}`, "scope"},
+		{`{"scope":"board"}`, "scope"},
+		{`{"scope":"tag"}`, "tag"},
+		{`{"scope":"tag","tag":"two words"}`, "tag"},
+		{`{"scope":"list","tag":"ops"}`, "tag"},
+		{`{"scope":"list","expires_at":"` + past + `"}`, "expires_at"},
+	} {
+		w := apiRequest(router, http.MethodPost, "/shares", "", tt.body)
+		require.Equal(t, http.StatusBadRequest, w.Code, tt.body)
+		var problem Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		if assert.Len(t, problem.Errors, 1, tt.body) {
+			assert.Equal(t, tt.field, problem.Errors[0].Field, tt.body)
+		}
+	}
+}
+
+func TestShares_NegotiatedBodies(t *testing.T) {
+	router := setupRouter(&TodoMgr{})
+
+	w := negotiationRequest(router, http.MethodPost, "/shares", "application/yaml", "", "scope: tag\ntag: ops\n")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var share NewShare
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &share))
+	assert.Equal(t, "ops", share.Tag)
+
+	w = negotiationRequest(router, http.MethodPost, "/shares", "application/xml", "", "<share/>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestShares_Tenants(t *testing.T) {
+	reg, router := setupTenantTest(t)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"acme"}`).Code)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"globex"}`).Code)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/api/v1/todos", "acme", "", `{"description":"acme only"}`).Code)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/api/v1/todos", "globex", "", `{"description":"globex only"}`).Code)
+
+	w := tenantRequest(router, http.MethodPost, "/shares", "acme", "alice", `{"scope":"list"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var share NewShare
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &share))
+
+	// Outsiders name no tenant
+	w = tenantRequest(router, http.MethodGet, share.Path, "", "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "acme only")
+	assert.NotContains(t, w.Body.String(), "globex only")
+	assert.Equal(t, http.StatusMethodNotAllowed, tenantRequest(router, http.MethodDelete, share.Path, "", "", "").Code)
+
+	_, err := reg.SuspendTenant("acme")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, tenantRequest(router, http.MethodGet, share.Path, "", "", "").Code)
+}
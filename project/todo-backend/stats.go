@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Statistics are computed on request from the audit trail (created, completed and deleted
+// counts, completion times) and the current todos (open count and age distribution).
+// A todo counts as completed when an event moves it into a done state; reopening and
+// completing it again counts twice.
+
+// maxStatsBuckets bounds the size of a GET /stats response.
+const maxStatsBuckets = 1000
+
+// StatsBucket holds the counts of one day or week. Start is midnight UTC, on Monday for weeks.
+type StatsBucket struct {
+	Start     time.Time `json:"start"`
+	Created   int       `json:"created"`
+	Completed int       `json:"completed"`
+	Deleted   int       `json:"deleted"`
+}
+
+// AgeBucket counts the open todos of an age range, e.g. "1-7d".
+type AgeBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// TodoStats is the response of GET /stats.
+type TodoStats struct {
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Bucket string        `json:"bucket"`
+	Series []StatsBucket `json:"series"`
+
+	Created   int `json:"created"`
+	Completed int `json:"completed"`
+	Deleted   int `json:"deleted"`
+	// AvgCompletionSeconds is the mean time from creation to completion of the todos
+	// completed within the range, omitted if none were.
+	AvgCompletionSeconds *float64 `json:"avg_completion_seconds,omitempty"`
+
+	Open            int         `json:"open"`
+	AgeDistribution []AgeBucket `json:"age_distribution"`
+}
+
+// ageBuckets are the age ranges of open todos, in order.
+var ageBuckets = []struct {
+	label string
+	below time.Duration // zero for the last, unbounded bucket
+}{
+	{"<1d", 24 * time.Hour},
+	{"1-7d", 7 * 24 * time.Hour},
+	{"7-30d", 30 * 24 * time.Hour},
+	{">30d", 0},
+}
+
+// bucketStart returns the start of the day or week containing t.
+func bucketStart(t time.Time, bucket string) time.Time {
+	y, m, d := t.UTC().Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if bucket == "week" {
+		// Weeks start on Monday, as in ISO 8601
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	}
+	return day
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	if bucket == "week" {
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// Stats computes the statistics for events in [from, to) grouped by "day" or "week",
+// with the open todos aged relative to now.
+func (s *TodoMgr) Stats(from, to time.Time, bucket string, now time.Time) TodoStats {
+	stats := TodoStats{From: from, To: to, Bucket: bucket, Series: []StatsBucket{}}
+	index := map[time.Time]int{}
+	for start := bucketStart(from, bucket); start.Before(to); start = nextBucket(start, bucket) {
+		index[start] = len(stats.Series)
+		stats.Series = append(stats.Series, StatsBucket{Start: start})
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// The done flag of every todo as of the event being looked at, to spot completions
+	done := map[string]bool{}
+	for _, t := range s.auditBase {
+		done[t.UUID] = t.Done
+	}
+	var completionTotal time.Duration
+	for _, ev := range s.auditTrail {
+		wasDone := done[ev.TodoUUID]
+		done[ev.TodoUUID] = ev.Todo.Done
+		// Restores bring back an earlier state, nobody did the work
+		if ev.At.Before(from) || !ev.At.Before(to) || ev.Backup != "" {
+			continue
+		}
+		b := &stats.Series[index[bucketStart(ev.At, bucket)]]
+
+		switch {
+		case ev.Action == AuditTodoCreated:
+			b.Created++
+			stats.Created++
+		case ev.Action == AuditTodoDeleted:
+			b.Deleted++
+			stats.Deleted++
+		case ev.Action == AuditTodoUpdated && ev.Todo.Done && !wasDone:
+			b.Completed++
+			stats.Completed++
+			completionTotal += ev.At.Sub(ev.Todo.CreatedAt)
+		}
+	}
+	if stats.Completed > 0 {
+		avg := completionTotal.Seconds() / float64(stats.Completed)
+		stats.AvgCompletionSeconds = &avg
+	}
+
+	stats.AgeDistribution = make([]AgeBucket, len(ageBuckets))
+	for i, ab := range ageBuckets {
+		stats.AgeDistribution[i].Label = ab.label
+	}
+	for _, t := range s.todosSorted {
+		if t.Done {
+			continue
+		}
+		stats.Open++
+		age := now.Sub(t.CreatedAt)
+		for i, ab := range ageBuckets {
+			if ab.below == 0 || age < ab.below {
+				stats.AgeDistribution[i].Count++
+				break
+			}
+		}
+	}
+	return stats
+}
+
+// getStats returns todo statistics for a time range.
+// @param from query string false "Start of the range (RFC 3339), defaults to 30 days before to"
+// @param to query string false "End of the range (RFC 3339), defaults to now"
+// @param bucket query string false "day (default) or week"
+// @success 200 {object} TodoStats
+// @failure 400 {object} Problem
+func (s *TodoMgr) getStats(c *gin.Context) {
+	now := time.Now().UTC()
+
+	to, ok := parseInstant(c, "to", now)
+	if !ok {
+		return
+	}
+	from, ok := parseInstant(c, "from", to.AddDate(0, 0, -30))
+	if !ok {
+		return
+	}
+	if !from.Before(to) {
+		abortWithValidationError(c, "to", "must be after from")
+		return
+	}
+
+	bucket := c.DefaultQuery("bucket", "day")
+	if bucket != "day" && bucket != "week" {
+		abortWithValidationError(c, "bucket", "must be day or week")
+		return
+	}
+	buckets := to.Sub(bucketStart(from, bucket)).Hours() / 24
+	if bucket == "week" {
+		buckets /= 7
+	}
+	if buckets > maxStatsBuckets {
+		abortWithValidationError(c, "from", "range is too long for the bucket size")
+		return
+	}
+
+	c.JSON(http.StatusOK, s.Stats(from, to, bucket, now))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_FromAuditTrail(t *testing.T) {
+	s := &TodoMgr{}
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 12, 0, 0, 0, time.UTC) }
+
+	// Build the history directly, so the events can be spread over several days
+	old := Todo{UUID: "old", State: "backlog", CreatedAt: day(1)}
+	fast := Todo{UUID: "fast", State: "backlog", CreatedAt: day(5)}
+	gone := Todo{UUID: "gone", State: "backlog", CreatedAt: day(6)}
+	s.record(old.CreatedAt, "", AuditTodoCreated, old, nil)
+	s.record(fast.CreatedAt, "", AuditTodoCreated, fast, nil)
+	s.record(gone.CreatedAt, "", AuditTodoCreated, gone, nil)
+	fastDone := fast
+	fastDone.Done, fastDone.State = true, "done"
+	s.record(day(7), "", AuditTodoUpdated, fastDone, nil)
+	// An update of a completed todo is not another completion
+	s.record(day(8), "", AuditTodoUpdated, fastDone, nil)
+	s.record(day(8), "", AuditTodoDeleted, gone, nil)
+	s.todosSorted = []Todo{old, fastDone}
+
+	stats := s.Stats(day(5).Add(-12*time.Hour), day(9).Add(-12*time.Hour), "day", day(20))
+
+	require.Len(t, stats.Series, 4)
+	assert.Equal(t, time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), stats.Series[0].Start)
+	assert.Equal(t, 1, stats.Series[0].Created)
+	assert.Equal(t, 1, stats.Series[2].Completed)
+	assert.Equal(t, 1, stats.Series[3].Deleted)
+	assert.Equal(t, 2, stats.Created)
+	assert.Equal(t, 1, stats.Completed)
+	assert.Equal(t, 1, stats.Deleted)
+	require.NotNil(t, stats.AvgCompletionSeconds)
+	assert.Equal(t, (48 * time.Hour).Seconds(), *stats.AvgCompletionSeconds)
+
+	// Only "old" is open, created 19 days before now
+	assert.Equal(t, 1, stats.Open)
+	assert.Equal(t, []AgeBucket{{"<1d", 0}, {"1-7d", 0}, {"7-30d", 1}, {">30d", 0}}, stats.AgeDistribution)
+}
+
+func TestStats_WeeklyBuckets(t *testing.T) {
+	s := &TodoMgr{}
+	// 2026-10-07 is a Wednesday, its week starts on Monday 2026-10-05
+	stats := s.Stats(time.Date(2026, 10, 7, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC), "week", time.Now())
+	require.Len(t, stats.Series, 3)
+	assert.Equal(t, time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), stats.Series[0].Start)
+	assert.Nil(t, stats.AvgCompletionSeconds)
+}
+
+func TestGetStats(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("", TodoInput{Description: "count me"})
+	require.NoError(t, err)
+	_, err = s.Transition("", todo.UUID, "done")
+	require.NoError(t, err)
+	_, err = s.Create("", TodoInput{Description: "still open"})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats?bucket=week", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var stats TodoStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 2, stats.Created)
+	assert.Equal(t, 1, stats.Completed)
+	assert.Equal(t, 1, stats.Open)
+
+	for _, query := range []string{
+		"?bucket=month",
+		"?from=2026-10-10T00:00:00Z&to=2026-10-01T00:00:00Z",
+		"?from=2000-01-01T00:00:00Z&to=2026-10-01T00:00:00Z",
+		"?to=yesterday",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Templates are reusable checklists such as a release or onboarding checklist: an ordered
+// list of todo descriptions with {{placeholders}}. Instantiating a template creates one
+// todo per item, with the placeholders filled from the request, in a single batch: either
+// all todos are created or none.
+//
+// Design choice: like the todos, templates are kept in memory.
+
+// maxTemplateItems caps the number of todos a template creates.
+const maxTemplateItems = 100
+
+// ErrTemplateNotFound is returned when no template has the given id.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// ErrNotTemplateOwner is returned when someone else than the owner or an admin changes
+// a template, or anyone but an admin changes an anonymous one.
+var ErrNotTemplateOwner = errors.New("only the owner of a template or an admin can change it")
+
+// placeholderPattern matches {{name}}, optionally with spaces inside the braces.
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// Template is an ordered list of todo descriptions with placeholders.
+type Template struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Items        []string  `json:"items"`
+	Placeholders []string  `json:"placeholders"` // names used in the items, in order of appearance
+	Owner        string    `json:"owner,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	ChangedAt    time.Time `json:"changed_at"`
+}
+
+// TemplateInput holds the fields of a new or replaced template.
+type TemplateInput struct {
+	Name  string   `json:"name"`
+	Items []string `json:"items"`
+}
+
+// normalizeTemplate trims and checks the input, returning the placeholders it uses.
+func normalizeTemplate(in TemplateInput) (TemplateInput, []string, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return in, nil, &ValidationError{Field: "name", Message: "is required"}
+	}
+	if len(in.Items) == 0 {
+		return in, nil, &ValidationError{Field: "items", Message: "is required"}
+	}
+	if len(in.Items) > maxTemplateItems {
+		return in, nil, &ValidationError{Field: "items", Message: "has too many entries"}
+	}
+
+	items := make([]string, len(in.Items))
+	placeholders := []string{}
+	seen := map[string]bool{}
+	for i, item := range in.Items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return in, nil, &ValidationError{Field: "items", Message: "must not contain empty descriptions"}
+		}
+		// Filled in descriptions are checked again, but an item too long on its own can never fit
+		if len(placeholderPattern.ReplaceAllString(item, "")) > TODOMAXLENGTTH {
+			return in, nil, &ValidationError{Field: "items", Message: "contains a description exceeding the maximum length"}
+		}
+		for _, m := range placeholderPattern.FindAllStringSubmatch(item, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				placeholders = append(placeholders, m[1])
+			}
+		}
+		items[i] = item
+	}
+	in.Items = items
+	return in, placeholders, nil
+}
+
+// fillTemplate replaces the placeholders of an item with the values.
+func fillTemplate(item string, values map[string]string) (string, error) {
+	var missing string
+	filled := placeholderPattern.ReplaceAllStringFunc(item, func(m string) string {
+		name := placeholderPattern.FindStringSubmatch(m)[1]
+		value, ok := values[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", &ValidationError{Field: "values." + missing, Message: "is required"}
+	}
+	return filled, nil
+}
+
+// Templates returns all templates in creation order.
+func (s *TodoMgr) Templates() []Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]Template, len(s.templates))
+	copy(out, s.templates)
+	return out
+}
+
+// Template returns the template with the given id.
+func (s *TodoMgr) Template(id string) (Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.templateIndex(id); i >= 0 {
+		return s.templates[i], nil
+	}
+	return Template{}, ErrTemplateNotFound
+}
+
+// CreateTemplate validates the input and stores a new template.
+func (s *TodoMgr) CreateTemplate(owner string, in TemplateInput) (Template, error) {
+	in, placeholders, err := normalizeTemplate(in)
+	if err != nil {
+		return Template{}, err
+	}
+
+	now := time.Now().UTC()
+	tmpl := Template{
+		ID:           uuid.New().String(),
+		Name:         in.Name,
+		Items:        in.Items,
+		Placeholders: placeholders,
+		Owner:        owner,
+		CreatedAt:    now,
+		ChangedAt:    now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates = append(s.templates, tmpl)
+	return tmpl, nil
+}
+
+// ReplaceTemplate replaces the name and items of a template, which only its owner or an
+// admin may do.
+func (s *TodoMgr) ReplaceTemplate(id, actor string, admin bool, in TemplateInput) (Template, error) {
+	in, placeholders, err := normalizeTemplate(in)
+	if err != nil {
+		return Template{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.ownedTemplateIndex(id, actor, admin)
+	if err != nil {
+		return Template{}, err
+	}
+	tmpl := &s.templates[i]
+	tmpl.Name = in.Name
+	tmpl.Items = in.Items
+	tmpl.Placeholders = placeholders
+	tmpl.ChangedAt = time.Now().UTC()
+	return *tmpl, nil
+}
+
+// DeleteTemplate removes a template, for its owner or an admin. Todos created from it
+// are kept.
+func (s *TodoMgr) DeleteTemplate(id, actor string, admin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.ownedTemplateIndex(id, actor, admin)
+	if err != nil {
+		return err
+	}
+	s.templates = append(s.templates[:i], s.templates[i+1:]...)
+	return nil
+}
+
+// Instantiate creates one todo per item of the template for the owner, with the
+// placeholders filled from values. Either all todos are created or none.
+func (s *TodoMgr) Instantiate(owner, id string, values map[string]string, dueAt *time.Time) ([]Todo, error) {
+	defer storeTimer("instantiate").ObserveDuration()
+
+	tmpl, err := s.Template(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate everything before taking the write lock, so a bad item creates nothing
+	now := time.Now().UTC()
+	todos := make([]Todo, len(tmpl.Items))
+	for i, item := range tmpl.Items {
+		filled, err := fillTemplate(item, values)
+		if err != nil {
+			return nil, err
+		}
+		description, err := normalizeDescription(filled)
+		if err != nil {
+			return nil, err
+		}
+		todos[i] = Todo{
+			UUID:        uuid.New().String(),
+			Description: description,
+			Owner:       owner,
+			State:       s.workflow().initialState(),
+			DueAt:       utcPtr(dueAt),
+			CreatedAt:   now,
+			ChangedAt:   now,
+			Links:       s.previewLinks(description, nil),
+		}
+	}
+
+	s.mu.Lock()
+	if s.overQuota(owner, len(todos)) {
+		s.mu.Unlock()
+		return nil, ErrQuotaExceeded
+	}
+	for _, t := range todos {
+		s.todosSorted = append(s.todosSorted, t)
+		s.publish(TodoEvent{Type: TodoCreated, Todo: t})
+		s.record(now, owner, AuditTodoCreated, t, nil)
+	}
+	s.mu.Unlock()
+
+	for _, t := range todos {
+		s.requestPreviews(t)
+	}
+	return todos, nil
+}
+
+// templateIndex returns the position of the template, or -1.
+// Caller must hold s.mu.
+func (s *TodoMgr) templateIndex(id string) int {
+	for i := range s.templates {
+		if s.templates[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ownedTemplateIndex returns the position of the template the actor wants to change.
+// Anonymous actors own nothing, so templates created anonymously are left to the admins.
+// Caller must hold s.mu.
+func (s *TodoMgr) ownedTemplateIndex(id, actor string, admin bool) (int, error) {
+	i := s.templateIndex(id)
+	if i < 0 {
+		return -1, ErrTemplateNotFound
+	}
+	if !admin && (actor == "" || s.templates[i].Owner != actor) {
+		return -1, ErrNotTemplateOwner
+	}
+	return i, nil
+}
+
+// getTemplates lists the templates.
+// @success 200 {array} Template
+func (s *TodoMgr) getTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Templates())
+}
+
+// createTemplate stores a new template.
+// @param name body string true "Name of the template"
+// @param items body []string true "Todo descriptions, may contain {{placeholders}}"
+// @success 201 {object} Template
+// @failure 400 {object} Problem
+func (s *TodoMgr) createTemplate(c *gin.Context) {
+	var req TemplateInput
+	if !bindBody(c, &req) {
+		return
+	}
+
+	tmpl, err := s.CreateTemplate(requestIdentity(c), req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, tmpl)
+}
+
+// getTemplate returns a template.
+// @param id path string true "ID of the template"
+// @success 200 {object} Template
+// @failure 404 {object} Problem
+func (s *TodoMgr) getTemplate(c *gin.Context) {
+	tmpl, err := s.Template(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tmpl)
+}
+
+// putTemplate returns the handler replacing the name and items of a template, for its
+// owner or the admins.
+// @param id path string true "ID of the template"
+// @param name body string true "Name of the template"
+// @param items body []string true "Todo descriptions, may contain {{placeholders}}"
+// @success 200 {object} Template
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) putTemplate(admins map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TemplateInput
+		if !bindBody(c, &req) {
+			return
+		}
+
+		actor := requestIdentity(c)
+		tmpl, err := s.ReplaceTemplate(c.Param("id"), actor, admins[actor], req)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, tmpl)
+	}
+}
+
+// deleteTemplate returns the handler deleting a template, for its owner or the admins.
+// @param id path string true "ID of the template"
+// @success 200 {object} map[string]string
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (s *TodoMgr) deleteTemplate(admins map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := requestIdentity(c)
+		if err := s.DeleteTemplate(c.Param("id"), actor, admins[actor]); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "template deleted"})
+	}
+}
+
+// instantiateTemplate creates the todos of a template.
+// @param id path string true "ID of the template"
+// @param values body map[string]string false "Placeholder values"
+// @param due_at body string false "Due date (RFC 3339) of all created todos"
+// @param Idempotency-Key header string false "Replays the first response for retries with the same key"
+// @success 201 {array} Todo
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+// @failure 409 {object} Problem
+// @failure 422 {object} Problem
+func (s *TodoMgr) instantiateTemplate(c *gin.Context) {
+	var req struct {
+		Values map[string]string `json:"values"`
+		DueAt  *time.Time        `json:"due_at"`
+	}
+	// An empty body has no values
+	if !bindOptionalBody(c, &req) {
+		return
+	}
+
+	todos, err := s.Instantiate(requestIdentity(c), c.Param("id"), req.Values, req.DueAt)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, todos)
+}
@@ -0,0 +1,903 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// One todo-backend can serve several teams as tenants. Multi-tenancy is enabled by
+// TENANT_SOURCES, the comma separated places the tenant of a request is taken from, tried
+// in order:
+//
+//	header     the X-Tenant-ID header
+//	subdomain  the first label of the host under TENANT_DOMAIN, e.g. acme.todo.example.com
+//	claim      the TENANT_CLAIM claim (default "tenant") of the token in
+//	           X-Forwarded-Access-Token
+//
+// Like X-Forwarded-User, the headers are set by the authenticating proxy, which has
+// verified the token, so the claim is only decoded here. The ingress strips them from
+// client requests (manifests/middleware-strip-identity.yaml), and todo-backend drops them
+// from requests that do not come from TRUSTED_PROXIES. The same goes for the metadata of
+// gRPC calls, so calls from other peers can only name their tenant by the authority.
+//
+// Every tenant has its own TodoMgr, so todos, comments, templates, the audit trail and
+// the rate limits are not shared, and its own directory under TENANTS_DIR for its
+// attachments, jobs and backups. A tenant's quota caps its todos in total and per owner.
+// Admins manage tenants under /admin/tenants; the registry is kept in tenants.json there,
+// encrypted like the other files. Requests of suspended tenants get a 403; only suspended
+// tenants can be deleted, which removes their directory. Share links are served without
+// a tenant, which is found by the token.
+//
+// Without TENANT_SOURCES todo-backend serves a single tenant, as before.
+
+const (
+	tenantHeader      = "X-Tenant-ID"
+	accessTokenHeader = "X-Forwarded-Access-Token"
+
+	tenantSourceHeader    = "header"
+	tenantSourceSubdomain = "subdomain"
+	tenantSourceClaim     = "claim"
+
+	tenantStatusActive    = "active"
+	tenantStatusSuspended = "suspended"
+
+	// tenantSuspendedReason is the reason of the problem for requests of suspended tenants
+	tenantSuspendedReason = "tenant-suspended"
+
+	tenantsFileName = "tenants.json"
+	tenantsContext  = "tenants"
+
+	// deleteTenantTimeout is how long the running jobs of a deleted tenant get to finish
+	deleteTenantTimeout = 20 * time.Second
+)
+
+// Defaults for the env variables read in NewTenantRegistryFromEnv.
+const (
+	defaultTenantsDir     = "/app/data/tenants"
+	defaultTenantClaim    = "tenant"
+	defaultTenantMaxTodos = 10000
+)
+
+// tenantMgrKey is the context key of the TodoMgr of the tenant of a gRPC call.
+type tenantMgrKey struct{}
+
+// tenantIDPattern keeps tenant ids usable as DNS labels and directory names.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var (
+	// ErrNoTenant is returned for requests that name no tenant.
+	ErrNoTenant = errors.New("the request names no tenant")
+	// ErrTenantNotFound is returned for unknown tenants.
+	ErrTenantNotFound = errors.New("tenant not found")
+	// ErrTenantSuspended is returned for requests of suspended tenants.
+	ErrTenantSuspended = errors.New("tenant is suspended")
+	// ErrTenantExists is returned when creating a tenant with the id of an existing one.
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrTenantActive is returned when deleting a tenant that is not suspended.
+	ErrTenantActive = errors.New("only suspended tenants can be deleted")
+)
+
+// TenantQuota limits the todos of a tenant. Zero means unlimited.
+type TenantQuota struct {
+	MaxTodos         int `json:"max_todos"`
+	MaxTodosPerOwner int `json:"max_todos_per_owner"`
+}
+
+// TenantUsage is what a tenant uses of its quota.
+type TenantUsage struct {
+	Todos int `json:"todos"`
+}
+
+// Tenant is a team served by todo-backend.
+type Tenant struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name,omitempty"`
+	Status      string       `json:"status"`
+	Quota       TenantQuota  `json:"quota"`
+	CreatedAt   time.Time    `json:"created_at"`
+	SuspendedAt *time.Time   `json:"suspended_at,omitempty"`
+	Usage       *TenantUsage `json:"usage,omitempty"` // in responses only
+}
+
+// TenantInput holds the fields of a new tenant. A nil quota gets the defaults.
+type TenantInput struct {
+	ID    string       `json:"id"`
+	Name  string       `json:"name"`
+	Quota *TenantQuota `json:"quota"`
+}
+
+// tenant is a tenant with the TodoMgr and the router serving it.
+type tenant struct {
+	Tenant
+	mgr    *TodoMgr
+	router http.Handler
+}
+
+// TenantRegistry holds the tenants and routes requests to them.
+type TenantRegistry struct {
+	mu      sync.RWMutex
+	dir     string
+	tenants map[string]*tenant
+	// deleting holds the ids of deleted tenants whose data is still being removed
+	deleting map[string]bool
+	keys     *Keyring
+	newMgr   func(id, dir string) (*TodoMgr, error)
+
+	// Sources are the places the tenant is taken from, in order.
+	Sources []string
+	// Domain is the domain under which subdomains name tenants.
+	Domain string
+	// Claim is the claim of the access token naming the tenant.
+	Claim string
+	// DefaultQuota is the quota of tenants created without one.
+	DefaultQuota TenantQuota
+}
+
+// NewTenantRegistry loads the tenants kept in dir. newMgr returns the TodoMgr of a
+// tenant, storing its files in the given directory.
+func NewTenantRegistry(dir string, keys *Keyring, newMgr func(id, dir string) (*TodoMgr, error)) (*TenantRegistry, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	reg := &TenantRegistry{
+		dir:      dir,
+		tenants:  map[string]*tenant{},
+		deleting: map[string]bool{},
+		keys:     keys,
+		newMgr:   newMgr,
+		Sources:  []string{tenantSourceHeader},
+		Claim:    defaultTenantClaim,
+	}
+
+	data, err := readSealedFile(keys, filepath.Join(dir, tenantsFileName), tenantsContext)
+	if errors.Is(err, os.ErrNotExist) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", tenantsFileName, err)
+	}
+	for _, t := range tenants {
+		if _, err := reg.add(t); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+	}
+	return reg, nil
+}
+
+// tenantSourcesFromEnv reads TENANT_SOURCES; nil means a single tenant.
+func tenantSourcesFromEnv() ([]string, error) {
+	var sources []string
+	for _, source := range strings.Split(os.Getenv("TENANT_SOURCES"), ",") {
+		switch source = strings.TrimSpace(source); source {
+		case "":
+		case tenantSourceHeader, tenantSourceSubdomain, tenantSourceClaim:
+			sources = append(sources, source)
+		default:
+			return nil, fmt.Errorf("unknown tenant source %q", source)
+		}
+	}
+	return sources, nil
+}
+
+// NewTenantRegistryFromEnv configures the registry from TENANTS_DIR, TENANT_DOMAIN,
+// TENANT_CLAIM and the default quota from TENANT_MAX_TODOS and MAX_TODOS_PER_OWNER.
+func NewTenantRegistryFromEnv(sources []string, keys *Keyring, newMgr func(id, dir string) (*TodoMgr, error)) (*TenantRegistry, error) {
+	dir := os.Getenv("TENANTS_DIR")
+	if dir == "" {
+		dir = defaultTenantsDir
+	}
+	reg, err := NewTenantRegistry(dir, keys, newMgr)
+	if err != nil {
+		return nil, err
+	}
+	reg.Sources = sources
+	reg.Domain = strings.ToLower(strings.Trim(os.Getenv("TENANT_DOMAIN"), "."))
+	if claim := os.Getenv("TENANT_CLAIM"); claim != "" {
+		reg.Claim = claim
+	}
+	if slices.Contains(reg.Sources, tenantSourceSubdomain) && reg.Domain == "" {
+		return nil, errors.New("the subdomain tenant source needs TENANT_DOMAIN")
+	}
+	reg.DefaultQuota = TenantQuota{MaxTodos: defaultTenantMaxTodos, MaxTodosPerOwner: maxTodosPerOwnerFromEnv()}
+	if value := os.Getenv("TENANT_MAX_TODOS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid TENANT_MAX_TODOS %q", value)
+		}
+		reg.DefaultQuota.MaxTodos = n
+	}
+	return reg, nil
+}
+
+// tenantMgrFactory returns a newMgr for NewTenantRegistry building TodoMgrs like base,
+// with the attachments, jobs and backups of each tenant in its own directory.
+func tenantMgrFactory(base *TodoMgr, jobWorkers int) func(id, dir string) (*TodoMgr, error) {
+	return func(id, dir string) (*TodoMgr, error) {
+		attachments, err := NewAttachmentStore(filepath.Join(dir, "attachments"),
+			sizeFromEnv("ATTACHMENT_MAX_SIZE", defaultMaxAttachmentSize),
+			sizeFromEnv("ATTACHMENTS_MAX_PER_TODO", defaultMaxAttachmentsPerTodo))
+		if err != nil {
+			return nil, err
+		}
+		attachments.Keys = base.Keys
+		backups, err := NewBackupStore(filepath.Join(dir, "backups"))
+		if err != nil {
+			return nil, err
+		}
+		backups.Keys = base.Keys
+		jobs, err := NewJobQueue(filepath.Join(dir, "jobs.json"), jobWorkers, base.Keys)
+		if err != nil {
+			return nil, err
+		}
+		s := &TodoMgr{
+			MaxAuditEvents: base.MaxAuditEvents,
+			Attachments:    attachments,
+			Workflow:       base.Workflow,
+			Previews:       base.Previews,
+			Jobs:           jobs,
+			Backups:        backups,
+			Keys:           base.Keys,
+		}
+		jobs.Handle(linkPreviewJob, s.runLinkPreviewJob)
+		jobs.Start()
+		return s, nil
+	}
+}
+
+// add sets up the TodoMgr and router of a tenant. Caller must hold reg.mu for writing,
+// or own reg exclusively.
+func (reg *TenantRegistry) add(t Tenant) (*tenant, error) {
+	mgr, err := reg.newMgr(t.ID, reg.tenantDir(t.ID))
+	if err != nil {
+		return nil, err
+	}
+	mgr.SetQuota(t.Quota.MaxTodos, t.Quota.MaxTodosPerOwner)
+	if t.Status == tenantStatusSuspended && mgr.Jobs != nil {
+		mgr.Jobs.SetPaused(true)
+	}
+	entry := &tenant{Tenant: t, mgr: mgr, router: setupRouter(mgr)}
+	reg.tenants[t.ID] = entry
+	return entry, nil
+}
+
+func (reg *TenantRegistry) tenantDir(id string) string {
+	return filepath.Join(reg.dir, id)
+}
+
+// save writes the registry. Caller must hold reg.mu.
+func (reg *TenantRegistry) save() error {
+	tenants := make([]Tenant, 0, len(reg.tenants))
+	for _, t := range reg.tenants {
+		tenants = append(tenants, t.Tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	data, err := json.Marshal(tenants)
+	if err != nil {
+		return err
+	}
+	return writeSealedFile(reg.keys, filepath.Join(reg.dir, tenantsFileName), tenantsContext, data)
+}
+
+func normalizeTenantQuota(q TenantQuota) (TenantQuota, error) {
+	if q.MaxTodos < 0 {
+		return q, &ValidationError{Field: "quota.max_todos", Message: "must not be negative"}
+	}
+	if q.MaxTodosPerOwner < 0 {
+		return q, &ValidationError{Field: "quota.max_todos_per_owner", Message: "must not be negative"}
+	}
+	return q, nil
+}
+
+// CreateTenant adds an active tenant.
+func (reg *TenantRegistry) CreateTenant(in TenantInput) (Tenant, error) {
+	id := strings.TrimSpace(in.ID)
+	if !tenantIDPattern.MatchString(id) {
+		return Tenant{}, &ValidationError{Field: "id", Message: "must be lower case letters, digits and dashes"}
+	}
+	quota := reg.DefaultQuota
+	if in.Quota != nil {
+		quota = *in.Quota
+	}
+	quota, err := normalizeTenantQuota(quota)
+	if err != nil {
+		return Tenant{}, err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if _, ok := reg.tenants[id]; ok || reg.deleting[id] {
+		return Tenant{}, ErrTenantExists
+	}
+	t, err := reg.add(Tenant{
+		ID:        id,
+		Name:      strings.TrimSpace(in.Name),
+		Status:    tenantStatusActive,
+		Quota:     quota,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return Tenant{}, err
+	}
+	if err := reg.save(); err != nil {
+		delete(reg.tenants, id)
+		// The new queue has no running jobs, so stopping it does not wait
+		if cleanupErr := reg.dispose(context.Background(), id, t); cleanupErr != nil {
+			log.Printf("Tenant %s: cannot remove the data: %v", id, cleanupErr)
+		}
+		return Tenant{}, err
+	}
+	return t.withUsage(), nil
+}
+
+// withUsage returns the tenant with its current usage.
+func (t *tenant) withUsage() Tenant {
+	out := t.Tenant
+	out.Usage = &TenantUsage{Todos: t.mgr.Count()}
+	return out
+}
+
+// Tenants returns all tenants, ordered by id.
+func (reg *TenantRegistry) Tenants() []Tenant {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	out := make([]Tenant, 0, len(reg.tenants))
+	for _, t := range reg.tenants {
+		out = append(out, t.withUsage())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Tenant returns the tenant with the id.
+func (reg *TenantRegistry) Tenant(id string) (Tenant, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	t, ok := reg.tenants[id]
+	if !ok {
+		return Tenant{}, ErrTenantNotFound
+	}
+	return t.withUsage(), nil
+}
+
+// update changes a tenant with fn and saves the registry.
+func (reg *TenantRegistry) update(id string, fn func(t *tenant) error) (Tenant, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	t, ok := reg.tenants[id]
+	if !ok {
+		return Tenant{}, ErrTenantNotFound
+	}
+	before := t.Tenant
+	if err := fn(t); err != nil {
+		return Tenant{}, err
+	}
+	if err := reg.save(); err != nil {
+		t.Tenant = before
+		return Tenant{}, err
+	}
+	return t.withUsage(), nil
+}
+
+// SetTenantQuota replaces the quota of a tenant. Todos above a lowered quota are kept,
+// but no new ones can be created.
+func (reg *TenantRegistry) SetTenantQuota(id string, q TenantQuota) (Tenant, error) {
+	q, err := normalizeTenantQuota(q)
+	if err != nil {
+		return Tenant{}, err
+	}
+	return reg.update(id, func(t *tenant) error {
+		t.Quota = q
+		t.mgr.SetQuota(q.MaxTodos, q.MaxTodosPerOwner)
+		return nil
+	})
+}
+
+// SuspendTenant rejects the requests of a tenant and pauses its jobs, keeping its data.
+func (reg *TenantRegistry) SuspendTenant(id string) (Tenant, error) {
+	return reg.update(id, func(t *tenant) error {
+		if t.Status == tenantStatusSuspended {
+			return nil
+		}
+		now := time.Now().UTC()
+		t.Status, t.SuspendedAt = tenantStatusSuspended, &now
+		if t.mgr.Jobs != nil {
+			t.mgr.Jobs.SetPaused(true)
+		}
+		return nil
+	})
+}
+
+// ResumeTenant serves a suspended tenant again.
+func (reg *TenantRegistry) ResumeTenant(id string) (Tenant, error) {
+	return reg.update(id, func(t *tenant) error {
+		t.Status, t.SuspendedAt = tenantStatusActive, nil
+		if t.mgr.Jobs != nil {
+			t.mgr.Jobs.SetPaused(t.mgr.Maintenance().Enabled)
+		}
+		return nil
+	})
+}
+
+// DeleteTenant removes a suspended tenant with all its data. Its running jobs get
+// deleteTenantTimeout to finish, without blocking the registry. The deletion is not tied
+// to a request, so the directory is not removed while the jobs still run.
+func (reg *TenantRegistry) DeleteTenant(id string) error {
+	reg.mu.Lock()
+	t, ok := reg.tenants[id]
+	if !ok {
+		reg.mu.Unlock()
+		return ErrTenantNotFound
+	}
+	if t.Status != tenantStatusSuspended {
+		reg.mu.Unlock()
+		return ErrTenantActive
+	}
+	delete(reg.tenants, id)
+	if err := reg.save(); err != nil {
+		reg.tenants[id] = t
+		reg.mu.Unlock()
+		return err
+	}
+	// Keeps the id from being created again while its directory is removed
+	reg.deleting[id] = true
+	reg.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), deleteTenantTimeout)
+	defer cancel()
+	err := reg.dispose(ctx, id, t)
+
+	reg.mu.Lock()
+	delete(reg.deleting, id)
+	reg.mu.Unlock()
+	return err
+}
+
+// dispose stops the jobs of a tenant that is no longer in the registry and removes its
+// directory.
+func (reg *TenantRegistry) dispose(ctx context.Context, id string, t *tenant) error {
+	if t.mgr.Jobs != nil {
+		if err := t.mgr.Jobs.Stop(ctx); err != nil {
+			log.Printf("Tenant %s: cancelled running jobs: %v", id, err)
+		}
+	}
+	return os.RemoveAll(reg.tenantDir(id))
+}
+
+// Each calls fn with the TodoMgr of every tenant.
+func (reg *TenantRegistry) Each(fn func(id string, s *TodoMgr)) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	for id, t := range reg.tenants {
+		fn(id, t.mgr)
+	}
+}
+
+// ToggleMaintenance switches the maintenance mode of all tenants on if any of them is
+// writable, otherwise off. The jobs of suspended tenants stay paused. It reports whether
+// maintenance is on.
+func (reg *TenantRegistry) ToggleMaintenance() bool {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	enable := false
+	for _, t := range reg.tenants {
+		enable = enable || !t.mgr.Maintenance().Enabled
+	}
+	for _, t := range reg.tenants {
+		switch {
+		case !enable:
+			t.mgr.endMaintenance(t.Status != tenantStatusSuspended)
+		case !t.mgr.Maintenance().Enabled:
+			t.mgr.StartMaintenance(MaintenanceInput{})
+		}
+	}
+	return enable
+}
+
+// Stop stops the jobs of all tenants, see JobQueue.Stop.
+func (reg *TenantRegistry) Stop(ctx context.Context) error {
+	var errs []error
+	reg.Each(func(id string, s *TodoMgr) {
+		if s.Jobs != nil {
+			if err := s.Jobs.Stop(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("tenant %s: %w", id, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Rekey rewraps the data keys of tenants.json and of the files of all tenants with the
+// primary key.
+func (reg *TenantRegistry) Rekey() (RekeyResult, error) {
+	var result RekeyResult
+	if reg.keys == nil {
+		return result, ErrEncryptionDisabled
+	}
+	reg.mu.RLock()
+	// Holding the lock keeps save from writing the file meanwhile
+	changed, err := rewrapFile(reg.keys, filepath.Join(reg.dir, tenantsFileName), tenantsContext)
+	mgrs := make(map[string]*TodoMgr, len(reg.tenants))
+	for id, t := range reg.tenants {
+		mgrs[id] = t.mgr
+	}
+	reg.mu.RUnlock()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return result, err
+	default:
+		result.add(changed)
+	}
+
+	for id, s := range mgrs {
+		r, err := s.Rekey()
+		result.Rewrapped += r.Rewrapped
+		result.Unchanged += r.Unchanged
+		if err != nil && !errors.Is(err, ErrEncryptionDisabled) {
+			return result, fmt.Errorf("tenant %s: %w", id, err)
+		}
+	}
+	return result, nil
+}
+
+// tenantOf returns the id of the tenant named by a request, trying the sources in order.
+// header returns the value of a header, host is the requested host.
+func (reg *TenantRegistry) tenantOf(header func(string) string, host string) (string, error) {
+	for _, source := range reg.Sources {
+		var id string
+		switch source {
+		case tenantSourceHeader:
+			id = header(tenantHeader)
+		case tenantSourceSubdomain:
+			id = subdomainOf(host, reg.Domain)
+		case tenantSourceClaim:
+			id = tokenClaim(header(accessTokenHeader), reg.Claim)
+		}
+		if id = strings.ToLower(strings.TrimSpace(id)); id != "" {
+			return id, nil
+		}
+	}
+	return "", ErrNoTenant
+}
+
+// subdomainOf returns the label of host right under domain, or "".
+func subdomainOf(host, domain string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), "."+domain)
+	if !ok || domain == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// tokenClaim returns a string claim of a JWT, or "". The signature is not checked: the
+// proxy passing the token has done so.
+func tokenClaim(token, claim string) string {
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	value, _ := claims[claim].(string)
+	return value
+}
+
+// resolve returns the active tenant with the id.
+func (reg *TenantRegistry) resolve(id string) (*tenant, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	t, ok := reg.tenants[id]
+	if !ok {
+		return nil, ErrTenantNotFound
+	}
+	if t.Status == tenantStatusSuspended {
+		return nil, ErrTenantSuspended
+	}
+	return t, nil
+}
+
+// SharedTodos looks the token up in the shares of every active tenant, so that share
+// links work without naming the tenant.
+func (reg *TenantRegistry) SharedTodos(token string) (SharedTodos, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	for _, t := range reg.tenants {
+		if t.Status == tenantStatusSuspended {
+			continue
+		}
+		if shared, err := t.mgr.SharedTodos(token); !errors.Is(err, ErrShareLinkInvalid) {
+			return shared, err
+		}
+	}
+	return SharedTodos{}, ErrShareLinkInvalid
+}
+
+// newTenantRouter serves the tenant admin routes, and hands every other request to the
+// router of its tenant.
+func newTenantRouter(reg *TenantRegistry) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.CustomRecovery(recovery))
+	trustProxies(r, trustedProxiesFromEnv(), identityHeader, tenantHeader, accessTokenHeader)
+	// The routers of the tenants log and validate their requests themselves
+	r.NoRoute(reg.dispatch)
+
+	spec := mustLoadOpenAPISpec()
+	own := r.Group("", gin.Logger(), instrumented())
+	if gin.Mode() == gin.TestMode || os.Getenv("OPENAPI_VALIDATION") == "true" {
+		own.Use(openAPIValidator(spec))
+	}
+	// Served without a tenant, for the probes and the docs
+	own.GET("/openapi.json", getOpenAPISpec(spec))
+	own.GET("/docs", getDocs)
+	own.GET("/healthz", healthHandler(reg.livenessChecks()))
+	own.GET("/metrics", getMetrics)
+	own.GET("/readyz", reg.getReadiness)
+	// Share links name no tenant, the token finds it
+	sharedRoutes(own, routeRateLimited(rateLimitFromEnv("RATE_LIMIT_READ", defaultReadRateLimit)), reg.SharedTodos)
+
+	admins := adminsFromEnv()
+	write := routeRateLimited(rateLimitFromEnv("RATE_LIMIT_WRITE", defaultWriteRateLimit))
+	// The keys are shared by all tenants, so the rekey covers the registry and every tenant
+	own.POST("/admin/rekey", requireAdmin(admins), write, postRekey(reg.Rekey))
+
+	admin := own.Group("/admin/tenants", requireAdmin(admins), write)
+	admin.GET("", reg.getTenants)
+	admin.POST("", reg.createTenant)
+	admin.GET("/:id", reg.getTenant)
+	admin.DELETE("/:id", reg.deleteTenant)
+	admin.PUT("/:id/quota", reg.putTenantQuota)
+	admin.POST("/:id/suspend", reg.suspendTenant)
+	admin.POST("/:id/resume", reg.resumeTenant)
+	return r
+}
+
+// dispatch hands the request to the router of its tenant.
+func (reg *TenantRegistry) dispatch(c *gin.Context) {
+	id, err := reg.tenantOf(c.GetHeader, c.Request.Host)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	t, err := reg.resolve(id)
+	if errors.Is(err, ErrTenantSuspended) {
+		p := NewProblem(http.StatusForbidden, err.Error())
+		p.Reason = tenantSuspendedReason
+		abortWithProblem(c, p)
+		return
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	t.router.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// grpcTenant resolves the tenant of a gRPC call from its metadata, the gRPC counterpart
+// of dispatch.
+func (reg *TenantRegistry) grpcTenant(ctx context.Context) (*TodoMgr, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	id, err := reg.tenantOf(header, header(":authority"))
+	if err == nil {
+		var t *tenant
+		if t, err = reg.resolve(id); err == nil {
+			return t.mgr, nil
+		}
+	}
+	switch {
+	case errors.Is(err, ErrNoTenant):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrTenantNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	default:
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+}
+
+// tenantUnaryInterceptor puts the TodoMgr of the tenant into the context of unary calls
+// and applies its maintenance mode.
+func (reg *TenantRegistry) tenantUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !strings.HasPrefix(info.FullMethod, "/todo.") {
+		return handler(ctx, req)
+	}
+	s, err := reg.grpcTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.maintenanceInterceptor(context.WithValue(ctx, tenantMgrKey{}, s), req, info, handler)
+}
+
+// tenantStreamInterceptor is tenantUnaryInterceptor for streaming calls.
+func (reg *TenantRegistry) tenantStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !strings.HasPrefix(info.FullMethod, "/todo.") {
+		return handler(srv, ss)
+	}
+	s, err := reg.grpcTenant(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextServerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), tenantMgrKey{}, s)})
+}
+
+// livenessChecks are the checks of /healthz: the registry and the stores of all tenants
+// can be locked.
+func (reg *TenantRegistry) livenessChecks() *HealthChecks {
+	checks := &HealthChecks{}
+	checks.Add("stores", func(ctx context.Context) error {
+		if err := rLockWithin(ctx, &reg.mu); err != nil {
+			return fmt.Errorf("registry: %w", err)
+		}
+		defer reg.mu.RUnlock()
+		for id, t := range reg.tenants {
+			if err := lockAvailable(&t.mgr.mu)(ctx); err != nil {
+				return fmt.Errorf("tenant %s: %w", id, err)
+			}
+		}
+		return nil
+	})
+	return checks
+}
+
+// getReadiness reports todo-backend ready if the storage of the tenants is available; the
+// maintenance modes are per tenant.
+// @success 200 {object} map[string]any
+// @failure 503 {object} map[string]any
+func (reg *TenantRegistry) getReadiness(c *gin.Context) {
+	checks := &HealthChecks{}
+	checks.Add("tenants", dirWritable(reg.dir))
+	ok, results := checks.Run(c.Request.Context())
+	reg.mu.RLock()
+	n := len(reg.tenants)
+	reg.mu.RUnlock()
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "tenants": n, "checks": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "tenants": n, "checks": results})
+}
+
+// getTenants lists the tenants.
+// @success 200 {array} Tenant
+// @failure 403 {object} Problem
+func (reg *TenantRegistry) getTenants(c *gin.Context) {
+	c.JSON(http.StatusOK, reg.Tenants())
+}
+
+// createTenant adds a tenant.
+// @param id body string true "Id of the tenant, a DNS label"
+// @param name body string false "Display name"
+// @param quota body TenantQuota false "Quota, default from TENANT_MAX_TODOS and MAX_TODOS_PER_OWNER"
+// @success 201 {object} Tenant
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 409 {object} Problem
+func (reg *TenantRegistry) createTenant(c *gin.Context) {
+	var req TenantInput
+	if !bindBody(c, &req) {
+		return
+	}
+	t, err := reg.CreateTenant(req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, t)
+}
+
+// getTenant returns a tenant with its usage.
+// @param id path string true "Id of the tenant"
+// @success 200 {object} Tenant
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (reg *TenantRegistry) getTenant(c *gin.Context) {
+	t, err := reg.Tenant(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// putTenantQuota replaces the quota of a tenant.
+// @param id path string true "Id of the tenant"
+// @param quota body TenantQuota true "The quota"
+// @success 200 {object} Tenant
+// @failure 400 {object} Problem
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (reg *TenantRegistry) putTenantQuota(c *gin.Context) {
+	var req TenantQuota
+	if !bindBody(c, &req) {
+		return
+	}
+	t, err := reg.SetTenantQuota(c.Param("id"), req)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// suspendTenant suspends a tenant.
+// @param id path string true "Id of the tenant"
+// @success 200 {object} Tenant
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (reg *TenantRegistry) suspendTenant(c *gin.Context) {
+	t, err := reg.SuspendTenant(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// resumeTenant serves a suspended tenant again.
+// @param id path string true "Id of the tenant"
+// @success 200 {object} Tenant
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+func (reg *TenantRegistry) resumeTenant(c *gin.Context) {
+	t, err := reg.ResumeTenant(c.Param("id"))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// deleteTenant deletes a suspended tenant and its data.
+// @param id path string true "Id of the tenant"
+// @success 200 {object} Message
+// @failure 403 {object} Problem
+// @failure 404 {object} Problem
+// @failure 409 {object} Problem
+func (reg *TenantRegistry) deleteTenant(c *gin.Context) {
+	if err := reg.DeleteTenant(c.Param("id")); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "tenant deleted"})
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"fazstrac/project/todo-backend/todopb"
+)
+
+// inMemoryTenants keeps the TodoMgrs of tenants in memory only.
+func inMemoryTenants(id, dir string) (*TodoMgr, error) {
+	return &TodoMgr{}, nil
+}
+
+func setupTenantTest(t *testing.T) (*TenantRegistry, *gin.Engine) {
+	t.Helper()
+	t.Setenv("ADMIN_USERS", "root")
+	reg, err := NewTenantRegistry(t.TempDir(), nil, inMemoryTenants)
+	require.NoError(t, err)
+	return reg, newTenantRouter(reg)
+}
+
+func tenantRequest(router *gin.Engine, method, path, tenantID, user, body string) *httptest.ResponseRecorder {
+	req := newAPIRequest(method, path, user, body)
+	if tenantID != "" {
+		req.Header.Set(tenantHeader, tenantID)
+	}
+	return serve(router, req)
+}
+
+func TestTenants_Isolation(t *testing.T) {
+	_, router := setupTenantTest(t)
+	assert.Equal(t, http.StatusForbidden, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "alice", `{"id":"acme"}`).Code)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"acme","name":"ACME"}`).Code)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"globex"}`).Code)
+	assert.Equal(t, http.StatusConflict, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"acme"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"Not A Label"}`).Code)
+
+	w := tenantRequest(router, http.MethodPost, "/api/v1/todos", "acme", "", `{"description":"acme only"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = tenantRequest(router, http.MethodGet, "/api/v1/todos", "acme", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "acme only")
+	w = tenantRequest(router, http.MethodGet, "/api/v1/todos", "globex", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, tenantRequest(router, http.MethodGet, "/api/v1/todos", "", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, tenantRequest(router, http.MethodGet, "/api/v1/todos", "initech", "", "").Code)
+
+	// Probes and docs need no tenant
+	w = tenantRequest(router, http.MethodGet, "/readyz", "", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var ready struct {
+		Status  string                 `json:"status"`
+		Tenants int                    `json:"tenants"`
+		Checks  map[string]CheckResult `json:"checks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ready))
+	assert.Equal(t, "ready", ready.Status)
+	assert.Equal(t, 2, ready.Tenants)
+	assert.Equal(t, healthStatusOK, ready.Checks["tenants"].Status)
+	assert.Equal(t, http.StatusOK, tenantRequest(router, http.MethodGet, "/healthz", "", "", "").Code)
+	assert.Equal(t, http.StatusOK, tenantRequest(router, http.MethodGet, "/openapi.json", "", "", "").Code)
+
+	w = tenantRequest(router, http.MethodGet, "/admin/tenants", "", "root", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var tenants []Tenant
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tenants))
+	require.Len(t, tenants, 2)
+	assert.Equal(t, "acme", tenants[0].ID)
+	assert.Equal(t, "ACME", tenants[0].Name)
+	assert.Equal(t, 1, tenants[0].Usage.Todos)
+	assert.Equal(t, 0, tenants[1].Usage.Todos)
+}
+
+func TestTenants_Quota(t *testing.T) {
+	_, router := setupTenantTest(t)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root",
+		`{"id":"acme","quota":{"max_todos":2,"max_todos_per_owner":1}}`).Code)
+
+	assert.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/todos", "acme", "alice", `{"description":"one"}`).Code)
+	assert.Equal(t, http.StatusForbidden, tenantRequest(router, http.MethodPost, "/todos", "acme", "alice", `{"description":"two"}`).Code)
+	assert.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/todos", "acme", "bob", `{"description":"two"}`).Code)
+	assert.Equal(t, http.StatusForbidden, tenantRequest(router, http.MethodPost, "/todos", "acme", "carol", `{"description":"three"}`).Code)
+
+	w := tenantRequest(router, http.MethodPut, "/admin/tenants/acme/quota", "", "root", `{"max_todos":0,"max_todos_per_owner":0}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/todos", "acme", "carol", `{"description":"three"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, tenantRequest(router, http.MethodPut, "/admin/tenants/acme/quota", "", "root", `{"max_todos":-1}`).Code)
+	assert.Equal(t, http.StatusNotFound, tenantRequest(router, http.MethodPut, "/admin/tenants/initech/quota", "", "root", `{}`).Code)
+}
+
+func TestTenants_NegotiatedBodies(t *testing.T) {
+	reg, router := setupTenantTest(t)
+	yamlRequest := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/yaml")
+		req.Header.Set(identityHeader, "root")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := yamlRequest(http.MethodPost, "/admin/tenants", "id: acme\nname: ACME\n")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = yamlRequest(http.MethodPut, "/admin/tenants/acme/quota", "max_todos: 5\n")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	tenant, err := reg.Tenant("acme")
+	require.NoError(t, err)
+	assert.Equal(t, "ACME", tenant.Name)
+	assert.Equal(t, 5, tenant.Quota.MaxTodos)
+}
+
+func TestTenants_SuspendAndDelete(t *testing.T) {
+	reg, router := setupTenantTest(t)
+	require.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/admin/tenants", "", "root", `{"id":"acme"}`).Code)
+	require.NoError(t, os.MkdirAll(reg.tenantDir("acme"), 0o750))
+	assert.Equal(t, http.StatusCreated, tenantRequest(router, http.MethodPost, "/todos", "acme", "", `{"description":"kept"}`).Code)
+
+	assert.Equal(t, http.StatusConflict, tenantRequest(router, http.MethodDelete, "/admin/tenants/acme", "", "root", "").Code)
+
+	w := tenantRequest(router, http.MethodPost, "/admin/tenants/acme/suspend", "", "root", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var suspended Tenant
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &suspended))
+	assert.Equal(t, tenantStatusSuspended, suspended.Status)
+	assert.NotNil(t, suspended.SuspendedAt)
+
+	w = tenantRequest(router, http.MethodGet, "/todos", "acme", "", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	var p Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, tenantSuspendedReason, p.Reason)
+
+	// Resuming keeps the data
+	require.Equal(t, http.StatusOK, tenantRequest(router, http.MethodPost, "/admin/tenants/acme/resume", "", "root", "").Code)
+	w = tenantRequest(router, http.MethodGet, "/todos", "acme", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "kept")
+
+	require.Equal(t, http.StatusOK, tenantRequest(router, http.MethodPost, "/admin/tenants/acme/suspend", "", "root", "").Code)
+	require.Equal(t, http.StatusOK, tenantRequest(router, http.MethodDelete, "/admin/tenants/acme", "", "root", "").Code)
+	assert.NoDirExists(t, reg.tenantDir("acme"))
+	assert.Equal(t, http.StatusNotFound, tenantRequest(router, http.MethodGet, "/todos", "acme", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, tenantRequest(router, http.MethodGet, "/admin/tenants/acme", "", "root", "").Code)
+}
+
+func TestTenants_DeleteStopsJobsOutsideTheLock(t *testing.T) {
+	reg, err := NewTenantRegistry(t.TempDir(), nil, tenantMgrFactory(&TodoMgr{}, 1))
+	require.NoError(t, err)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, reg.Stop(ctx))
+	}()
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+
+	jobs := reg.tenants["acme"].mgr.Jobs
+	started, release := make(chan struct{}), make(chan struct{})
+	jobs.Handle("slow", func(ctx context.Context, job Job) error {
+		close(started)
+		<-release
+		return nil
+	})
+	_, err = jobs.Enqueue("slow", nil, time.Time{})
+	require.NoError(t, err)
+	<-started
+	_, err = reg.SuspendTenant("acme")
+	require.NoError(t, err)
+
+	deleted := make(chan error, 1)
+	go func() { deleted <- reg.DeleteTenant("acme") }()
+
+	// The registry keeps serving while the job finishes, but the id is not free yet
+	require.Eventually(t, func() bool {
+		_, err := reg.Tenant("acme")
+		return errors.Is(err, ErrTenantNotFound)
+	}, time.Second, 5*time.Millisecond)
+	assert.Empty(t, reg.Tenants())
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	assert.ErrorIs(t, err, ErrTenantExists)
+
+	close(release)
+	require.NoError(t, <-deleted)
+	assert.NoDirExists(t, reg.tenantDir("acme"))
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	assert.NoError(t, err)
+}
+
+func TestTenants_CreateCleansUpWhenSaveFails(t *testing.T) {
+	dir := t.TempDir()
+	reg, err := NewTenantRegistry(dir, nil, tenantMgrFactory(&TodoMgr{}, 1))
+	require.NoError(t, err)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, reg.Stop(ctx))
+	}()
+
+	// A directory in the way of the registry file
+	blocker := filepath.Join(dir, tenantsFileName)
+	require.NoError(t, os.MkdirAll(filepath.Join(blocker, "x"), 0o750))
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.Error(t, err)
+	assert.NoDirExists(t, reg.tenantDir("acme"))
+	assert.Empty(t, reg.Tenants())
+
+	require.NoError(t, os.RemoveAll(blocker))
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+}
+
+func TestTenants_HeadersOnlyFromTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1")
+	reg, router := setupTenantTest(t)
+	_, err := reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+
+	get := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/todos", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(tenantHeader, "acme")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:4000"))
+	assert.Equal(t, http.StatusBadRequest, get("203.0.113.7:4000"), "clients cannot pick the tenant")
+}
+
+func TestTenants_Persisted(t *testing.T) {
+	dir := t.TempDir()
+	keys := testKeyring(t, "k1")
+	reg, err := NewTenantRegistry(dir, keys, inMemoryTenants)
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "acme", Quota: &TenantQuota{MaxTodos: 5}})
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "globex"})
+	require.NoError(t, err)
+	_, err = reg.SuspendTenant("globex")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, tenantsFileName))
+	require.NoError(t, err)
+	assert.True(t, isSealed(data))
+
+	reloaded, err := NewTenantRegistry(dir, keys, inMemoryTenants)
+	require.NoError(t, err)
+	acme, err := reloaded.Tenant("acme")
+	require.NoError(t, err)
+	assert.Equal(t, 5, acme.Quota.MaxTodos)
+	_, err = reloaded.resolve("globex")
+	assert.ErrorIs(t, err, ErrTenantSuspended)
+}
+
+func TestTenants_RekeyAndReload(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	dir := t.TempDir()
+	stop := func(reg *TenantRegistry) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, reg.Stop(ctx))
+	}
+	reg, err := NewTenantRegistry(dir, testKeyring(t, "a"), tenantMgrFactory(&TodoMgr{Keys: testKeyring(t, "a")}, 1))
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+	acme, err := reg.resolve("acme")
+	require.NoError(t, err)
+	_, err = acme.mgr.Jobs.Enqueue("customer.call", nil, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	stop(reg)
+
+	// Rotate to key b and rekey through the API
+	rotated := testKeyring(t, "b", "a")
+	reg, err = NewTenantRegistry(dir, rotated, tenantMgrFactory(&TodoMgr{Keys: rotated}, 1))
+	require.NoError(t, err)
+	w := tenantRequest(newTenantRouter(reg), http.MethodPost, "/admin/rekey", "", "root", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var result RekeyResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, RekeyResult{Rewrapped: 2}, result)
+	stop(reg)
+
+	// The registry and the tenants load without the old key
+	b := testKeyring(t, "b")
+	reg, err = NewTenantRegistry(dir, b, tenantMgrFactory(&TodoMgr{Keys: b}, 1))
+	require.NoError(t, err)
+	defer stop(reg)
+	acme, err = reg.resolve("acme")
+	require.NoError(t, err)
+	assert.Len(t, acme.mgr.Jobs.Jobs(""), 1)
+}
+
+func TestTenants_StorageDirectories(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root")
+	reg, err := NewTenantRegistry(t.TempDir(), nil, tenantMgrFactory(&TodoMgr{}, 1))
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, reg.Stop(ctx))
+	}()
+
+	router := newTenantRouter(reg)
+	w := tenantRequest(router, http.MethodPost, "/admin/backup", "acme", "root", "")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.DirExists(t, filepath.Join(reg.tenantDir("acme"), "attachments"))
+	backups, err := os.ReadDir(filepath.Join(reg.tenantDir("acme"), "backups"))
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+}
+
+func TestTenants_Sources(t *testing.T) {
+	token := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+	reg := &TenantRegistry{
+		Sources: []string{tenantSourceHeader, tenantSourceSubdomain, tenantSourceClaim},
+		Domain:  "todo.example.com",
+		Claim:   "team",
+	}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		host    string
+		want    string
+	}{
+		{"header first", map[string]string{tenantHeader: " ACME ", accessTokenHeader: token(`{"team":"globex"}`)}, "initech.todo.example.com", "acme"},
+		{"subdomain", nil, "initech.todo.example.com:8080", "initech"},
+		{"nested subdomain", nil, "a.b.todo.example.com", ""},
+		{"other domain", nil, "initech.example.org", ""},
+		{"claim", map[string]string{accessTokenHeader: token(`{"team":"globex"}`)}, "todo.example.com", "globex"},
+		{"bearer claim", map[string]string{accessTokenHeader: "Bearer " + token(`{"team":"globex"}`)}, "", "globex"},
+		{"other claim", map[string]string{accessTokenHeader: token(`{"tenant":"globex"}`)}, "", ""},
+		{"not a token", map[string]string{accessTokenHeader: "opaque"}, "", ""},
+	}
+	for _, tt := range tests {
+		id, err := reg.tenantOf(func(name string) string { return tt.headers[name] }, tt.host)
+		if tt.want == "" {
+			assert.ErrorIs(t, err, ErrNoTenant, tt.name)
+			continue
+		}
+		assert.Equal(t, tt.want, id, tt.name)
+	}
+
+	t.Setenv("TENANT_SOURCES", "header, jwt")
+	_, err := tenantSourcesFromEnv()
+	assert.Error(t, err)
+}
+
+func TestTenants_GRPC(t *testing.T) {
+	reg, err := NewTenantRegistry(t.TempDir(), nil, inMemoryTenants)
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "acme"})
+	require.NoError(t, err)
+	_, err = reg.CreateTenant(TenantInput{ID: "globex"})
+	require.NoError(t, err)
+
+	// The test client is the proxy
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := newTenantGRPCServer(reg, []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := todopb.NewTodoServiceClient(conn)
+
+	acme := metadata.AppendToOutgoingContext(context.Background(), strings.ToLower(tenantHeader), "acme")
+	_, err = client.CreateTodo(acme, &todopb.CreateTodoRequest{Description: "acme only"})
+	require.NoError(t, err)
+
+	globex := metadata.AppendToOutgoingContext(context.Background(), strings.ToLower(tenantHeader), "globex")
+	list, err := client.ListTodos(globex, &todopb.ListTodosRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, list.GetTodos())
+
+	_, err = client.ListTodos(context.Background(), &todopb.ListTodosRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Streams are resolved too
+	ctx, cancel := context.WithTimeout(acme, 5*time.Second)
+	defer cancel()
+	stream, err := client.WatchTodos(ctx, &todopb.WatchTodosRequest{})
+	require.NoError(t, err)
+	acmeMgr, err := reg.resolve("acme")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		acmeMgr.mgr.mu.RLock()
+		defer acmeMgr.mgr.mu.RUnlock()
+		return len(acmeMgr.mgr.subscribers) == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err = client.CreateTodo(globex, &todopb.CreateTodoRequest{Description: "not for acme"})
+	require.NoError(t, err)
+	_, err = client.CreateTodo(acme, &todopb.CreateTodoRequest{Description: "for acme"})
+	require.NoError(t, err)
+	ev, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "for acme", ev.GetTodo().GetDescription())
+
+	_, err = reg.SuspendTenant("globex")
+	require.NoError(t, err)
+	_, err = client.ListTodos(globex, &todopb.ListTodosRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Other peers cannot name a tenant
+	untrusted := bufconn.Listen(1024 * 1024)
+	go server.Serve(untrusted)
+	untrustedConn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return untrusted.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { untrustedConn.Close() })
+	_, err = todopb.NewTodoServiceClient(untrustedConn).ListTodos(acme, &todopb.ListTodosRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTenants_ToggleMaintenanceKeepsSuspendedJobsPaused(t *testing.T) {
+	reg, err := NewTenantRegistry(t.TempDir(), nil, tenantMgrFactory(&TodoMgr{}, 1))
+	require.NoError(t, err)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, reg.Stop(ctx))
+	}()
+	for _, id := range []string{"acme", "globex"} {
+		_, err = reg.CreateTenant(TenantInput{ID: id})
+		require.NoError(t, err)
+	}
+	_, err = reg.SuspendTenant("globex")
+	require.NoError(t, err)
+
+	paused := func(id string) bool {
+		q := reg.tenants[id].mgr.Jobs
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.paused
+	}
+	require.True(t, reg.ToggleMaintenance())
+	assert.True(t, paused("acme"))
+	assert.True(t, paused("globex"))
+
+	require.False(t, reg.ToggleMaintenance())
+	assert.False(t, paused("acme"))
+	assert.True(t, paused("globex"))
+	assert.False(t, reg.tenants["globex"].mgr.Maintenance().Enabled)
+
+	_, err = reg.ResumeTenant("globex")
+	require.NoError(t, err)
+	assert.False(t, paused("globex"))
+}
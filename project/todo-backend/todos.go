@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The todo logic lives here, independent of the transport. Both the gin handlers
+// and the gRPC server call these methods so validation cannot differ between them.
+
+const TODOMAXLENGTTH = 140
+
+// ErrTodoNotFound is returned when no todo has the given UUID.
+var ErrTodoNotFound = errors.New("todo not found")
+
+// ErrQuotaExceeded is returned when an owner already has the maximum number of todos.
+var ErrQuotaExceeded = errors.New("todo quota exceeded")
+
+// ValidationError reports a single invalid field of a request.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// Todo represents a single todo item.
+type Todo struct {
+	UUID        string     `json:"uuid"`
+	Description string     `json:"description"`
+	Owner       string     `json:"owner,omitempty"` // authenticated creator, empty for anonymous
+	State       string     `json:"state"`           // workflow state
+	Done        bool       `json:"done"`            // whether State is a done state
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ChangedAt   time.Time  `json:"changed_at,omitempty"`
+
+	CommentCount int `json:"comment_count"`
+
+	// Recurring todos carry an RRULE. RecurrenceStart is the DTSTART of the series and
+	// NextUUID points to the occurrence generated when this one was completed.
+	RRule           string     `json:"rrule,omitempty"`
+	RecurrenceStart *time.Time `json:"recurrence_start,omitempty"`
+	NextUUID        string     `json:"next_uuid,omitempty"`
+
+	// Links are the URLs of the description with their page previews.
+	Links []LinkPreview `json:"links,omitempty"`
+}
+
+// TodoInput holds the fields of a new todo.
+type TodoInput struct {
+	Description string
+	DueAt       *time.Time
+	RRule       string
+}
+
+// TodoPatch holds the fields to change in a todo. Nil fields are left as they are.
+// An empty RRule stops the recurrence.
+type TodoPatch struct {
+	Description *string
+	Done        *bool
+	DueAt       *time.Time
+	RRule       *string
+}
+
+// TodoEventType tells what happened to a todo.
+type TodoEventType string
+
+const (
+	TodoCreated TodoEventType = "created"
+	TodoUpdated TodoEventType = "updated"
+	TodoDeleted TodoEventType = "deleted"
+)
+
+// TodoEvent is published to subscribers after every change.
+// For deletions Todo holds the last known state.
+type TodoEvent struct {
+	Type TodoEventType
+	Todo Todo
+}
+
+// TodoMgr holds in-memory todos and a mutex for concurrency.
+// The zero value is ready to use.
+type TodoMgr struct {
+	mu          sync.RWMutex
+	todosSorted []Todo
+	subscribers map[chan TodoEvent]struct{}
+	comments    map[string][]Comment // by todo UUID, oldest first
+	auditTrail  []AuditEvent
+	auditBase   []Todo // the todos before the oldest event of auditTrail
+	templates   []Template
+	shares      []Share
+
+	// MaxTodosPerOwner caps how many todos one owner can have. Anonymous todos share
+	// one quota. Zero means unlimited.
+	MaxTodosPerOwner int
+
+	// MaxTodos caps how many todos there are in total, e.g. for a tenant. Zero means
+	// unlimited.
+	MaxTodos int
+
+	// MaxAuditEvents caps how many events the audit trail keeps. Zero means unlimited.
+	MaxAuditEvents int
+
+	// Attachments stores files attached to todos. Nil disables attachments.
+	Attachments *AttachmentStore
+
+	// Workflow defines the states of todos. Nil means defaultWorkflow.
+	Workflow *Workflow
+
+	// Previews fetches the pages of links in descriptions. Nil disables link previews.
+	Previews *LinkPreviewer
+
+	// Jobs runs background work. Nil runs it in plain goroutines, without persistence
+	// or retries.
+	Jobs *JobQueue
+
+	// Backups stores snapshots of the todos. Nil disables backups.
+	Backups *BackupStore
+
+	// Keys encrypts the files of Attachments, Jobs and Backups. Nil stores them unencrypted.
+	Keys *Keyring
+
+	// maintenance is set while todo-backend is read-only
+	maintenance atomic.Pointer[MaintenanceStatus]
+}
+
+// normalizeDescription trims the description and checks it against the length limits.
+func normalizeDescription(description string) (string, error) {
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return "", &ValidationError{Field: "description", Message: "is required"}
+	}
+	if len(description) > TODOMAXLENGTTH {
+		return "", &ValidationError{Field: "description", Message: "exceeds maximum length"}
+	}
+	return description, nil
+}
+
+// List returns a copy of all todos in creation order.
+func (s *TodoMgr) List() []Todo {
+	defer storeTimer("list").ObserveDuration()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// return a copy to avoid racey access by callers
+	out := make([]Todo, len(s.todosSorted))
+	copy(out, s.todosSorted)
+	return out
+}
+
+// Get returns the todo with the given UUID.
+func (s *TodoMgr) Get(UUID string) (Todo, error) {
+	defer storeTimer("get").ObserveDuration()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if i := s.indexOf(UUID); i >= 0 {
+		return s.todosSorted[i], nil
+	}
+	return Todo{}, ErrTodoNotFound
+}
+
+// Create validates the input and stores a new todo for the owner.
+func (s *TodoMgr) Create(owner string, in TodoInput) (Todo, error) {
+	defer storeTimer("create").ObserveDuration()
+
+	description, err := normalizeDescription(in.Description)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	now := time.Now().UTC()
+	t := Todo{
+		UUID:        uuid.New().String(),
+		Description: description,
+		Owner:       owner,
+		State:       s.workflow().initialState(),
+		DueAt:       utcPtr(in.DueAt),
+		CreatedAt:   now,
+		ChangedAt:   now,
+		Links:       s.previewLinks(description, nil),
+	}
+	if in.RRule != "" {
+		if err := setRecurrence(&t, in.RRule, now); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	s.mu.Lock()
+	if s.overQuota(owner, 1) {
+		s.mu.Unlock()
+		return Todo{}, ErrQuotaExceeded
+	}
+	s.todosSorted = append(s.todosSorted, t)
+	s.publish(TodoEvent{Type: TodoCreated, Todo: t})
+	s.record(now, owner, AuditTodoCreated, t, nil)
+	s.mu.Unlock()
+
+	s.requestPreviews(t)
+	return t, nil
+}
+
+// Update applies the patch to the todo with the given UUID on behalf of actor.
+// Completing a recurring todo creates its next occurrence.
+func (s *TodoMgr) Update(actor, UUID string, p TodoPatch) (Todo, error) {
+	defer storeTimer("update").ObserveDuration()
+
+	t, err := s.update(actor, UUID, p)
+	if err != nil {
+		return Todo{}, err
+	}
+	s.requestPreviews(t)
+	return t, nil
+}
+
+// update is Update without the link previews, which are requested once s.mu is released.
+func (s *TodoMgr) update(actor, UUID string, p TodoPatch) (Todo, error) {
+	if p == (TodoPatch{}) {
+		return Todo{}, &ValidationError{Field: "description", Message: "is required when no other field is given"}
+	}
+	var description string
+	if p.Description != nil {
+		var err error
+		if description, err = normalizeDescription(*p.Description); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(UUID)
+	if i < 0 {
+		return Todo{}, ErrTodoNotFound
+	}
+	// Work on a copy so a failing patch leaves the todo untouched
+	t := s.todosSorted[i]
+	now := time.Now().UTC()
+
+	if p.Description != nil {
+		t.Description = description
+		t.Links = s.previewLinks(description, t.Links)
+	}
+	if p.DueAt != nil {
+		t.DueAt = utcPtr(p.DueAt)
+	}
+	if p.RRule != nil {
+		if *p.RRule == "" {
+			t.RRule, t.RecurrenceStart = "", nil
+		} else if err := setRecurrence(&t, *p.RRule, now); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	// Completing and reopening are transitions to the done and initial states
+	var next *Todo
+	if p.Done != nil && *p.Done != t.Done {
+		target := s.workflow().initialState()
+		if *p.Done {
+			target = s.workflow().doneState()
+		}
+		var err error
+		if next, err = s.transitionTo(&t, target, now); err != nil {
+			return Todo{}, err
+		}
+	}
+
+	t.ChangedAt = now
+	s.commitUpdate(actor, i, t, next, now)
+	return t, nil
+}
+
+// commitUpdate stores the updated todo at position i, and the next occurrence if
+// completing it created one.
+// Caller must hold s.mu for writing.
+func (s *TodoMgr) commitUpdate(actor string, i int, t Todo, next *Todo, now time.Time) {
+	s.todosSorted[i] = t
+	s.publish(TodoEvent{Type: TodoUpdated, Todo: t})
+	s.record(now, actor, AuditTodoUpdated, t, nil)
+
+	// Design choice: generated occurrences do not count against MaxTodosPerOwner, so
+	// completing a recurring todo never fails because of the quota.
+	if next != nil {
+		s.todosSorted = append(s.todosSorted, *next)
+		s.publish(TodoEvent{Type: TodoCreated, Todo: *next})
+		s.record(now, actor, AuditTodoCreated, *next, nil)
+	}
+}
+
+// Delete removes the todo with the given UUID, its comments and its attachments on behalf of actor, and returns it.
+func (s *TodoMgr) Delete(actor, UUID string) (Todo, error) {
+	defer storeTimer("delete").ObserveDuration()
+
+	t, err := s.remove(actor, UUID)
+	if err != nil {
+		return Todo{}, err
+	}
+
+	// Files are removed outside the lock; failing to remove them only leaves garbage on the volume
+	if s.Attachments != nil {
+		if err := s.Attachments.RemoveAll(UUID); err != nil {
+			log.Printf("Failed to remove attachments of todo %s: %v", UUID, err)
+		}
+	}
+	return t, nil
+}
+
+// remove takes the todo and its comments out of the store and publishes the deletion.
+func (s *TodoMgr) remove(actor, UUID string) (Todo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(UUID)
+	if i < 0 {
+		return Todo{}, ErrTodoNotFound
+	}
+	t := s.todosSorted[i]
+	// Remove the todo from the slice
+	s.todosSorted = append(s.todosSorted[:i], s.todosSorted[i+1:]...)
+	delete(s.comments, UUID)
+	s.publish(TodoEvent{Type: TodoDeleted, Todo: t})
+	s.record(time.Now().UTC(), actor, AuditTodoDeleted, t, nil)
+
+	return t, nil
+}
+
+// Subscribe returns a channel receiving every change made after the call,
+// and a function that must be called to stop the subscription.
+//
+// Design choice: a subscriber that does not keep up loses events instead of
+// blocking the writers. The buffer absorbs short bursts.
+func (s *TodoMgr) Subscribe() (<-chan TodoEvent, func()) {
+	ch := make(chan TodoEvent, 16)
+
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan TodoEvent]struct{})
+	}
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// publish sends the event to all subscribers without blocking.
+// Caller must hold s.mu for writing.
+func (s *TodoMgr) publish(ev TodoEvent) {
+	for ch := range s.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// subscriber is too slow, drop the event
+		}
+	}
+}
+
+// overQuota reports whether n more todos of the owner would exceed MaxTodos or
+// MaxTodosPerOwner.
+// Caller must hold s.mu.
+func (s *TodoMgr) overQuota(owner string, n int) bool {
+	return (s.MaxTodos > 0 && len(s.todosSorted)+n > s.MaxTodos) ||
+		(s.MaxTodosPerOwner > 0 && s.countOwnedBy(owner)+n > s.MaxTodosPerOwner)
+}
+
+// SetQuota replaces MaxTodos and MaxTodosPerOwner while the TodoMgr is in use.
+func (s *TodoMgr) SetQuota(maxTodos, maxTodosPerOwner int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MaxTodos, s.MaxTodosPerOwner = maxTodos, maxTodosPerOwner
+}
+
+// Count returns the number of todos.
+func (s *TodoMgr) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.todosSorted)
+}
+
+// countOwnedBy returns how many todos the owner has.
+// Caller must hold s.mu.
+func (s *TodoMgr) countOwnedBy(owner string) int {
+	n := 0
+	for _, t := range s.todosSorted {
+		if t.Owner == owner {
+			n++
+		}
+	}
+	return n
+}
+
+// utcPtr returns a copy of the time in UTC, or nil.
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// indexOf returns the position of the todo in todosSorted, or -1.
+// Caller must hold s.mu.
+func (s *TodoMgr) indexOf(UUID string) int {
+	for i, t := range s.todosSorted {
+		if t.UUID == UUID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Todos move through the states of a workflow, e.g. backlog → in-progress → review → done.
+// Only the transitions listed in the workflow are allowed. States marked done complete the
+// todo, so PATCH {"done": true} is a transition to the first done state, and
+// {"done": false} one back to the initial state.
+//
+// The workflow is read from the JSON file named by WORKFLOW_CONFIG (in the cluster a
+// ConfigMap), falling back to defaultWorkflow:
+//
+//	{
+//	  "states": [{"name": "backlog"}, {"name": "in-progress", "wip_limit": 3}, {"name": "done", "done": true}],
+//	  "transitions": {"backlog": ["in-progress"], "in-progress": ["backlog", "done"], "done": ["backlog"]}
+//	}
+
+// ErrTransitionNotAllowed is returned when the workflow has no transition between two states.
+var ErrTransitionNotAllowed = errors.New("transition not allowed")
+
+// WorkflowState is a column of the board. The first state is where new todos start.
+type WorkflowState struct {
+	Name string `json:"name"`
+	// WIPLimit is the number of todos the state should hold at most. It is reported
+	// on the board, not enforced. Zero means no limit.
+	WIPLimit int  `json:"wip_limit,omitempty"`
+	Done     bool `json:"done,omitempty"`
+}
+
+// Workflow lists the states in board order and the allowed transitions between them.
+type Workflow struct {
+	States      []WorkflowState     `json:"states"`
+	Transitions map[string][]string `json:"transitions"`
+}
+
+var defaultWorkflow = &Workflow{
+	States: []WorkflowState{
+		{Name: "backlog"},
+		{Name: "in-progress"},
+		{Name: "review"},
+		{Name: "done", Done: true},
+	},
+	Transitions: map[string][]string{
+		"backlog":     {"in-progress", "done"},
+		"in-progress": {"backlog", "review", "done"},
+		"review":      {"in-progress", "done"},
+		"done":        {"backlog"},
+	},
+}
+
+// loadWorkflow reads and validates a workflow from a JSON file.
+func loadWorkflow(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var wf Workflow
+	if err := json.Unmarshal(data, &wf); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := wf.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &wf, nil
+}
+
+// workflowFromEnv loads the workflow named by WORKFLOW_CONFIG, or returns the default one.
+func workflowFromEnv() (*Workflow, error) {
+	path := os.Getenv("WORKFLOW_CONFIG")
+	if path == "" {
+		return defaultWorkflow, nil
+	}
+	return loadWorkflow(path)
+}
+
+func (wf *Workflow) validate() error {
+	if len(wf.States) == 0 {
+		return errors.New("workflow has no states")
+	}
+	hasDone := false
+	for i, st := range wf.States {
+		if strings.TrimSpace(st.Name) == "" {
+			return fmt.Errorf("state %d has no name", i)
+		}
+		if wf.state(st.Name) != &wf.States[i] {
+			return fmt.Errorf("state %q is listed twice", st.Name)
+		}
+		if st.WIPLimit < 0 {
+			return fmt.Errorf("state %q has a negative WIP limit", st.Name)
+		}
+		hasDone = hasDone || st.Done
+	}
+	if !hasDone {
+		return errors.New("workflow has no done state")
+	}
+	if wf.States[0].Done {
+		return errors.New("the initial state cannot be a done state")
+	}
+	for from, targets := range wf.Transitions {
+		if wf.state(from) == nil {
+			return fmt.Errorf("transition from unknown state %q", from)
+		}
+		for _, to := range targets {
+			if wf.state(to) == nil {
+				return fmt.Errorf("transition from %q to unknown state %q", from, to)
+			}
+		}
+	}
+	return nil
+}
+
+// state returns the state with the given name, or nil.
+func (wf *Workflow) state(name string) *WorkflowState {
+	for i := range wf.States {
+		if wf.States[i].Name == name {
+			return &wf.States[i]
+		}
+	}
+	return nil
+}
+
+func (wf *Workflow) initialState() string {
+	return wf.States[0].Name
+}
+
+// doneState is where PATCH {"done": true} moves a todo.
+func (wf *Workflow) doneState() string {
+	for _, st := range wf.States {
+		if st.Done {
+			return st.Name
+		}
+	}
+	return ""
+}
+
+// allowed reports whether a todo may move from one state to another.
+func (wf *Workflow) allowed(from, to string) bool {
+	for _, target := range wf.Transitions[from] {
+		if target == to {
+			return true
+		}
+	}
+	return false
+}
+
+// workflow returns the configured workflow or the default one.
+func (s *TodoMgr) workflow() *Workflow {
+	if s.Workflow == nil {
+		return defaultWorkflow
+	}
+	return s.Workflow
+}
+
+// moveTo puts t into the state, completing or reopening it as needed. It returns the next
+// occurrence when completing a recurring todo creates one.
+// Caller must have checked that the transition is allowed.
+func (s *TodoMgr) moveTo(t *Todo, state string, now time.Time) *Todo {
+	t.State = state
+	done := s.workflow().state(state).Done
+	if done == t.Done {
+		return nil
+	}
+
+	t.Done = done
+	if !done {
+		t.CompletedAt = nil
+		return nil
+	}
+	t.CompletedAt = &now
+	// NextUUID is kept when a todo is reopened, so completing it again does not
+	// generate a second occurrence.
+	if t.RRule == "" || t.NextUUID != "" {
+		return nil
+	}
+	next := nextOccurrence(t, s.workflow().initialState(), now)
+	if next != nil {
+		t.NextUUID = next.UUID
+	}
+	return next
+}
+
+// transitionTo checks that t may move to the state and moves it.
+// Caller must hold s.mu for writing.
+func (s *TodoMgr) transitionTo(t *Todo, state string, now time.Time) (*Todo, error) {
+	wf := s.workflow()
+	if wf.state(state) == nil {
+		return nil, &ValidationError{Field: "state", Message: "is not a state of the workflow"}
+	}
+	if t.State == state {
+		return nil, nil
+	}
+	if !wf.allowed(t.State, state) {
+		return nil, fmt.Errorf("%w from %s to %s", ErrTransitionNotAllowed, t.State, state)
+	}
+	return s.moveTo(t, state, now), nil
+}
+
+// Transition moves the todo to another state of the workflow on behalf of actor.
+func (s *TodoMgr) Transition(actor, UUID, state string) (Todo, error) {
+	defer storeTimer("transition").ObserveDuration()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.indexOf(UUID)
+	if i < 0 {
+		return Todo{}, ErrTodoNotFound
+	}
+	t := s.todosSorted[i]
+	now := time.Now().UTC()
+
+	next, err := s.transitionTo(&t, state, now)
+	if err != nil {
+		return Todo{}, err
+	}
+	t.ChangedAt = now
+	s.commitUpdate(actor, i, t, next, now)
+	return t, nil
+}
+
+// BoardColumn is one state of the board with its todos.
+type BoardColumn struct {
+	State     string `json:"state"`
+	WIPLimit  int    `json:"wip_limit,omitempty"`
+	Count     int    `json:"count"`
+	OverLimit bool   `json:"over_limit"`
+	Todos     []Todo `json:"todos"`
+}
+
+// Board groups the todos by state, in workflow order.
+func (s *TodoMgr) Board() []BoardColumn {
+	wf := s.workflow()
+	columns := make([]BoardColumn, len(wf.States))
+	index := make(map[string]int, len(wf.States))
+	for i, st := range wf.States {
+		columns[i] = BoardColumn{State: st.Name, WIPLimit: st.WIPLimit, Todos: []Todo{}}
+		index[st.Name] = i
+	}
+
+	for _, t := range s.List() {
+		i, ok := index[t.State]
+		if !ok {
+			// Todos keep their state if the workflow changes; show them where new todos start
+			i = 0
+		}
+		columns[i].Todos = append(columns[i].Todos, t)
+	}
+	for i := range columns {
+		columns[i].Count = len(columns[i].Todos)
+		columns[i].OverLimit = columns[i].WIPLimit > 0 && columns[i].Count > columns[i].WIPLimit
+	}
+	return columns
+}
+
+// transitionTodo moves a todo to another workflow state.
+// @param uuid path string true "UUID of the todo"
+// @param to body string true "Target state"
+// @success 200 {object} Todo
+// @failure 400 {object} Problem
+// @failure 404 {object} Problem
+// @failure 409 {object} Problem
+func (s *TodoMgr) transitionTodo(c *gin.Context) {
+	var req struct {
+		To string `json:"to"`
+	}
+	if !bindBody(c, &req) {
+		return
+	}
+
+	t, err := s.Transition(requestIdentity(c), strings.TrimSpace(c.Param("uuid")), strings.TrimSpace(req.To))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	renderTodo(c, http.StatusOK, t)
+}
+
+// getWorkflow returns the states and allowed transitions.
+// @success 200 {object} Workflow
+func (s *TodoMgr) getWorkflow(c *gin.Context) {
+	c.JSON(http.StatusOK, s.workflow())
+}
+
+// getBoard returns the todos grouped by workflow state, with the WIP limit of each column.
+// @success 200 {object} map[string][]BoardColumn
+func (s *TodoMgr) getBoard(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"columns": s.Board()})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func transition(router *gin.Engine, UUID, to string) *httptest.ResponseRecorder {
+	return apiRequest(router, http.MethodPost, "/todos/"+UUID+"/transition", "", `{"to":"`+to+`"}`)
+}
+
+func TestTransition_FollowsWorkflow(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("", TodoInput{Description: "ship it"})
+	require.NoError(t, err)
+	assert.Equal(t, "backlog", todo.State)
+
+	// backlog -> review skips in-progress
+	w := transition(router, todo.UUID, "review")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+
+	for _, state := range []string{"in-progress", "review"} {
+		w = transition(router, todo.UUID, state)
+		require.Equal(t, http.StatusOK, w.Code, state)
+		assert.Equal(t, state, decodeTodo(t, w).State)
+		assert.False(t, decodeTodo(t, w).Done)
+	}
+
+	w = transition(router, todo.UUID, "done")
+	require.Equal(t, http.StatusOK, w.Code)
+	done := decodeTodo(t, w)
+	assert.True(t, done.Done)
+	assert.NotNil(t, done.CompletedAt)
+
+	// PATCH done=false reopens into the initial state
+	w = patchTodo(router, todo.UUID, `{"done":false}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "backlog", decodeTodo(t, w).State)
+	assert.Nil(t, decodeTodo(t, w).CompletedAt)
+
+	w = transition(router, todo.UUID, "nowhere")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = transition(router, "non-existent-uuid", "done")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTransitionTodo_NegotiatedBodies(t *testing.T) {
+	s := &TodoMgr{}
+	router := setupRouter(s)
+	todo, err := s.Create("", TodoInput{Description: "any format"})
+	require.NoError(t, err)
+	path := "/todos/" + todo.UUID + "/transition"
+
+	w := negotiationRequest(router, http.MethodPost, path, "application/yaml", "", "to: in-progress\n")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "in-progress", decodeTodo(t, w).State)
+
+	w = negotiationRequest(router, http.MethodPost, path, "text/csv", "", "to\nreview\n")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "review", decodeTodo(t, w).State)
+
+	w = negotiationRequest(router, http.MethodPost, path, "application/xml", "", "<to>done</to>")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestPatchTodo_DoneRespectsWorkflow(t *testing.T) {
+	s := &TodoMgr{Workflow: &Workflow{
+		States: []WorkflowState{{Name: "todo"}, {Name: "doing"}, {Name: "done", Done: true}},
+		Transitions: map[string][]string{
+			"todo":  {"doing"},
+			"doing": {"done"},
+		},
+	}}
+	router := setupRouter(s)
+	todo, err := s.Create("", TodoInput{Description: "strict"})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusConflict, patchTodo(router, todo.UUID, `{"done":true}`).Code)
+	require.Equal(t, http.StatusOK, transition(router, todo.UUID, "doing").Code)
+	assert.Equal(t, http.StatusOK, patchTodo(router, todo.UUID, `{"done":true}`).Code)
+}
+
+func TestGetBoard_GroupsByStateWithWIPLimits(t *testing.T) {
+	wf := *defaultWorkflow
+	wf.States = []WorkflowState{{Name: "backlog"}, {Name: "in-progress", WIPLimit: 1}, {Name: "review"}, {Name: "done", Done: true}}
+	s := &TodoMgr{Workflow: &wf}
+	router := setupRouter(s)
+
+	for _, description := range []string{"a", "b", "c"} {
+		todo, err := s.Create("", TodoInput{Description: description})
+		require.NoError(t, err)
+		if description != "c" {
+			_, err = s.Transition("", todo.UUID, "in-progress")
+			require.NoError(t, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/board", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var board struct {
+		Columns []BoardColumn `json:"columns"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &board))
+	require.Len(t, board.Columns, 4)
+
+	assert.Equal(t, "backlog", board.Columns[0].State)
+	assert.Equal(t, 1, board.Columns[0].Count)
+	assert.Equal(t, "in-progress", board.Columns[1].State)
+	assert.Equal(t, 2, board.Columns[1].Count)
+	assert.Equal(t, 1, board.Columns[1].WIPLimit)
+	assert.True(t, board.Columns[1].OverLimit)
+	assert.Empty(t, board.Columns[3].Todos)
+}
+
+func TestLoadWorkflow(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "workflow.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	wf, err := loadWorkflow(write(`{
+		"states": [{"name": "todo"}, {"name": "doing", "wip_limit": 2}, {"name": "done", "done": true}],
+		"transitions": {"todo": ["doing"], "doing": ["todo", "done"]}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "todo", wf.initialState())
+	assert.Equal(t, "done", wf.doneState())
+	assert.True(t, wf.allowed("doing", "done"))
+	assert.False(t, wf.allowed("todo", "done"))
+
+	for _, bad := range []string{
+		`{"states": []}`,
+		`{"states": [{"name": "todo"}, {"name": "todo", "done": true}]}`,
+		`{"states": [{"name": "todo"}]}`,
+		`{"states": [{"name": "done", "done": true}]}`,
+		`{"states": [{"name": "todo"}, {"name": "done", "done": true}], "transitions": {"todo": ["later"]}}`,
+	} {
+		_, err := loadWorkflow(write(bad))
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiPrefix is the version of the todo routes todoctl uses. v2 adds overdue to todos.
+const apiPrefix = "/api/v2"
+
+// Todo is a todo as todo-backend returns it. Only the fields todoctl shows are decoded;
+// JSON output passes the responses through as they are.
+type Todo struct {
+	UUID        string     `json:"uuid"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	Done        bool       `json:"done"`
+	Overdue     bool       `json:"overdue"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	RRule       string     `json:"rrule,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// TodoInput is the body of a new todo.
+type TodoInput struct {
+	Description string     `json:"description"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	RRule       string     `json:"rrule,omitempty"`
+}
+
+// TodoPatch holds the fields to change in a todo. Nil fields are left as they are.
+type TodoPatch struct {
+	Description *string    `json:"description,omitempty"`
+	Done        *bool      `json:"done,omitempty"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	RRule       *string    `json:"rrule,omitempty"`
+}
+
+// TodoChangeEvent is a change in the feed of GET /todos/changes.
+type TodoChangeEvent struct {
+	Seq    int64     `json:"seq"`
+	At     time.Time `json:"at"`
+	Type   string    `json:"type"`   // created, updated or deleted
+	Todo   Todo      `json:"todo"`   // after the change, or before the deletion
+	Fields []string  `json:"fields"` // for updates, the fields that changed
+}
+
+// TodoChanges is a page of the change feed.
+type TodoChanges struct {
+	Changes []TodoChangeEvent `json:"changes"`
+	Next    int64             `json:"next"`
+	More    bool              `json:"more"`
+	Missed  bool              `json:"missed"`
+}
+
+// FieldError describes a validation failure of a single request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is the RFC 7807 problem details todo-backend reports errors with.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// APIError is an error response of todo-backend.
+type APIError struct {
+	Status  int
+	Problem Problem
+}
+
+func (e *APIError) Error() string {
+	msg := e.Problem.Detail
+	if msg == "" {
+		msg = e.Problem.Title
+	}
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	return fmt.Sprintf("%s (%d)", msg, e.Status)
+}
+
+// ExitCode tells not found, invalid requests and server errors apart.
+func (e *APIError) ExitCode() int {
+	switch {
+	case e.Status == http.StatusNotFound:
+		return exitNotFound
+	case e.Status == http.StatusBadRequest || e.Status == http.StatusUnprocessableEntity:
+		return exitValidation
+	case e.Status >= 500:
+		return exitServer
+	default:
+		return exitError
+	}
+}
+
+// Client calls the REST API of todo-backend.
+type Client struct {
+	BaseURL string
+	// Token is sent as a bearer token, for the authenticating proxy in front of todo-backend.
+	Token string
+	// Tenant is sent in X-Tenant-ID when set. todo-backend only honours it when the
+	// authenticating proxy passes it on; the ingress strips it otherwise.
+	Tenant string
+	HTTP   *http.Client
+}
+
+// NewClient returns a client of the todo-backend of the config.
+func NewClient(cfg Config) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(cfg.URL, "/"),
+		Token:   cfg.Token,
+		Tenant:  cfg.Tenant,
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// request sends a request, with the body as JSON unless it is nil, and returns the
+// response if it succeeded.
+func (c *Client) request(ctx context.Context, method, path string, query url.Values, body any, header http.Header) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.Tenant)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &unreachableError{err}
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		apiErr := &APIError{Status: resp.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &apiErr.Problem) != nil {
+			apiErr.Problem = Problem{Detail: strings.TrimSpace(string(data))}
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// call sends a JSON request and decodes the JSON response into out, unless it is nil.
+func (c *Client) call(ctx context.Context, method, path string, query url.Values, body, out any, header http.Header) error {
+	resp, err := c.request(ctx, method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("cannot read the response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// List returns the todos in creation order, along with each of them as it was sent.
+func (c *Client) List(ctx context.Context) ([]Todo, []json.RawMessage, error) {
+	data, err := c.Export(ctx, "application/json")
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("cannot read the todos: %w", err)
+	}
+	todos := make([]Todo, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal(r, &todos[i]); err != nil {
+			return nil, nil, fmt.Errorf("cannot read the todos: %w", err)
+		}
+	}
+	return todos, raw, nil
+}
+
+// Export returns all todos in the media type.
+func (c *Client) Export(ctx context.Context, mediaType string) ([]byte, error) {
+	resp, err := c.request(ctx, http.MethodGet, apiPrefix+"/todos", nil, nil, http.Header{"Accept": {mediaType}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Create adds a todo. A non-empty idempotency key makes retries of the same todo safe.
+func (c *Client) Create(ctx context.Context, in TodoInput, idempotencyKey string) (Todo, error) {
+	var header http.Header
+	if idempotencyKey != "" {
+		header = http.Header{"Idempotency-Key": {idempotencyKey}}
+	}
+	var t Todo
+	err := c.call(ctx, http.MethodPost, apiPrefix+"/todos", nil, in, &t, header)
+	return t, err
+}
+
+// Update changes the fields of the patch in a todo.
+func (c *Client) Update(ctx context.Context, uuid string, p TodoPatch) (Todo, error) {
+	var t Todo
+	err := c.call(ctx, http.MethodPatch, apiPrefix+"/todos/"+url.PathEscape(uuid), nil, p, &t, nil)
+	return t, err
+}
+
+// Delete removes a todo.
+func (c *Client) Delete(ctx context.Context, uuid string) error {
+	return c.call(ctx, http.MethodDelete, apiPrefix+"/todos/"+url.PathEscape(uuid), nil, nil, nil, nil)
+}
+
+// Changes returns the changes to the todos after the cursor. A negative cursor returns
+// only the cursor of the latest change.
+func (c *Client) Changes(ctx context.Context, after int64) (TodoChanges, error) {
+	var changes TodoChanges
+	var query url.Values
+	if after >= 0 {
+		query = url.Values{"after": {strconv.FormatInt(after, 10)}}
+	}
+	err := c.call(ctx, http.MethodGet, apiPrefix+"/todos/changes", query, nil, &changes, nil)
+	return changes, err
+}
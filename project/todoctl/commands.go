@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Output formats of list and watch.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// exportFormats are the formats of export, by the media type todo-backend serves them as.
+var exportFormats = map[string]string{
+	"json": "application/json",
+	"yaml": "application/yaml",
+	"csv":  "text/csv",
+}
+
+func checkOutput(output string) error {
+	if output != outputTable && output != outputJSON {
+		return usageError(fmt.Errorf("unknown output %q, want %s or %s", output, outputTable, outputJSON))
+	}
+	return nil
+}
+
+func newListCmd(c *cli) *cobra.Command {
+	var output, state string
+	var open bool
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the todos",
+		Args:    args(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			todos, raw, err := client.List(cmd.Context())
+			if err != nil {
+				return err
+			}
+			var shown []Todo
+			shownRaw := []json.RawMessage{}
+			for i, t := range todos {
+				if (state != "" && t.State != state) || (open && t.Done) {
+					continue
+				}
+				shown = append(shown, t)
+				shownRaw = append(shownRaw, raw[i])
+			}
+			if output == outputJSON {
+				// As todo-backend sent them, with all fields
+				return writeJSON(cmd.OutOrStdout(), shownRaw)
+			}
+			return printTodos(cmd.OutOrStdout(), shown)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable, "output format: table or json")
+	cmd.Flags().StringVar(&state, "state", "", "only todos in the workflow state")
+	cmd.Flags().BoolVar(&open, "open", false, "only todos that are not done")
+	registerOutputCompletion(cmd)
+	return cmd
+}
+
+func newAddCmd(c *cli) *cobra.Command {
+	var due, rrule string
+	cmd := &cobra.Command{
+		Use:   "add DESCRIPTION...",
+		Short: "Add a todo and print its UUID",
+		Args:  args(cobra.MinimumNArgs(1)),
+		RunE: func(cmd *cobra.Command, a []string) error {
+			in := TodoInput{Description: strings.Join(a, " "), RRule: rrule}
+			if due != "" {
+				dueAt, err := parseDue(due)
+				if err != nil {
+					return err
+				}
+				in.DueAt = &dueAt
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			t, err := client.Create(cmd.Context(), in, "")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), t.UUID)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&due, "due", "", "due date, e.g. 2026-10-20 or 2026-10-20T17:00 (local time) or RFC 3339")
+	cmd.Flags().StringVar(&rrule, "rrule", "", "RFC 5545 recurrence rule, e.g. FREQ=WEEKLY;BYDAY=MO")
+	return cmd
+}
+
+func newEditCmd(c *cli) *cobra.Command {
+	var description, due, rrule string
+	cmd := &cobra.Command{
+		Use:               "edit ID",
+		Short:             "Change the description, due date or recurrence of a todo",
+		Args:              args(cobra.ExactArgs(1)),
+		ValidArgsFunction: completeIDs(c),
+		RunE: func(cmd *cobra.Command, a []string) error {
+			var p TodoPatch
+			flags := cmd.Flags()
+			if flags.Changed("description") {
+				p.Description = &description
+			}
+			if flags.Changed("due") {
+				dueAt, err := parseDue(due)
+				if err != nil {
+					return err
+				}
+				p.DueAt = &dueAt
+			}
+			if flags.Changed("rrule") {
+				p.RRule = &rrule
+			}
+			if p == (TodoPatch{}) {
+				return usageError(fmt.Errorf("nothing to change, give --description, --due or --rrule"))
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			ids, err := resolveIDs(cmd.Context(), client, a)
+			if err != nil {
+				return err
+			}
+			t, err := client.Update(cmd.Context(), ids[0], p)
+			if err != nil {
+				return err
+			}
+			return printTodos(cmd.OutOrStdout(), []Todo{t})
+		},
+	}
+	cmd.Flags().StringVar(&description, "description", "", "new description")
+	cmd.Flags().StringVar(&due, "due", "", "new due date")
+	cmd.Flags().StringVar(&rrule, "rrule", "", "new recurrence rule, empty to stop the recurrence")
+	return cmd
+}
+
+func newDoneCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "done ID...",
+		Short:             "Complete todos",
+		Args:              args(cobra.MinimumNArgs(1)),
+		ValidArgsFunction: completeIDs(c),
+		RunE: func(cmd *cobra.Command, a []string) error {
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			ids, err := resolveIDs(cmd.Context(), client, a)
+			if err != nil {
+				return err
+			}
+			done := true
+			var updated []Todo
+			for _, id := range ids {
+				t, err := client.Update(cmd.Context(), id, TodoPatch{Done: &done})
+				if err != nil {
+					return err
+				}
+				updated = append(updated, t)
+			}
+			return printTodos(cmd.OutOrStdout(), updated)
+		},
+	}
+}
+
+func newRmCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "rm ID...",
+		Short:             "Delete todos",
+		Args:              args(cobra.MinimumNArgs(1)),
+		ValidArgsFunction: completeIDs(c),
+		RunE: func(cmd *cobra.Command, a []string) error {
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			ids, err := resolveIDs(cmd.Context(), client, a)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := client.Delete(cmd.Context(), id); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func newExportCmd(c *cli) *cobra.Command {
+	var format, file string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write all todos as JSON, YAML or CSV",
+		Args:  args(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			mediaType, ok := exportFormats[format]
+			if !ok {
+				return usageError(fmt.Errorf("unknown format %q", format))
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			data, err := client.Export(cmd.Context(), mediaType)
+			if err != nil {
+				return err
+			}
+			if file == "" || file == "-" {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			return os.WriteFile(file, data, 0o600)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "json", "json, yaml or csv")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "file to write instead of stdout")
+	_ = cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{"json", "yaml", "csv"}, cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
+
+func newImportCmd(c *cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import [FILE]",
+		Short: "Add the todos of a JSON or CSV export",
+		Long: `Add the todos of a JSON or CSV file written by "todoctl export", or read from
+stdin without a file or with "-". Their description, due date, recurrence and whether
+they are done are kept; they get new UUIDs. Done todos with a recurrence are skipped:
+completing one would add its next occurrence, which the export already has.
+
+Every todo is sent with an idempotency key derived from it and its position in the
+file, so running an import
+again after a failure does not add the todos that made it the first time (for as
+long as todo-backend remembers the keys, by default a day).`,
+		Args: args(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, a []string) error {
+			var r io.Reader = cmd.InOrStdin()
+			if len(a) == 1 && a[0] != "-" {
+				f, err := os.Open(a[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			records, err := parseImport(data)
+			if err != nil {
+				return err
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			imported, skipped := 0, 0
+			for i, rec := range records {
+				if rec.Done && rec.RRule != "" {
+					skipped++
+					continue
+				}
+				t, err := client.Create(cmd.Context(), rec.TodoInput, importKey(i, rec))
+				if err != nil {
+					return fmt.Errorf("todo %d of %d: %w", i+1, len(records), err)
+				}
+				if rec.Done && !t.Done {
+					done := true
+					if _, err := client.Update(cmd.Context(), t.UUID, TodoPatch{Done: &done}); err != nil {
+						return fmt.Errorf("todo %d of %d: %w", i+1, len(records), err)
+					}
+				}
+				imported++
+			}
+			if skipped > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "imported %d todos, skipped %d done occurrences of recurring todos\n", imported, skipped)
+			} else {
+				fmt.Fprintf(cmd.ErrOrStderr(), "imported %d todos\n", imported)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
+
+// importKey returns the idempotency key of the i-th todo of an import. The position
+// keeps identical todos apart, as exports without UUIDs have nothing else to tell
+// them by.
+func importKey(i int, rec importRecord) string {
+	data, _ := json.Marshal(rec)
+	sum := sha256.Sum256(fmt.Appendf(data, "\n%d", i))
+	return "todoctl-import-" + hex.EncodeToString(sum[:16])
+}
+
+func newWatchCmd(c *cli) *cobra.Command {
+	var output string
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Print changes to the todos as they happen, until interrupted",
+		Long: `Print changes to the todos as they happen, until interrupted. todo-backend is
+asked for the changes after the last one seen every --interval, so none is skipped
+as long as todo-backend still holds it.`,
+		Args: args(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := checkOutput(output); err != nil {
+				return err
+			}
+			if interval <= 0 {
+				return usageError(fmt.Errorf("--interval must be positive"))
+			}
+			client, err := c.client()
+			if err != nil {
+				return err
+			}
+			return watch(cmd.Context(), client, interval, func(changes TodoChanges) error {
+				if changes.Missed {
+					fmt.Fprintln(cmd.ErrOrStderr(), "some changes are no longer known to todo-backend and were missed")
+				}
+				return printChanges(cmd.OutOrStdout(), output, changes)
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", outputTable, "output format: table (one line per change) or json (one object per change)")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "how often to ask for changes")
+	registerOutputCompletion(cmd)
+	return cmd
+}
+
+// watch calls fn with the changes to the todos made after it started, asking for them
+// every interval until ctx is done.
+func watch(ctx context.Context, client *Client, interval time.Duration, fn func(TodoChanges) error) error {
+	// The cursor of the feed decides what is new, not a clock
+	changes, err := client.Changes(ctx, -1)
+	if err != nil {
+		return err
+	}
+	after := changes.Next
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		for more := true; more; {
+			changes, err := client.Changes(ctx, after)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(changes); err != nil {
+				return err
+			}
+			after, more = changes.Next, changes.More
+		}
+	}
+}
+
+func newConfigCmd(c *cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show or change the settings in the config file",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "Show the settings in effect, with the token masked",
+		Args:  args(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := c.config()
+			if err != nil {
+				return err
+			}
+			if cfg.Token != "" {
+				cfg.Token = "********"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "config: %s\nurl: %s\ntoken: %s\ntenant: %s\n", c.configPath, cfg.URL, cfg.Token, cfg.Tenant)
+			return nil
+		},
+	}, &cobra.Command{
+		Use:       "set KEY VALUE",
+		Short:     "Set url, token or tenant in the config file; an empty value removes it",
+		Args:      args(cobra.ExactArgs(2)),
+		ValidArgs: configKeyNames(),
+		RunE: func(_ *cobra.Command, a []string) error {
+			field, ok := configKeys[a[0]]
+			if !ok {
+				return usageError(fmt.Errorf("unknown setting %q, want one of %s", a[0], strings.Join(configKeyNames(), ", ")))
+			}
+			cfg, err := readConfigFile(c.configPath)
+			if err != nil {
+				return err
+			}
+			*field(&cfg) = a[1]
+			return saveConfig(c.configPath, cfg)
+		},
+	})
+	return cmd
+}
+
+// resolveIDs returns the UUIDs of the todos named by the arguments, full UUIDs or
+// unique prefixes of them.
+func resolveIDs(ctx context.Context, client *Client, a []string) ([]string, error) {
+	if !slices.ContainsFunc(a, func(id string) bool { return len(id) != uuidLength }) {
+		return a, nil
+	}
+	todos, _, err := client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(a))
+	for i, prefix := range a {
+		if len(prefix) == uuidLength {
+			ids[i] = prefix
+			continue
+		}
+		var matches []string
+		for _, t := range todos {
+			if prefix != "" && strings.HasPrefix(t.UUID, strings.ToLower(prefix)) {
+				matches = append(matches, t.UUID)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, notFoundError("no todo with id %q", prefix)
+		case 1:
+			ids[i] = matches[0]
+		default:
+			return nil, validationError("id %q is ambiguous, it matches %d todos", prefix, len(matches))
+		}
+	}
+	return ids, nil
+}
+
+// completeIDs completes the UUIDs of the todos, described by their description.
+func completeIDs(c *cli) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, a []string, toComplete string) ([]cobra.Completion, cobra.ShellCompDirective) {
+		client, err := c.client()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		todos, _, err := client.List(cmd.Context())
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var completions []cobra.Completion
+		for _, t := range todos {
+			if strings.HasPrefix(t.UUID, toComplete) && !slices.Contains(a, t.UUID) {
+				completions = append(completions, cobra.CompletionWithDesc(t.UUID, t.Description))
+			}
+		}
+		return completions, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func registerOutputCompletion(cmd *cobra.Command) {
+	_ = cmd.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputTable, outputJSON}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// parseDue reads a due date in RFC 3339, or as a date with an optional time of day in
+// local time.
+func parseDue(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, validationError("invalid due date %q, want e.g. 2026-10-20, 2026-10-20T17:00 or RFC 3339", s)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The settings are read from the config file, then from the environment, then from the
+// flags, later ones winning.
+
+const defaultURL = "http://localhost:8080"
+
+// Config holds the settings of todoctl.
+type Config struct {
+	// URL is the base URL of todo-backend, e.g. https://todo.example.com.
+	URL string `yaml:"url,omitempty"`
+	// Token is sent as a bearer token, for the authenticating proxy in front of todo-backend.
+	Token string `yaml:"token,omitempty"`
+	// Tenant names the tenant when todo-backend serves several.
+	Tenant string `yaml:"tenant,omitempty"`
+}
+
+// configKeys are the settings `todoctl config set` knows, by name.
+var configKeys = map[string]func(c *Config) *string{
+	"url":    func(c *Config) *string { return &c.URL },
+	"token":  func(c *Config) *string { return &c.Token },
+	"tenant": func(c *Config) *string { return &c.Tenant },
+}
+
+// configKeyNames returns the names of the settings in order.
+func configKeyNames() []string {
+	names := make([]string, 0, len(configKeys))
+	for name := range configKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// defaultConfigPath returns the config file in the user's config directory, unless
+// TODOCTL_CONFIG names another.
+func defaultConfigPath() string {
+	if path := os.Getenv("TODOCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(".todoctl", "config.yaml")
+	}
+	return filepath.Join(dir, "todoctl", "config.yaml")
+}
+
+// readConfigFile reads the config file, if there is one.
+func readConfigFile(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// loadConfig reads the config file and the environment, TODOCTL_URL, TODOCTL_TOKEN and
+// TODOCTL_TENANT.
+func loadConfig(path string) (Config, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	for name, field := range configKeys {
+		if value := os.Getenv("TODOCTL_" + strings.ToUpper(name)); value != "" {
+			*field(&cfg) = value
+		}
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the config file, readable by the user only as it holds the token.
+func saveConfig(path string, cfg Config) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes of todoctl, so that scripts can tell failures apart.
+const (
+	exitOK         = 0
+	exitError      = 1 // any failure not listed below
+	exitUsage      = 2 // wrong command line
+	exitNotFound   = 3 // no such todo
+	exitValidation = 4 // todo-backend or todoctl rejected the input
+	exitServer     = 5 // todo-backend failed or could not be reached
+)
+
+// codedError is an error of todoctl itself with its exit code.
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+func (e *codedError) ExitCode() int { return e.code }
+
+func usageError(err error) error {
+	return &codedError{exitUsage, err}
+}
+
+func notFoundError(format string, args ...any) error {
+	return &codedError{exitNotFound, fmt.Errorf(format, args...)}
+}
+
+func validationError(format string, args ...any) error {
+	return &codedError{exitValidation, fmt.Errorf(format, args...)}
+}
+
+// unreachableError is a request that did not get a response.
+type unreachableError struct {
+	err error
+}
+
+func (e *unreachableError) Error() string { return "cannot reach todo-backend: " + e.err.Error() }
+func (e *unreachableError) Unwrap() error { return e.err }
+func (e *unreachableError) ExitCode() int { return exitServer }
+
+// exitCode returns the exit code for the error returned by a command.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+	return exitError
+}
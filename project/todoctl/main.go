@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+// todoctl is a command-line client of the REST API of todo-backend.
+
+var (
+	// COMMIT_SHA and COMMIT_TAG are set by the build system
+	COMMIT_SHA string
+	COMMIT_TAG string
+)
+
+func main() {
+	// Interrupting watch ends it normally
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line and returns the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := newRootCmd()
+	cmd.SetArgs(args)
+	cmd.SetIn(stdin)
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+	err := cmd.ExecuteContext(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, "todoctl:", err)
+	}
+	return exitCode(err)
+}
+
+// cli holds the settings given on the command line.
+type cli struct {
+	configPath string
+	// flags overrides the config file and the environment where set
+	flags Config
+}
+
+// config returns the settings of the command.
+func (c *cli) config() (Config, error) {
+	cfg, err := loadConfig(c.configPath)
+	if err != nil {
+		return Config{}, err
+	}
+	for _, field := range configKeys {
+		if value := *field(&c.flags); value != "" {
+			*field(&cfg) = value
+		}
+	}
+	return cfg, nil
+}
+
+// client returns a client of the todo-backend of the settings.
+func (c *cli) client() (*Client, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(cfg), nil
+}
+
+func newRootCmd() *cobra.Command {
+	c := &cli{}
+	root := &cobra.Command{
+		Use:   "todoctl",
+		Short: "Manage the todos of todo-backend from the terminal",
+		Long: `todoctl manages the todos of todo-backend over its REST API.
+
+Todos are named by their UUID or by a prefix of it that is unique, such as the
+short ids that "todoctl list" shows.
+
+Exit codes: 0 success, 1 other failures, 2 wrong command line, 3 no such todo,
+4 invalid input, 5 todo-backend failed or could not be reached.`,
+		Version:       fmt.Sprintf("%s (%s)", COMMIT_TAG, COMMIT_SHA),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		// Makes unknown commands usage errors
+		Args: args(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, _ []string) error { return cmd.Help() },
+	}
+	root.SetFlagErrorFunc(func(_ *cobra.Command, err error) error { return usageError(err) })
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&c.configPath, "config", defaultConfigPath(), "config file")
+	flags.StringVar(&c.flags.URL, "url", "", "base URL of todo-backend (default from the config, TODOCTL_URL or "+defaultURL+")")
+	flags.StringVar(&c.flags.Token, "token", "", "bearer token for the authenticating proxy (default from the config or TODOCTL_TOKEN)")
+	flags.StringVar(&c.flags.Tenant, "tenant", "", "tenant, when todo-backend serves several (default from the config or TODOCTL_TENANT)")
+
+	root.AddCommand(
+		newListCmd(c),
+		newAddCmd(c),
+		newEditCmd(c),
+		newDoneCmd(c),
+		newRmCmd(c),
+		newImportCmd(c),
+		newExportCmd(c),
+		newWatchCmd(c),
+		newConfigCmd(c),
+	)
+	return root
+}
+
+// args wraps a cobra argument check so that its errors are usage errors.
+func args(check cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, a []string) error {
+		if err := check(cmd, a); err != nil {
+			return usageError(err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend serves the part of the REST API of todo-backend that todoctl uses.
+type fakeBackend struct {
+	mu    sync.Mutex
+	todos []Todo
+	keys  map[string]Todo
+	next  int
+	// changes is the feed of GET /todos/changes, served two at a time; watch starts
+	// after the change numbered latest
+	changes []TodoChangeEvent
+	latest  int64
+	headers http.Header
+}
+
+func newFakeBackend(t *testing.T, todos ...Todo) (*fakeBackend, string) {
+	t.Helper()
+	b := &fakeBackend{todos: todos, keys: map[string]Todo{}}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	return b, srv.URL
+}
+
+func (b *fakeBackend) problem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{Title: http.StatusText(status), Status: status, Detail: detail})
+}
+
+func (b *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.headers = r.Header.Clone()
+
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	switch {
+	case r.URL.Path == "/healthz":
+		w.WriteHeader(http.StatusOK)
+	case path == "/todos/changes" && r.Method == http.MethodGet:
+		page := TodoChanges{Changes: []TodoChangeEvent{}, Next: b.latest}
+		if r.URL.Query().Has("after") {
+			after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+			page.Next = after
+			for _, ch := range b.changes {
+				if ch.Seq <= after {
+					continue
+				}
+				if len(page.Changes) == 2 {
+					page.More = true
+					break
+				}
+				page.Changes = append(page.Changes, ch)
+				page.Next = ch.Seq
+			}
+		}
+		json.NewEncoder(w).Encode(page)
+	case path == "/todos" && r.Method == http.MethodGet:
+		if r.Header.Get("Accept") == "text/csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			fmt.Fprintln(w, "uuid,description,done")
+			for _, t := range b.todos {
+				fmt.Fprintf(w, "%s,%s,%t\n", t.UUID, t.Description, t.Done)
+			}
+			return
+		}
+		json.NewEncoder(w).Encode(b.todos)
+	case path == "/todos" && r.Method == http.MethodPost:
+		var in TodoInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.Description == "" {
+			b.problem(w, http.StatusBadRequest, "description is required")
+			return
+		}
+		if t, ok := b.keys[r.Header.Get("Idempotency-Key")]; ok {
+			json.NewEncoder(w).Encode(t)
+			return
+		}
+		b.next++
+		t := Todo{UUID: fmt.Sprintf("%08d-0000-4000-8000-000000000000", b.next), Description: in.Description, State: "todo", DueAt: in.DueAt, RRule: in.RRule}
+		b.todos = append(b.todos, t)
+		if key := r.Header.Get("Idempotency-Key"); key != "" {
+			b.keys[key] = t
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+	case strings.HasPrefix(path, "/todos/"):
+		uuid := strings.TrimPrefix(path, "/todos/")
+		i := b.index(uuid)
+		if i < 0 {
+			b.problem(w, http.StatusNotFound, "todo not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodPatch:
+			var p TodoPatch
+			json.NewDecoder(r.Body).Decode(&p)
+			if p.Description != nil {
+				b.todos[i].Description = *p.Description
+			}
+			if p.Done != nil {
+				b.todos[i].Done = *p.Done
+				b.todos[i].State = "done"
+			}
+			if p.DueAt != nil {
+				b.todos[i].DueAt = p.DueAt
+			}
+			json.NewEncoder(w).Encode(b.todos[i])
+		case http.MethodDelete:
+			b.todos = append(b.todos[:i], b.todos[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		b.problem(w, http.StatusInternalServerError, "unexpected "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (b *fakeBackend) index(uuid string) int {
+	for i, t := range b.todos {
+		if t.UUID == uuid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *fakeBackend) snapshot() []Todo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Todo(nil), b.todos...)
+}
+
+// runCLI runs todoctl against the URL with an empty config and returns its exit code
+// and output.
+func runCLI(t *testing.T, url, stdin string, a ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv("TODOCTL_URL", "")
+	t.Setenv("TODOCTL_TOKEN", "")
+	t.Setenv("TODOCTL_TENANT", "")
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), append([]string{"--config", config, "--url", url}, a...), strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+var (
+	milk  = Todo{UUID: "a1b2c3d4-0000-4000-8000-000000000001", Description: "Buy milk", State: "todo"}
+	taxes = Todo{UUID: "a1f00000-0000-4000-8000-000000000002", Description: "File taxes", State: "done", Done: true}
+)
+
+func TestList(t *testing.T) {
+	_, url := newFakeBackend(t, milk, taxes)
+
+	code, out, _ := runCLI(t, url, "", "list")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, out, "ID")
+	assert.Contains(t, out, "a1b2c3d4")
+	assert.Contains(t, out, "Buy milk")
+	assert.Contains(t, out, "File taxes")
+
+	code, out, _ = runCLI(t, url, "", "list", "--open", "-o", "json")
+	require.Equal(t, exitOK, code)
+	var todos []Todo
+	require.NoError(t, json.Unmarshal([]byte(out), &todos))
+	assert.Equal(t, []Todo{milk}, todos)
+}
+
+func TestAddEditDoneRm(t *testing.T) {
+	b, url := newFakeBackend(t, milk)
+
+	code, out, _ := runCLI(t, url, "", "add", "Water", "the", "plants", "--due", "2026-10-20T17:00")
+	require.Equal(t, exitOK, code)
+	uuid := strings.TrimSpace(out)
+	todos := b.snapshot()
+	require.Len(t, todos, 2)
+	assert.Equal(t, uuid, todos[1].UUID)
+	assert.Equal(t, "Water the plants", todos[1].Description)
+	require.NotNil(t, todos[1].DueAt)
+	assert.True(t, todos[1].DueAt.Equal(time.Date(2026, 10, 20, 17, 0, 0, 0, time.Local)))
+
+	code, _, _ = runCLI(t, url, "", "edit", uuid[:8], "--description", "Water the garden")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "Water the garden", b.snapshot()[1].Description)
+
+	code, _, _ = runCLI(t, url, "", "done", "a1b2", uuid)
+	require.Equal(t, exitOK, code)
+	for _, todo := range b.snapshot() {
+		assert.True(t, todo.Done, todo.Description)
+	}
+
+	code, _, _ = runCLI(t, url, "", "rm", uuid)
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, []string{milk.UUID}, uuids(b.snapshot()))
+}
+
+func uuids(todos []Todo) []string {
+	var ids []string
+	for _, t := range todos {
+		ids = append(ids, t.UUID)
+	}
+	return ids
+}
+
+func TestExitCodes(t *testing.T) {
+	_, url := newFakeBackend(t, milk, taxes)
+
+	tests := []struct {
+		name string
+		url  string
+		args []string
+		want int
+	}{
+		{"unknown command", url, []string{"frobnicate"}, exitUsage},
+		{"unknown flag", url, []string{"list", "--frobnicate"}, exitUsage},
+		{"missing argument", url, []string{"done"}, exitUsage},
+		{"nothing to edit", url, []string{"edit", milk.UUID}, exitUsage},
+		{"unknown output", url, []string{"list", "-o", "xml"}, exitUsage},
+		{"no such prefix", url, []string{"done", "ffff"}, exitNotFound},
+		{"no such uuid", url, []string{"rm", "ffffffff-0000-4000-8000-000000000000"}, exitNotFound},
+		{"ambiguous prefix", url, []string{"rm", "a1"}, exitValidation},
+		{"invalid due date", url, []string{"add", "x", "--due", "tomorrow"}, exitValidation},
+		{"rejected by the server", url, []string{"add", ""}, exitValidation},
+		{"server error", url + "/nowhere", []string{"list"}, exitServer},
+		{"unreachable", "http://127.0.0.1:1", []string{"list"}, exitServer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCLI(t, tt.url, "", tt.args...)
+			assert.Equal(t, tt.want, code, stderr)
+			assert.True(t, strings.HasPrefix(stderr, "todoctl: "), stderr)
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	_, url := newFakeBackend(t, milk, taxes)
+	file := filepath.Join(t.TempDir(), "todos.json")
+	code, _, _ := runCLI(t, url, "", "export", "-f", file)
+	require.Equal(t, exitOK, code)
+
+	code, out, _ := runCLI(t, url, "", "export", "--format", "csv")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "uuid,description,done\n"+milk.UUID+",Buy milk,false\n"+taxes.UUID+",File taxes,true\n", out)
+
+	b, otherURL := newFakeBackend(t)
+	code, _, stderr := runCLI(t, otherURL, "", "import", file)
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "imported 2 todos\n", stderr)
+	// Importing again does not add the todos twice
+	code, _, _ = runCLI(t, otherURL, "", "import", file)
+	require.Equal(t, exitOK, code)
+
+	todos := b.snapshot()
+	require.Len(t, todos, 2)
+	assert.Equal(t, "Buy milk", todos[0].Description)
+	assert.False(t, todos[0].Done)
+	assert.Equal(t, "File taxes", todos[1].Description)
+	assert.True(t, todos[1].Done)
+	assert.NotEqual(t, taxes.UUID, todos[1].UUID)
+
+	// The same todos exported as CSV are recognised too
+	code, _, _ = runCLI(t, otherURL, out, "import", "-")
+	require.Equal(t, exitOK, code)
+	assert.Len(t, b.snapshot(), 2)
+	code, _, _ = runCLI(t, otherURL, "description\nWater the plants\n", "import")
+	require.Equal(t, exitOK, code)
+	assert.Len(t, b.snapshot(), 3)
+
+	// Identical rows are different todos
+	code, _, _ = runCLI(t, otherURL, "description\nWater the plants\nWater the plants\n", "import")
+	require.Equal(t, exitOK, code)
+	assert.Len(t, b.snapshot(), 4)
+}
+
+func TestImportSkipsDoneRecurringTodos(t *testing.T) {
+	b, url := newFakeBackend(t)
+	code, _, stderr := runCLI(t, url, "description,rrule,done\nPay rent,FREQ=MONTHLY,true\nPay rent,FREQ=MONTHLY,false\n", "import")
+	require.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "imported 1 todos, skipped 1 done occurrences of recurring todos\n", stderr)
+
+	todos := b.snapshot()
+	require.Len(t, todos, 1)
+	assert.Equal(t, "FREQ=MONTHLY", todos[0].RRule)
+	assert.False(t, todos[0].Done)
+}
+
+func TestParseImport(t *testing.T) {
+	due := time.Date(2026, 10, 20, 17, 0, 0, 0, time.UTC)
+	records, err := parseImport([]byte("description,due_at,rrule,done\nPay rent,2026-10-20T17:00:00Z,FREQ=MONTHLY,\nCall mom,,,true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []importRecord{
+		{TodoInput: TodoInput{Description: "Pay rent", DueAt: &due, RRule: "FREQ=MONTHLY"}},
+		{TodoInput: TodoInput{Description: "Call mom"}, Done: true},
+	}, records)
+
+	for _, data := range []string{"[{", "title\nx\n", "description,done\nx,maybe\n", "description,due_at\nx,soon\n"} {
+		_, err := parseImport([]byte(data))
+		assert.Equal(t, exitValidation, exitCode(err), data)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	b, url := newFakeBackend(t)
+	at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	done := milk
+	done.Done = true
+	b.changes = []TodoChangeEvent{
+		{Seq: 1, At: at, Type: "created", Todo: taxes},
+		{Seq: 2, At: at, Type: "created", Todo: milk},
+		{Seq: 3, At: at.Add(time.Second), Type: "updated", Todo: done, Fields: []string{"done"}},
+		{Seq: 4, At: at.Add(time.Second), Type: "deleted", Todo: taxes},
+	}
+	b.latest = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var events []WatchEvent
+	var out bytes.Buffer
+	err := watch(ctx, NewClient(Config{URL: url}), time.Millisecond, func(changes TodoChanges) error {
+		require.NoError(t, printChanges(&out, outputJSON, changes))
+		if changes.Next == 4 {
+			cancel()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var ev WatchEvent
+		require.NoError(t, dec.Decode(&ev))
+		events = append(events, ev)
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, "added", events[0].Type)
+	assert.Equal(t, "changed", events[1].Type)
+	assert.Equal(t, []string{"done"}, events[1].Fields)
+	assert.True(t, events[1].Todo.Done)
+	assert.Equal(t, "removed", events[2].Type)
+	assert.Equal(t, taxes.UUID, events[2].Todo.UUID)
+}
+
+func TestConfig(t *testing.T) {
+	b, url := newFakeBackend(t, milk)
+	config := filepath.Join(t.TempDir(), "todoctl", "config.yaml")
+	t.Setenv("TODOCTL_CONFIG", config)
+	t.Setenv("TODOCTL_URL", "")
+	t.Setenv("TODOCTL_TOKEN", "")
+	t.Setenv("TODOCTL_TENANT", "")
+	cli := func(a ...string) (int, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), a, strings.NewReader(""), &stdout, &stderr)
+		return code, stdout.String() + stderr.String()
+	}
+
+	for _, kv := range [][2]string{{"url", url}, {"token", "s3cret"}, {"tenant", "acme"}} {
+		code, out := cli("config", "set", kv[0], kv[1])
+		require.Equal(t, exitOK, code, out)
+	}
+	info, err := os.Stat(config)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	code, out := cli("config", "show")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, out, "url: "+url)
+	assert.Contains(t, out, "tenant: acme")
+	assert.NotContains(t, out, "s3cret")
+
+	code, _ = cli("list")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "Bearer s3cret", b.headers.Get("Authorization"))
+	assert.Equal(t, "acme", b.headers.Get("X-Tenant-ID"))
+
+	// The environment overrides the file, and flags the environment
+	t.Setenv("TODOCTL_TENANT", "globex")
+	code, _ = cli("list")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "globex", b.headers.Get("X-Tenant-ID"))
+	code, _ = cli("list", "--tenant", "initech")
+	require.Equal(t, exitOK, code)
+	assert.Equal(t, "initech", b.headers.Get("X-Tenant-ID"))
+
+	code, _ = cli("config", "set", "colour", "blue")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestCompletion(t *testing.T) {
+	_, url := newFakeBackend(t, milk, taxes)
+	code, out, _ := runCLI(t, url, "", "__complete", "done", "a1b")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, out, milk.UUID+"\tBuy milk")
+	assert.NotContains(t, out, taxes.UUID)
+
+	code, out, _ = runCLI(t, url, "", "completion", "bash")
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, out, "todoctl")
+}
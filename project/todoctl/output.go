@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// uuidLength is the length of a UUID in its text form; shorter ids are prefixes.
+const uuidLength = 36
+
+// shortIDLength is how much of the UUIDs the table shows.
+const shortIDLength = 8
+
+const dueLayout = "2006-01-02 15:04"
+
+func shortID(uuid string) string {
+	return uuid[:min(len(uuid), shortIDLength)]
+}
+
+// printTodos writes the todos as a table, with the due dates in local time.
+func printTodos(w io.Writer, todos []Todo) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tDUE\tDESCRIPTION")
+	for _, t := range todos {
+		due := ""
+		if t.DueAt != nil {
+			due = t.DueAt.Local().Format(dueLayout)
+			if t.Overdue {
+				due += " (overdue)"
+			}
+		}
+		if t.RRule != "" {
+			due += " ↻"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", shortID(t.UUID), t.State, strings.TrimSpace(due), t.Description)
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// WatchEvent is a change printed by watch -o json.
+type WatchEvent struct {
+	Type   string    `json:"type"` // added, changed or removed
+	At     time.Time `json:"at"`
+	Todo   Todo      `json:"todo"` // the todo after the change, or before its removal
+	Fields []string  `json:"fields,omitempty"`
+}
+
+// watchEventTypes names the types of the change feed the way watch prints them.
+var watchEventTypes = map[string]string{"created": "added", "updated": "changed", "deleted": "removed"}
+
+// printChanges writes the changes, one line or, for JSON output, one object per change.
+func printChanges(w io.Writer, output string, changes TodoChanges) error {
+	events := make([]WatchEvent, 0, len(changes.Changes))
+	for _, ch := range changes.Changes {
+		typ := watchEventTypes[ch.Type]
+		if typ == "" {
+			typ = ch.Type
+		}
+		events = append(events, WatchEvent{Type: typ, At: ch.At, Todo: ch.Todo, Fields: ch.Fields})
+	}
+	enc := json.NewEncoder(w)
+	for _, ev := range events {
+		if output == outputJSON {
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+			continue
+		}
+		line := fmt.Sprintf("%s %-7s %s %s", ev.At.Local().Format(time.TimeOnly), ev.Type, shortID(ev.Todo.UUID), ev.Todo.Description)
+		if len(ev.Fields) > 0 {
+			line += " (" + strings.Join(ev.Fields, ", ") + ")"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importRecord is a todo read from an export.
+type importRecord struct {
+	TodoInput
+	// UUID is the UUID of the exported todo; the imported one gets a new one
+	UUID string `json:"uuid,omitempty"`
+	Done bool   `json:"done,omitempty"`
+}
+
+// parseImport reads the todos of a JSON or CSV export.
+func parseImport(data []byte) ([]importRecord, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	if trimmed[0] == '[' {
+		var records []importRecord
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, validationError("cannot read the JSON: %v", err)
+		}
+		return records, nil
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(trimmed)).ReadAll()
+	if err != nil {
+		return nil, validationError("cannot read the CSV: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	if _, ok := columns["description"]; !ok {
+		return nil, validationError("the CSV has no description column")
+	}
+	cell := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	records := make([]importRecord, 0, len(rows)-1)
+	for n, row := range rows[1:] {
+		rec := importRecord{
+			TodoInput: TodoInput{Description: cell(row, "description"), RRule: cell(row, "rrule")},
+			UUID:      cell(row, "uuid"),
+		}
+		if due := cell(row, "due_at"); due != "" {
+			dueAt, err := time.Parse(time.RFC3339, due)
+			if err != nil {
+				return nil, validationError("row %d: invalid due_at %q", n+2, due)
+			}
+			rec.DueAt = &dueAt
+		}
+		if done := cell(row, "done"); done != "" {
+			if rec.Done, err = strconv.ParseBool(done); err != nil {
+				return nil, validationError("row %d: invalid done %q", n+2, done)
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}